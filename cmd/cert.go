@@ -4,6 +4,7 @@ package cmd
 import (
 	"fmt"
 	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 
@@ -38,6 +39,17 @@ var CertCmd = &cobra.Command{
 			Must(err)
 		}
 
+		w, err := newWatcher(c, cmd)
+		if err != nil {
+			Must(err)
+		}
+		if w != nil {
+			if err := w.Run(stopSignal()); err != nil {
+				Must(err)
+			}
+			return
+		}
+
 		if err := c.RunCert(); err != nil {
 			Must(err)
 		}
@@ -49,18 +61,30 @@ func init() {
 }
 
 func InitCertCmdFlags(cmd *cobra.Command) {
-	cmd.PersistentFlags().Int(cert.FlagKeyBitSize, 2048, "Bit size used for generating key. [int]")
+	cmd.PersistentFlags().Int(cert.FlagKeyBitSize, 2048, "Bit size used for generating key, 256, 384 or 521 for ecdsa. [int] (default 2048 for rsa, 256 for ecdsa and ed25519)")
 	cmd.Flag(cert.FlagKeyBitSize).Shorthand = "b"
 
-	cmd.PersistentFlags().String(cert.FlagKeyType, "RSA", "Type of key to generate. [string]")
+	cmd.PersistentFlags().String(cert.FlagKeyType, cert.KeyTypeRSA, "Type of key to generate: rsa, ecdsa or ed25519. [string]")
 	cmd.Flag(cert.FlagKeyType).Shorthand = "t"
 
+	cmd.PersistentFlags().String(cert.FlagKeyFormat, "", "Encoding of the key file: pkcs1 (rsa), pkcs8 or sec1 (ecdsa). [string] (default pkcs1 for rsa, sec1 for ecdsa, pkcs8 for ed25519)")
+
 	cmd.PersistentFlags().StringSlice(cert.FlagIpSans, []string{}, "IP sans. [[]string] (default none)")
 	cmd.Flag(cert.FlagIpSans).Shorthand = "i"
 
 	cmd.PersistentFlags().StringSlice(cert.FlagSanHosts, []string{}, "Host Sans. [[]string] (default none)")
 	cmd.Flag(cert.FlagSanHosts).Shorthand = "s"
 
+	cmd.PersistentFlags().Bool(cert.FlagAutoIPSans, false, "Add the global unicast addresses of the network interfaces to the IP sans. [bool]")
+	cmd.PersistentFlags().StringSlice(cert.FlagAutoIPSansInclude, []string{}, "Only add discovered IP sans within these CIDRs. [[]string] (default all)")
+	cmd.PersistentFlags().StringSlice(cert.FlagAutoIPSansExclude, []string{}, "Don't add discovered IP sans within these CIDRs. [[]string] (default none)")
+	cmd.PersistentFlags().Bool(cert.FlagAutoDNSSans, false, "Add the hostname and FQDN of this host to the host sans. [bool]")
+
+	cmd.PersistentFlags().StringSlice(cert.FlagURISans, []string{}, "URI sans, such as spiffe://cluster.local/ns/default/sa/web. [[]string] (default none)")
+	cmd.PersistentFlags().StringSlice(cert.FlagOtherSans, []string{}, "Other sans as <oid>;UTF8:<value>. [[]string] (default none)")
+	cmd.PersistentFlags().Bool(cert.FlagExcludeCNFromSans, false, "Exclude the common name from the sans of the certificate. [bool]")
+	cmd.PersistentFlags().Duration(cert.FlagTTL, 0, "Requested lifetime of the certificate, at most the max ttl of the role. [duration] (default ttl of the role)")
+
 	cmd.PersistentFlags().StringSlice(cert.FlagOrganisation, []string{}, "Organisation(s) - i.e. kubernetes groups. [[]string] (default none)")
 
 	cmd.Flag(cert.FlagOrganisation).Shorthand = "n"
@@ -71,23 +95,54 @@ func InitCertCmdFlags(cmd *cobra.Command) {
 	cmd.PersistentFlags().String(cert.FlagGroup, "", "Group of created file/directories. Gid value also accepted. [string] (default <current user-group)")
 	cmd.Flag(cert.FlagGroup).Shorthand = "g"
 
+	cmd.PersistentFlags().String(cert.FlagMode, cert.ModeSign, "Key generation mode: sign (generate the key locally and sign a CSR) or issue (vault generates the key with the issue path of the role). [string]")
+
+	cmd.PersistentFlags().String(cert.FlagCertFile, "", "Path of the certificate, relative to the destination directory. [string] (default <destination>.pem)")
+	cmd.PersistentFlags().String(cert.FlagKeyFile, "", "Path of the key, relative to the destination directory. [string] (default <destination>-key.pem)")
+	cmd.PersistentFlags().String(cert.FlagCAFile, "", "Path of the CA certificate, relative to the destination directory. [string] (default <destination>-ca.pem)")
+
+	cmd.PersistentFlags().StringSlice(cert.FlagOutputFormats, []string{}, "Additional files written for each certificate: fullchain, combined (key, certificate and chain), der, pkcs12, jks or truststore, each as format[=path[:mode]]. [[]string] (default none)")
+	cmd.PersistentFlags().String(cert.FlagKeystorePasswordFile, "", "File containing the password of the pkcs12, jks and truststore outputs. [string] (default generated into <destination>-keystore-password)")
+
+	cmd.PersistentFlags().Duration(cert.FlagRenewBefore, cert.DefaultRenewBefore, "Request a new certificate if the existing certificate expires within this duration. [duration]")
+
+	cmd.PersistentFlags().Bool(cert.FlagWatch, false, "Keep running, renewing the token and re-issuing the certificate at a fraction of its lifetime. [bool]")
+	cmd.PersistentFlags().Float64(cert.FlagCertRenewFraction, cert.DefaultCertRenewFraction, "Fraction of the certificate's lifetime after which --watch re-issues it. [float]")
+	cmd.PersistentFlags().String(cert.FlagReloadExec, "", "Command run with /bin/sh after --watch rotated the certificate. [string]")
+	cmd.PersistentFlags().String(cert.FlagReloadPidFile, "", "Pid file of a process signalled after --watch rotated the certificate. [string]")
+	cmd.PersistentFlags().String(cert.FlagReloadSignal, "HUP", "Signal sent to the process of --reload-pidfile. [string]")
+	cmd.PersistentFlags().String(cert.FlagReloadSystemdUnit, "", "Systemd unit restarted after --watch rotated the certificate. [string]")
+
 	instanceTokenFlags(cmd)
 
 	RootCmd.AddCommand(cmd)
 }
 
 func setFlagsCert(c *cert.Cert, cmd *cobra.Command, args []string) error {
+	vStr, err := cmd.PersistentFlags().GetString(cert.FlagKeyType)
+	if err != nil {
+		return fmt.Errorf("error parsing %s [string] '%s': %v", cert.FlagKeyType, vStr, err)
+	}
+	keyType, err := cert.NormaliseKeyType(vStr)
+	if err != nil {
+		return err
+	}
+	c.SetKeyType(keyType)
+
 	vInt, err := cmd.PersistentFlags().GetInt(cert.FlagKeyBitSize)
 	if err != nil {
 		return fmt.Errorf("error parsing %s [int] '%d': %v", cert.FlagKeyBitSize, vInt, err)
 	}
+	if !cmd.PersistentFlags().Changed(cert.FlagKeyBitSize) {
+		vInt = cert.DefaultKeyBitSize(keyType)
+	}
 	c.SetBitSize(vInt)
 
-	vStr, err := cmd.PersistentFlags().GetString(cert.FlagKeyType)
+	vStr, err = cmd.PersistentFlags().GetString(cert.FlagKeyFormat)
 	if err != nil {
-		return fmt.Errorf("error parsing %s [string] '%s': %v", cert.FlagKeyType, vStr, err)
+		return fmt.Errorf("error parsing %s [string] '%s': %v", cert.FlagKeyFormat, vStr, err)
 	}
-	c.SetKeyType(vStr)
+	c.SetKeyFormat(strings.ToLower(vStr))
 
 	vStr, err = cmd.PersistentFlags().GetString(cert.FlagOwner)
 	if err != nil {
@@ -113,12 +168,132 @@ func setFlagsCert(c *cert.Cert, cmd *cobra.Command, args []string) error {
 	}
 	c.SetSanHosts(vSli)
 
+	vBool, err := cmd.PersistentFlags().GetBool(cert.FlagAutoIPSans)
+	if err != nil {
+		return fmt.Errorf("error parsing %s [bool] '%t': %v", cert.FlagAutoIPSans, vBool, err)
+	}
+	c.SetAutoIPSans(vBool)
+
+	vSli, err = cmd.PersistentFlags().GetStringSlice(cert.FlagAutoIPSansInclude)
+	if err != nil {
+		return fmt.Errorf("error parsing %s [[]string] '%s': %v", cert.FlagAutoIPSansInclude, vSli, err)
+	}
+	include, err := cert.ParseCIDRs(vSli)
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %v", cert.FlagAutoIPSansInclude, err)
+	}
+	c.SetAutoIPSansInclude(include)
+
+	vSli, err = cmd.PersistentFlags().GetStringSlice(cert.FlagAutoIPSansExclude)
+	if err != nil {
+		return fmt.Errorf("error parsing %s [[]string] '%s': %v", cert.FlagAutoIPSansExclude, vSli, err)
+	}
+	exclude, err := cert.ParseCIDRs(vSli)
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %v", cert.FlagAutoIPSansExclude, err)
+	}
+	c.SetAutoIPSansExclude(exclude)
+
+	vBool, err = cmd.PersistentFlags().GetBool(cert.FlagAutoDNSSans)
+	if err != nil {
+		return fmt.Errorf("error parsing %s [bool] '%t': %v", cert.FlagAutoDNSSans, vBool, err)
+	}
+	c.SetAutoDNSSans(vBool)
+
+	vSli, err = cmd.PersistentFlags().GetStringSlice(cert.FlagURISans)
+	if err != nil {
+		return fmt.Errorf("error parsing %s [[]string] '%s': %v", cert.FlagURISans, vSli, err)
+	}
+	c.SetURISans(vSli)
+
+	vSli, err = cmd.PersistentFlags().GetStringSlice(cert.FlagOtherSans)
+	if err != nil {
+		return fmt.Errorf("error parsing %s [[]string] '%s': %v", cert.FlagOtherSans, vSli, err)
+	}
+	otherSans, err := cert.NormaliseOtherSans(vSli)
+	if err != nil {
+		return err
+	}
+	c.SetOtherSans(otherSans)
+
+	vBool, err = cmd.PersistentFlags().GetBool(cert.FlagExcludeCNFromSans)
+	if err != nil {
+		return fmt.Errorf("error parsing %s [bool] '%t': %v", cert.FlagExcludeCNFromSans, vBool, err)
+	}
+	c.SetExcludeCNFromSans(vBool)
+
+	vTTL, err := cmd.PersistentFlags().GetDuration(cert.FlagTTL)
+	if err != nil {
+		return fmt.Errorf("error parsing %s [duration] '%s': %v", cert.FlagTTL, vTTL, err)
+	}
+	if vTTL < 0 {
+		return fmt.Errorf("invalid %s %s, must not be negative", cert.FlagTTL, vTTL)
+	}
+	c.SetTTL(vTTL)
+
 	vSli, err = cmd.PersistentFlags().GetStringSlice(cert.FlagOrganisation)
 	if err != nil {
 		return fmt.Errorf("error parsing %s [[]string] '%s' : %v", cert.FlagOrganisation, vSli, err)
 	}
 	c.SetOrganisation(vSli)
 
+	vStr, err = cmd.PersistentFlags().GetString(cert.FlagMode)
+	if err != nil {
+		return fmt.Errorf("error parsing %s [string] '%s': %v", cert.FlagMode, vStr, err)
+	}
+	mode, err := cert.NormaliseMode(vStr)
+	if err != nil {
+		return err
+	}
+	if mode == cert.ModeIssue && (cmd.PersistentFlags().Changed(cert.FlagKeyType) || cmd.PersistentFlags().Changed(cert.FlagKeyBitSize)) {
+		return fmt.Errorf("%s and %s can't be set with %s %s, the key type and size are set by the role", cert.FlagKeyType, cert.FlagKeyBitSize, cert.FlagMode, cert.ModeIssue)
+	}
+	c.SetMode(mode)
+
+	vStr, err = cmd.PersistentFlags().GetString(cert.FlagCertFile)
+	if err != nil {
+		return fmt.Errorf("error parsing %s [string] '%s': %v", cert.FlagCertFile, vStr, err)
+	}
+	c.SetCertFile(vStr)
+
+	vStr, err = cmd.PersistentFlags().GetString(cert.FlagKeyFile)
+	if err != nil {
+		return fmt.Errorf("error parsing %s [string] '%s': %v", cert.FlagKeyFile, vStr, err)
+	}
+	c.SetKeyFile(vStr)
+
+	vStr, err = cmd.PersistentFlags().GetString(cert.FlagCAFile)
+	if err != nil {
+		return fmt.Errorf("error parsing %s [string] '%s': %v", cert.FlagCAFile, vStr, err)
+	}
+	c.SetCAFile(vStr)
+
+	vSli, err = cmd.PersistentFlags().GetStringSlice(cert.FlagOutputFormats)
+	if err != nil {
+		return fmt.Errorf("error parsing %s [[]string] '%s': %v", cert.FlagOutputFormats, vSli, err)
+	}
+	var outputs []*cert.Output
+	for _, s := range vSli {
+		o, err := cert.ParseOutput(s)
+		if err != nil {
+			return err
+		}
+		outputs = append(outputs, o)
+	}
+	c.SetOutputFormats(outputs)
+
+	vStr, err = cmd.PersistentFlags().GetString(cert.FlagKeystorePasswordFile)
+	if err != nil {
+		return fmt.Errorf("error parsing %s [string] '%s': %v", cert.FlagKeystorePasswordFile, vStr, err)
+	}
+	c.SetKeystorePasswordFile(vStr)
+
+	vDur, err := cmd.PersistentFlags().GetDuration(cert.FlagRenewBefore)
+	if err != nil {
+		return fmt.Errorf("error parsing %s [duration] '%s': %v", cert.FlagRenewBefore, vDur, err)
+	}
+	c.SetRenewBefore(vDur)
+
 	abs, err := filepath.Abs(args[2])
 	if err != nil {
 		return fmt.Errorf("failed to generate absoute path from destination '%s': %v", args[2], err)
@@ -130,3 +305,61 @@ func setFlagsCert(c *cert.Cert, cmd *cobra.Command, args []string) error {
 
 	return nil
 }
+
+// newWatcher returns the certificate watcher if --watch is set, nil otherwise
+func newWatcher(c *cert.Cert, cmd *cobra.Command) (*cert.Watcher, error) {
+	watch, err := cmd.PersistentFlags().GetBool(cert.FlagWatch)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s [bool] '%t': %v", cert.FlagWatch, watch, err)
+	}
+	if !watch {
+		return nil, nil
+	}
+
+	w := cert.NewWatcher(c.Log, c)
+
+	fraction, err := cmd.PersistentFlags().GetFloat64(cert.FlagCertRenewFraction)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s [float] '%f': %v", cert.FlagCertRenewFraction, fraction, err)
+	}
+	if fraction <= 0 || fraction >= 1 {
+		return nil, fmt.Errorf("invalid %s %f, must be between 0 and 1", cert.FlagCertRenewFraction, fraction)
+	}
+	w.SetFraction(fraction)
+
+	vStr, err := cmd.PersistentFlags().GetString(cert.FlagReloadExec)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s [string] '%s': %v", cert.FlagReloadExec, vStr, err)
+	}
+	if vStr != "" {
+		w.AddHook(cert.NewExecHook(vStr))
+	}
+
+	pidFile, err := cmd.PersistentFlags().GetString(cert.FlagReloadPidFile)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s [string] '%s': %v", cert.FlagReloadPidFile, pidFile, err)
+	}
+	signal, err := cmd.PersistentFlags().GetString(cert.FlagReloadSignal)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s [string] '%s': %v", cert.FlagReloadSignal, signal, err)
+	}
+	if pidFile != "" {
+		h, err := cert.NewSignalHook(pidFile, signal)
+		if err != nil {
+			return nil, err
+		}
+		w.AddHook(h)
+	} else if cmd.PersistentFlags().Changed(cert.FlagReloadSignal) {
+		return nil, fmt.Errorf("%s requires %s", cert.FlagReloadSignal, cert.FlagReloadPidFile)
+	}
+
+	vStr, err = cmd.PersistentFlags().GetString(cert.FlagReloadSystemdUnit)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s [string] '%s': %v", cert.FlagReloadSystemdUnit, vStr, err)
+	}
+	if vStr != "" {
+		w.AddHook(cert.NewSystemdHook(vStr))
+	}
+
+	return w, nil
+}
@@ -0,0 +1,142 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/jetstack/vault-helper/pkg/cert"
+	"github.com/jetstack/vault-helper/pkg/kubernetes"
+)
+
+// certsCmd groups the commands on several certificates
+var certsCmd = &cobra.Command{
+	Use:   "certs",
+	Short: "Issue the certificates of a manifest, or list the certificates of a cluster.",
+}
+
+// certsApplyCmd represents the certs apply command
+var certsApplyCmd = &cobra.Command{
+	Use:   "apply [manifest path]",
+	Short: "Renew the token once, then ensure all certificates of a yaml manifest concurrently.",
+	Run: func(cmd *cobra.Command, args []string) {
+		log, err := LogLevel(cmd)
+		if err != nil {
+			Must(err)
+		}
+
+		if len(args) != 1 {
+			Must(fmt.Errorf("wrong number of arguments given. Usage: vault-helper certs apply [manifest path]"))
+		}
+
+		m, err := cert.LoadManifest(args[0])
+		if err != nil {
+			Must(err)
+		}
+
+		parallelism, err := cmd.PersistentFlags().GetInt(cert.FlagParallelism)
+		if err != nil {
+			Must(fmt.Errorf("error parsing %s [int] '%d': %v", cert.FlagParallelism, parallelism, err))
+		}
+		if parallelism < 1 {
+			Must(fmt.Errorf("invalid %s %d, must be at least 1", cert.FlagParallelism, parallelism))
+		}
+
+		i, err := newInstanceToken(cmd)
+		if err != nil {
+			Must(err)
+		}
+		if err := i.TokenRenewRun(); err != nil {
+			Must(err)
+		}
+
+		certs, err := m.Certs(log, i)
+		if err != nil {
+			Must(err)
+		}
+
+		if err := cert.WriteResults(os.Stdout, cert.Apply(certs, parallelism)); err != nil {
+			Must(err)
+		}
+	},
+}
+
+// certsListCmd represents the certs list command
+var certsListCmd = &cobra.Command{
+	Use:   "list [cluster ID]",
+	Short: "List the certificates issued by the pki backends of a cluster.",
+	Run: func(cmd *cobra.Command, args []string) {
+		log, err := LogLevel(cmd)
+		if err != nil {
+			Must(err)
+		}
+
+		if len(args) != 1 {
+			Must(fmt.Errorf("wrong number of arguments given. Usage: vault-helper certs list [cluster ID]"))
+		}
+
+		filter := &kubernetes.CertificateFilter{}
+
+		filter.Backends, err = cmd.Flags().GetStringSlice(kubernetes.FlagCertsBackend)
+		if err != nil {
+			Must(fmt.Errorf("error parsing %s '%s': %v", kubernetes.FlagCertsBackend, filter.Backends, err))
+		}
+
+		filter.CommonName, err = cmd.Flags().GetString(kubernetes.FlagCertsCommonName)
+		if err != nil {
+			Must(fmt.Errorf("error parsing %s '%s': %v", kubernetes.FlagCertsCommonName, filter.CommonName, err))
+		}
+
+		filter.ExpiresWithin, err = cmd.Flags().GetDuration(kubernetes.FlagCertsExpiresWithin)
+		if err != nil {
+			Must(fmt.Errorf("error parsing %s '%s': %v", kubernetes.FlagCertsExpiresWithin, filter.ExpiresWithin, err))
+		}
+
+		output, err := cmd.Flags().GetString(kubernetes.FlagCertsOutput)
+		if err != nil {
+			Must(fmt.Errorf("error parsing %s '%s': %v", kubernetes.FlagCertsOutput, output, err))
+		}
+		if output != "table" && output != "json" {
+			Must(fmt.Errorf("unknown output format '%s', valid formats are: table, json", output))
+		}
+
+		backoff, err := newBackoff(log)
+		if err != nil {
+			Must(err)
+		}
+
+		v, err := newVaultClient(nil, backoff)
+		if err != nil {
+			Must(err)
+		}
+
+		k := kubernetes.New(v, log)
+		k.SetClusterID(args[0])
+
+		certs, err := k.ListCertificates(v, filter)
+		if err != nil {
+			Must(err)
+		}
+
+		if err := kubernetes.WriteCertificates(os.Stdout, certs, output); err != nil {
+			Must(err)
+		}
+	},
+}
+
+func init() {
+	certsListCmd.Flags().StringSlice(kubernetes.FlagCertsBackend, []string{}, "Only list certificates of these pki backends: k8s, k8s-api-proxy, etcd-k8s or etcd-overlay (default all)")
+	certsListCmd.Flags().String(kubernetes.FlagCertsCommonName, "", "Only list certificates with a common name matching this glob, such as system:node:*")
+	certsListCmd.Flags().Duration(kubernetes.FlagCertsExpiresWithin, 0, "Only list certificates expiring within this duration, including expired certificates (default all)")
+	certsListCmd.Flags().StringP(kubernetes.FlagCertsOutput, "o", "table", "Output format: table or json")
+
+	certsCmd.AddCommand(certsListCmd)
+
+	instanceTokenFlags(certsApplyCmd)
+	certsApplyCmd.PersistentFlags().Int(cert.FlagParallelism, cert.DefaultParallelism, "Maximum number of certificates requested at once. [int]")
+
+	certsCmd.AddCommand(certsApplyCmd)
+	RootCmd.AddCommand(certsCmd)
+}
@@ -0,0 +1,308 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+
+	"github.com/jetstack/vault-helper/pkg/instanceToken"
+)
+
+const (
+	FlagConfigFile         = "config"
+	FlagVaultAddr          = "vault-addr"
+	FlagVaultCACert        = "vault-cacert"
+	FlagVaultCAPath        = "vault-capath"
+	FlagVaultClientCert    = "vault-client-cert"
+	FlagVaultClientKey     = "vault-client-key"
+	FlagVaultTLSServerName = "vault-tls-server-name"
+	FlagVaultSkipVerify    = "vault-skip-verify"
+	FlagVaultTimeout       = "vault-timeout"
+)
+
+const (
+	defaultConfigFile = "/etc/vault-helper/config.yaml"
+	envConfigFile     = "VAULT_HELPER_CONFIG"
+)
+
+// flagEnv maps flags to the environment variables taking precedence over the
+// config file
+var flagEnv = map[string]string{
+	FlagVaultAddr:              vault.EnvVaultAddress,
+	FlagVaultCACert:            vault.EnvVaultCACert,
+	FlagVaultCAPath:            vault.EnvVaultCAPath,
+	FlagVaultClientCert:        vault.EnvVaultClientCert,
+	FlagVaultClientKey:         vault.EnvVaultClientKey,
+	FlagVaultTLSServerName:     vault.EnvVaultTLSServerName,
+	FlagVaultSkipVerify:        vault.EnvVaultInsecure,
+	FlagVaultTimeout:           vault.EnvVaultClientTimeout,
+	instanceToken.FlagInitRole: "VAULT_INIT_ROLE",
+}
+
+// vaultConnection is the vault connection resolved from flags, environment
+// and config file, shared by all commands
+type vaultConnection struct {
+	address string
+	tls     vault.TLSConfig
+	timeout time.Duration
+}
+
+var resolvedVault = &vaultConnection{}
+
+func vaultFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().String(FlagConfigFile, defaultConfigFile, "Set path of the config file, setting any flag by its name. Flags and environment variables take precedence (env "+envConfigFile+")")
+	cmd.PersistentFlags().String(FlagVaultAddr, "", "Set address of the vault server (env "+vault.EnvVaultAddress+")")
+	cmd.PersistentFlags().String(FlagVaultCACert, "", "Set path of a PEM CA certificate to verify the vault server with (env "+vault.EnvVaultCACert+")")
+	cmd.PersistentFlags().String(FlagVaultCAPath, "", "Set path of a directory of PEM CA certificates to verify the vault server with (env "+vault.EnvVaultCAPath+")")
+	cmd.PersistentFlags().String(FlagVaultClientCert, "", "Set path of a PEM client certificate for TLS authentication to vault (env "+vault.EnvVaultClientCert+")")
+	cmd.PersistentFlags().String(FlagVaultClientKey, "", "Set path of the PEM key of the client certificate (env "+vault.EnvVaultClientKey+")")
+	cmd.PersistentFlags().String(FlagVaultTLSServerName, "", "Set server name to verify the vault server certificate with (env "+vault.EnvVaultTLSServerName+")")
+	cmd.PersistentFlags().Bool(FlagVaultSkipVerify, false, "Skip verification of the vault server certificate. Not recommended (env "+vault.EnvVaultInsecure+")")
+	cmd.PersistentFlags().Duration(FlagVaultTimeout, 0, "Set timeout of requests to vault (env "+vault.EnvVaultClientTimeout+") (default 60s)")
+}
+
+// loadConfig sets the flags of cmd from the config file, then resolves the
+// vault connection
+func loadConfig(cmd *cobra.Command) error {
+	if err := loadConfigFile(cmd); err != nil {
+		return err
+	}
+
+	return resolveVaultConnection(cmd)
+}
+
+// loadConfigFile sets the flags of cmd set neither on the command line nor by
+// environment from the config file. Top level keys apply to every command.
+// Keys under the path of a command, such as "renew-token" or "token status",
+// apply only to that command and take precedence.
+func loadConfigFile(cmd *cobra.Command) error {
+	path, err := cmd.Flags().GetString(FlagConfigFile)
+	if err != nil {
+		return fmt.Errorf("error parsing %s '%s': %v", FlagConfigFile, path, err)
+	}
+	explicit := cmd.Flags().Changed(FlagConfigFile)
+	if env := os.Getenv(envConfigFile); env != "" && !explicit {
+		path = env
+		explicit = true
+	}
+
+	dat, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading config file '%s': %v", path, err)
+	}
+
+	config := make(map[string]interface{})
+	if err := yaml.Unmarshal(dat, &config); err != nil {
+		return fmt.Errorf("error parsing config file '%s': %v", path, err)
+	}
+
+	values, err := configValues(config, commandPath(cmd))
+	if err != nil {
+		return fmt.Errorf("error in config file '%s': %v", path, err)
+	}
+
+	for name, value := range values {
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil || flag.Changed {
+			continue
+		}
+		if env, ok := flagEnv[name]; ok && os.Getenv(env) != "" {
+			continue
+		}
+
+		if err := cmd.Flags().Set(name, value); err != nil {
+			return fmt.Errorf("error setting %s from config file '%s': %v", name, path, err)
+		}
+	}
+
+	return nil
+}
+
+// configValues returns the flag values for the command at path. Keys are
+// checked against the flags of all commands, as a key unknown to every
+// command is a mistake.
+func configValues(config map[string]interface{}, path string) (map[string]string, error) {
+	flags := allFlags(RootCmd)
+	commands := allCommandPaths(RootCmd)
+	values := make(map[string]string)
+	var commandValues map[string]string
+
+	for key, value := range config {
+		if section, ok := value.(map[interface{}]interface{}); ok {
+			if !commands[key] {
+				return nil, fmt.Errorf("unknown command '%s'", key)
+			}
+			if key != path {
+				continue
+			}
+
+			commandValues = make(map[string]string)
+			for k, v := range section {
+				name := fmt.Sprintf("%v", k)
+				if !flags[name] {
+					return nil, fmt.Errorf("unknown flag '%s' for command '%s'", name, key)
+				}
+				commandValues[name] = configValue(v)
+			}
+			continue
+		}
+
+		if !flags[key] {
+			return nil, fmt.Errorf("unknown flag '%s'", key)
+		}
+		values[key] = configValue(value)
+	}
+
+	for k, v := range commandValues {
+		values[k] = v
+	}
+
+	return values, nil
+}
+
+func configValue(value interface{}) string {
+	list, ok := value.([]interface{})
+	if !ok {
+		return fmt.Sprintf("%v", value)
+	}
+
+	var items []string
+	for _, item := range list {
+		items = append(items, fmt.Sprintf("%v", item))
+	}
+
+	return strings.Join(items, ",")
+}
+
+func allFlags(cmd *cobra.Command) map[string]bool {
+	flags := make(map[string]bool)
+	add := func(f *pflag.Flag) {
+		flags[f.Name] = true
+	}
+
+	cmd.PersistentFlags().VisitAll(add)
+	cmd.Flags().VisitAll(add)
+	for _, c := range cmd.Commands() {
+		for name := range allFlags(c) {
+			flags[name] = true
+		}
+	}
+
+	return flags
+}
+
+func allCommandPaths(cmd *cobra.Command) map[string]bool {
+	paths := make(map[string]bool)
+	for _, c := range cmd.Commands() {
+		paths[commandPath(c)] = true
+		for path := range allCommandPaths(c) {
+			paths[path] = true
+		}
+	}
+
+	return paths
+}
+
+// commandPath returns the path of cmd without the root command, such as
+// "token status"
+func commandPath(cmd *cobra.Command) string {
+	var names []string
+	for c := cmd; c.HasParent(); c = c.Parent() {
+		names = append([]string{c.Name()}, names...)
+	}
+
+	return strings.Join(names, " ")
+}
+
+// resolveVaultConnection resolves each setting of the vault connection from
+// its flag, environment variable and the config file, in that order. Config
+// file values have been set as flags unless the environment variable is set.
+func resolveVaultConnection(cmd *cobra.Command) error {
+	c := &vaultConnection{}
+	var err error
+
+	for _, s := range []struct {
+		flag  string
+		value *string
+	}{
+		{FlagVaultAddr, &c.address},
+		{FlagVaultCACert, &c.tls.CACert},
+		{FlagVaultCAPath, &c.tls.CAPath},
+		{FlagVaultClientCert, &c.tls.ClientCert},
+		{FlagVaultClientKey, &c.tls.ClientKey},
+		{FlagVaultTLSServerName, &c.tls.TLSServerName},
+	} {
+		if !cmd.Flags().Changed(s.flag) {
+			*s.value = os.Getenv(flagEnv[s.flag])
+			continue
+		}
+		if *s.value, err = cmd.Flags().GetString(s.flag); err != nil {
+			return fmt.Errorf("error parsing %s '%s': %v", s.flag, *s.value, err)
+		}
+	}
+
+	if cmd.Flags().Changed(FlagVaultSkipVerify) {
+		if c.tls.Insecure, err = cmd.Flags().GetBool(FlagVaultSkipVerify); err != nil {
+			return fmt.Errorf("error parsing %s '%t': %v", FlagVaultSkipVerify, c.tls.Insecure, err)
+		}
+	} else if env := os.Getenv(vault.EnvVaultInsecure); env != "" {
+		if c.tls.Insecure, err = strconv.ParseBool(env); err != nil {
+			return fmt.Errorf("error parsing %s '%s': %v", vault.EnvVaultInsecure, env, err)
+		}
+	}
+
+	if cmd.Flags().Changed(FlagVaultTimeout) {
+		if c.timeout, err = cmd.Flags().GetDuration(FlagVaultTimeout); err != nil {
+			return fmt.Errorf("error parsing %s '%s': %v", FlagVaultTimeout, c.timeout, err)
+		}
+		if c.timeout <= 0 {
+			return fmt.Errorf("invalid %s '%s', must be greater than 0", FlagVaultTimeout, c.timeout)
+		}
+	}
+
+	resolvedVault = c
+
+	return nil
+}
+
+// configure applies the connection to a vault client config. The TLS client
+// certificate of tlsAuth is used if not nil, and must not conflict with the
+// vault client certificate.
+func (c *vaultConnection) configure(config *vault.Config, tlsAuth *vault.TLSConfig) error {
+	if c.address != "" {
+		config.Address = c.address
+	}
+	if c.timeout != 0 {
+		config.Timeout = c.timeout
+	}
+
+	tlsConfig := c.tls
+	if tlsAuth != nil {
+		if (tlsConfig.ClientCert != "" && tlsConfig.ClientCert != tlsAuth.ClientCert) || (tlsConfig.ClientKey != "" && tlsConfig.ClientKey != tlsAuth.ClientKey) {
+			return fmt.Errorf("%s '%s' conflicts with %s '%s', only one client certificate can be used", instanceToken.FlagAuthClientCert, tlsAuth.ClientCert, FlagVaultClientCert, tlsConfig.ClientCert)
+		}
+		tlsConfig.ClientCert = tlsAuth.ClientCert
+		tlsConfig.ClientKey = tlsAuth.ClientKey
+	}
+	if err := config.ConfigureTLS(&tlsConfig); err != nil {
+		return fmt.Errorf("error configuring vault client TLS: %v", err)
+	}
+
+	// verification may have been disabled by the environment
+	config.HttpClient.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify = tlsConfig.Insecure
+
+	return nil
+}
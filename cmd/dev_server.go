@@ -13,7 +13,9 @@ import (
 
 	"github.com/coreos/go-systemd/daemon"
 	"github.com/jetstack/vault-helper/pkg/dev_server"
+	"github.com/jetstack/vault-helper/pkg/instanceToken"
 	"github.com/jetstack/vault-helper/pkg/kubernetes"
+	"github.com/jetstack/vault-helper/pkg/vaultVersion"
 )
 
 // initCmd represents the init command
@@ -97,6 +99,11 @@ func init() {
 	devServerCmd.PersistentFlags().String(kubernetes.FlagInitTokenAll, "", "Set init-token-all    (Default to new token)")
 	devServerCmd.Flag(kubernetes.FlagInitTokenAll).Shorthand = "a"
 
+	devServerCmd.PersistentFlags().Bool(kubernetes.FlagEnableTransit, false, "Mount a transit backend with a key for kms encryption of kubernetes secrets")
+	devServerCmd.PersistentFlags().Bool(kubernetes.FlagEnableSSH, false, "Mount ssh backends with host and user CAs, and allow nodes to sign their host keys")
+	devServerCmd.PersistentFlags().StringSlice(kubernetes.FlagSSHAllowedDomains, []string{"compute.internal", "ec2.internal"}, "Domains nodes are allowed to sign ssh host certificates for")
+	devServerCmd.PersistentFlags().Bool(kubernetes.FlagRestrictKubelet, false, "Only allow nodes to sign kubelet certificates for their own node name, requires node tokens created with --"+instanceToken.FlagNodeEntityAlias+" from the init token of the node (init-token node). Requires vault "+vaultVersion.MinEntityAliases+" or later")
+
 	devServerCmd.PersistentFlags().Bool(dev_server.FlagWaitSignal, true, "Wait for TERM + QUIT signal has been given before termination")
 	devServerCmd.Flag(dev_server.FlagWaitSignal).Shorthand = "w"
 
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/hashicorp/go-multierror"
 	vault "github.com/hashicorp/vault/api"
@@ -12,6 +13,9 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/jetstack/vault-helper/pkg/instanceToken"
+	"github.com/jetstack/vault-helper/pkg/kubernetes"
+	"github.com/jetstack/vault-helper/pkg/retry"
+	"github.com/jetstack/vault-helper/pkg/vaultVersion"
 )
 
 // RootCmd represents the base command when called without any subcommands
@@ -38,22 +42,75 @@ func Execute() {
 func init() {
 	RootCmd.PersistentFlags().Int("log-level", 1, "Set the log level of output. 0-Fatal 1-Info 2-Debug")
 	RootCmd.Flag("log-level").Shorthand = "l"
+
+	RootCmd.PersistentFlags().Int(retry.FlagRetryMax, 5, "Maximum number of retries of vault requests failing with a transient error (sealed, standby, 5xx, connection refused)")
+	RootCmd.PersistentFlags().Duration(retry.FlagRetryMinBackoff, time.Millisecond*500, "Backoff before the first retry of a vault request")
+	RootCmd.PersistentFlags().Duration(retry.FlagRetryMaxBackoff, time.Second*30, "Maximum backoff between retries of a vault request")
+
+	vaultFlags(RootCmd)
+	RootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		return loadConfig(cmd)
+	}
 }
 
 func instanceTokenFlags(cmd *cobra.Command) {
 	cmd.PersistentFlags().StringP(instanceToken.FlagConfigPath, "p", "/etc/vault", "Set config path to directory with tokens")
 	cmd.PersistentFlags().StringP(instanceToken.FlagInitRole, "r", "", "Set role of token to renew. (default *no role*)")
+	cmd.PersistentFlags().String(instanceToken.FlagNodeName, "", "Set name of the node, stored in new tokens' metadata. (default <hostname>)")
+	cmd.PersistentFlags().Bool(instanceToken.FlagPreserveInitToken, false, "Keep the init token in a separate file after creating the token, to create a new token if it gets revoked or expires")
+	cmd.PersistentFlags().Bool(instanceToken.FlagInitTokenWrapped, false, "The init token is response-wrapped (init-token wrap), fail with a tamper warning if it has already been unwrapped")
+	cmd.PersistentFlags().Bool(instanceToken.FlagNodeEntityAlias, false, "Create new tokens with the node name as entity alias, required by clusters set up with --"+kubernetes.FlagRestrictKubelet+". The init role must be the node's own (init-token node). Requires vault "+vaultVersion.MinEntityAliases+" or later")
+	cmd.PersistentFlags().String(instanceToken.FlagAuthMethod, instanceToken.AuthMethodToken, "Set how to get a token: token (create from the init token), approle, cert or kubernetes (log in to vault)")
+	cmd.PersistentFlags().String(instanceToken.FlagAuthMount, "", "Set mount path of the auth backend, of the AppRole backend to re-bootstrap from with auth method token. (default <auth-method>, approle)")
+	cmd.PersistentFlags().String(instanceToken.FlagAuthRole, "", "Set role to log in with, required for kubernetes, certificate role name for cert")
+	cmd.PersistentFlags().String(instanceToken.FlagAuthRoleIDPath, "", "Set path of the file containing the AppRole role_id. (default <config-path>/approle-role-id)")
+	cmd.PersistentFlags().String(instanceToken.FlagAuthSecretIDPath, "", "Set path of the file containing the AppRole secret_id. (default <config-path>/approle-secret-id)")
+	cmd.PersistentFlags().String(instanceToken.FlagAuthJWTPath, instanceToken.DefaultJWTPath, "Set path of the Kubernetes service account token to log in with")
+	cmd.PersistentFlags().String(instanceToken.FlagAuthClientCert, "", "Set path of the TLS client certificate to log in with (cert)")
+	cmd.PersistentFlags().String(instanceToken.FlagAuthClientKey, "", "Set path of the TLS client key to log in with (cert)")
+	cmd.PersistentFlags().String(instanceToken.FlagTokenStore, instanceToken.TokenStoreFile, "Set where tokens are stored: file, keyring (Linux kernel user keyring) or tmpfs (with a sealed copy next to the token file)")
+	cmd.PersistentFlags().String(instanceToken.FlagTokenStoreTmpfsDir, instanceToken.DefaultTmpfsDir, "Set directory on a tmpfs to store tokens in with token store tmpfs")
+	cmd.PersistentFlags().String(instanceToken.FlagTokenStoreSealKey, "", "Set path of the key sealing the copies of tokens with token store tmpfs, created if missing. Required with token store tmpfs. Must only be accessible by its owner and kept on separate or protected storage: a key on the same disk as the sealed copies doesn't protect them")
 }
 
 func newInstanceToken(cmd *cobra.Command) (*instanceToken.InstanceToken, error) {
-	var result *multierror.Error
-
 	log, err := LogLevel(cmd)
 	if err != nil {
 		return nil, err
 	}
 
-	v, err := vault.NewClient(nil)
+	backoff, err := newBackoff(log)
+	if err != nil {
+		return nil, err
+	}
+
+	return newInstanceTokenWithBackoff(cmd, log, backoff)
+}
+
+// newInstanceTokenWithBackoff returns the instance token of the command with
+// a vault client retrying with backoff. A nil backoff sends every request
+// once.
+func newInstanceTokenWithBackoff(cmd *cobra.Command, log *logrus.Entry, backoff *retry.Backoff) (*instanceToken.InstanceToken, error) {
+	var result *multierror.Error
+
+	clientCert, err := cmd.Flags().GetString(instanceToken.FlagAuthClientCert)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s '%s': %v", instanceToken.FlagAuthClientCert, clientCert, err)
+	}
+	clientKey, err := cmd.Flags().GetString(instanceToken.FlagAuthClientKey)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s '%s': %v", instanceToken.FlagAuthClientKey, clientKey, err)
+	}
+
+	var tlsConfig *vault.TLSConfig
+	if clientCert != "" || clientKey != "" {
+		tlsConfig = &vault.TLSConfig{
+			ClientCert: clientCert,
+			ClientKey:  clientKey,
+		}
+	}
+
+	v, err := newVaultClient(tlsConfig, backoff)
 	if err != nil {
 		return nil, err
 	}
@@ -64,15 +121,109 @@ func newInstanceToken(cmd *cobra.Command) (*instanceToken.InstanceToken, error)
 	if err != nil {
 		result = multierror.Append(result, fmt.Errorf("error parsing %s '%s': %v", instanceToken.FlagInitRole, initRole, err))
 	}
+	authMethod, err := cmd.Flags().GetString(instanceToken.FlagAuthMethod)
+	if err != nil {
+		result = multierror.Append(result, fmt.Errorf("error parsing %s '%s': %v", instanceToken.FlagAuthMethod, authMethod, err))
+	}
+	i.SetAuthMethod(authMethod)
+	if _, err := i.Authenticator(); err != nil {
+		result = multierror.Append(result, err)
+	}
+
 	if initRole == "" {
 		//Read env variable
 		initRole = os.Getenv("VAULT_INIT_ROLE")
-		if initRole == "" {
+		if initRole == "" && i.AuthMethod() == instanceToken.AuthMethodToken {
 			result = multierror.Append(result, fmt.Errorf("no token role was given. token role is required for this command: --%s", instanceToken.FlagInitRole))
 		}
 	}
 	i.SetInitRole(initRole)
 
+	nodeName, err := cmd.Flags().GetString(instanceToken.FlagNodeName)
+	if err != nil {
+		result = multierror.Append(result, fmt.Errorf("error parsing %s '%s': %v", instanceToken.FlagNodeName, nodeName, err))
+	}
+	if nodeName == "" {
+		if nodeName, err = os.Hostname(); err != nil {
+			result = multierror.Append(result, fmt.Errorf("error getting hostname for node name: %v", err))
+		}
+	}
+	i.SetNodeName(nodeName)
+
+	entityAlias, err := cmd.Flags().GetBool(instanceToken.FlagNodeEntityAlias)
+	if err != nil {
+		result = multierror.Append(result, fmt.Errorf("error parsing %s '%t': %v", instanceToken.FlagNodeEntityAlias, entityAlias, err))
+	}
+	i.SetEntityAlias(entityAlias)
+
+	authMount, err := cmd.Flags().GetString(instanceToken.FlagAuthMount)
+	if err != nil {
+		result = multierror.Append(result, fmt.Errorf("error parsing %s '%s': %v", instanceToken.FlagAuthMount, authMount, err))
+	}
+	i.SetAuthMount(authMount)
+
+	authRole, err := cmd.Flags().GetString(instanceToken.FlagAuthRole)
+	if err != nil {
+		result = multierror.Append(result, fmt.Errorf("error parsing %s '%s': %v", instanceToken.FlagAuthRole, authRole, err))
+	}
+	i.SetAuthRole(authRole)
+
+	roleIDPath, err := cmd.Flags().GetString(instanceToken.FlagAuthRoleIDPath)
+	if err != nil {
+		result = multierror.Append(result, fmt.Errorf("error parsing %s '%s': %v", instanceToken.FlagAuthRoleIDPath, roleIDPath, err))
+	}
+	i.SetAuthRoleIDPath(roleIDPath)
+
+	secretIDPath, err := cmd.Flags().GetString(instanceToken.FlagAuthSecretIDPath)
+	if err != nil {
+		result = multierror.Append(result, fmt.Errorf("error parsing %s '%s': %v", instanceToken.FlagAuthSecretIDPath, secretIDPath, err))
+	}
+	i.SetAuthSecretIDPath(secretIDPath)
+
+	jwtPath, err := cmd.Flags().GetString(instanceToken.FlagAuthJWTPath)
+	if err != nil {
+		result = multierror.Append(result, fmt.Errorf("error parsing %s '%s': %v", instanceToken.FlagAuthJWTPath, jwtPath, err))
+	}
+	i.SetAuthJWTPath(jwtPath)
+
+	storeType, err := cmd.Flags().GetString(instanceToken.FlagTokenStore)
+	if err != nil {
+		result = multierror.Append(result, fmt.Errorf("error parsing %s '%s': %v", instanceToken.FlagTokenStore, storeType, err))
+	}
+	store, err := instanceToken.NewTokenStore(storeType)
+	if err != nil {
+		result = multierror.Append(result, err)
+	}
+	if tmpfs, ok := store.(*instanceToken.TmpfsTokenStore); ok {
+		tmpfsDir, err := cmd.Flags().GetString(instanceToken.FlagTokenStoreTmpfsDir)
+		if err != nil {
+			result = multierror.Append(result, fmt.Errorf("error parsing %s '%s': %v", instanceToken.FlagTokenStoreTmpfsDir, tmpfsDir, err))
+		}
+		tmpfs.SetDir(tmpfsDir)
+
+		sealKeyPath, err := cmd.Flags().GetString(instanceToken.FlagTokenStoreSealKey)
+		if err != nil {
+			result = multierror.Append(result, fmt.Errorf("error parsing %s '%s': %v", instanceToken.FlagTokenStoreSealKey, sealKeyPath, err))
+		}
+		if sealKeyPath == "" {
+			result = multierror.Append(result, fmt.Errorf("%s is required with %s %s", instanceToken.FlagTokenStoreSealKey, instanceToken.FlagTokenStore, instanceToken.TokenStoreTmpfs))
+		}
+		tmpfs.SetSealKeyPath(sealKeyPath)
+	}
+	i.SetTokenStore(store)
+
+	preserve, err := cmd.Flags().GetBool(instanceToken.FlagPreserveInitToken)
+	if err != nil {
+		result = multierror.Append(result, fmt.Errorf("error parsing %s '%t': %v", instanceToken.FlagPreserveInitToken, preserve, err))
+	}
+	i.SetPreserveInitToken(preserve)
+
+	wrapped, err := cmd.Flags().GetBool(instanceToken.FlagInitTokenWrapped)
+	if err != nil {
+		result = multierror.Append(result, fmt.Errorf("error parsing %s '%t': %v", instanceToken.FlagInitTokenWrapped, wrapped, err))
+	}
+	i.SetInitTokenWrapped(wrapped)
+
 	vaultConfigPath, err := cmd.Flags().GetString(instanceToken.FlagConfigPath)
 	if err != nil {
 		result = multierror.Append(result, fmt.Errorf("error parsing %s '%s': %v", instanceToken.FlagConfigPath, vaultConfigPath, err))
@@ -88,6 +239,65 @@ func newInstanceToken(cmd *cobra.Command) (*instanceToken.InstanceToken, error)
 	return i, result.ErrorOrNil()
 }
 
+// newVaultClient creates a vault client configured from the environment and
+// the resolved vault connection. tlsConfig may be nil, otherwise its client
+// certificate is used. Transient errors of every request are retried with
+// the backoff; without a backoff, requests are sent once.
+func newVaultClient(tlsConfig *vault.TLSConfig, backoff *retry.Backoff) (*vault.Client, error) {
+	config := vault.DefaultConfig()
+	if config.Error != nil {
+		return nil, config.Error
+	}
+	if err := resolvedVault.configure(config, tlsConfig); err != nil {
+		return nil, err
+	}
+
+	if backoff != nil {
+		config.HttpClient.Transport = retry.NewTransport(config.HttpClient.Transport, backoff)
+	}
+
+	v, err := vault.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// retries are handled by our transport, which also covers errors the
+	// client doesn't retry such as connection refused
+	v.SetMaxRetries(0)
+
+	return v, nil
+}
+
+func newBackoff(log *logrus.Entry) (*retry.Backoff, error) {
+	b := retry.New(log)
+
+	retries, err := RootCmd.PersistentFlags().GetInt(retry.FlagRetryMax)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s '%d': %v", retry.FlagRetryMax, retries, err)
+	}
+	if retries < 0 {
+		return nil, fmt.Errorf("invalid %s %d < 0", retry.FlagRetryMax, retries)
+	}
+	b.SetMaxRetries(retries)
+
+	min, err := RootCmd.PersistentFlags().GetDuration(retry.FlagRetryMinBackoff)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s '%s': %v", retry.FlagRetryMinBackoff, min, err)
+	}
+	b.SetMinBackoff(min)
+
+	max, err := RootCmd.PersistentFlags().GetDuration(retry.FlagRetryMaxBackoff)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s '%s': %v", retry.FlagRetryMaxBackoff, max, err)
+	}
+	if max < min {
+		return nil, fmt.Errorf("%s '%s' is less than %s '%s'", retry.FlagRetryMaxBackoff, max, retry.FlagRetryMinBackoff, min)
+	}
+	b.SetMaxBackoff(max)
+
+	return b, nil
+}
+
 func LogLevel(cmd *cobra.Command) (*logrus.Entry, error) {
 	logger := logrus.New()
 
@@ -0,0 +1,141 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/jetstack/vault-helper/pkg/file"
+	"github.com/jetstack/vault-helper/pkg/kubernetes"
+)
+
+// initTokenCmd groups the init token commands
+var initTokenCmd = &cobra.Command{
+	Use:   "init-token",
+	Short: "Manage init tokens of a kubernetes cluster.",
+}
+
+// initTokenWrapCmd represents the init-token wrap command
+var initTokenWrapCmd = &cobra.Command{
+	Use:   "wrap [cluster ID] [role]",
+	Short: "Output a response-wrapped init token for a role, to be delivered to nodes in place of the init token.",
+	Run: func(cmd *cobra.Command, args []string) {
+		log, err := LogLevel(cmd)
+		if err != nil {
+			Must(err)
+		}
+
+		if len(args) != 2 {
+			Must(fmt.Errorf("wrong number of arguments given. Usage: vault-helper init-token wrap [cluster ID] [role]"))
+		}
+
+		ttl, err := cmd.Flags().GetDuration(kubernetes.FlagWrapTTL)
+		if err != nil {
+			Must(fmt.Errorf("error parsing %s '%s': %v", kubernetes.FlagWrapTTL, ttl, err))
+		}
+
+		dest, err := cmd.Flags().GetString(kubernetes.FlagWrapDestPath)
+		if err != nil {
+			Must(fmt.Errorf("error parsing %s '%s': %v", kubernetes.FlagWrapDestPath, dest, err))
+		}
+
+		backoff, err := newBackoff(log)
+		if err != nil {
+			Must(err)
+		}
+
+		v, err := newVaultClient(nil, backoff)
+		if err != nil {
+			Must(err)
+		}
+
+		k := kubernetes.New(v, log)
+		k.SetClusterID(args[0])
+
+		token, err := k.WrapInitToken(v, args[1], ttl)
+		if err != nil {
+			Must(err)
+		}
+
+		if dest == "" {
+			fmt.Println(token)
+			return
+		}
+
+		abs, err := filepath.Abs(dest)
+		if err != nil {
+			Must(fmt.Errorf("error generating absoute path from destination '%s': %v", dest, err))
+		}
+		if err := file.WriteAtomic(abs, []byte(token), 0600); err != nil {
+			Must(err)
+		}
+		log.Infof("Wrapped init token written to: %s", abs)
+	},
+}
+
+// initTokenNodeCmd represents the init-token node command
+var initTokenNodeCmd = &cobra.Command{
+	Use:   "node [cluster ID] [role] [node name]",
+	Short: "Create an init token for a role bound to a single node, which can only create tokens with the node name as entity alias.",
+	Run: func(cmd *cobra.Command, args []string) {
+		log, err := LogLevel(cmd)
+		if err != nil {
+			Must(err)
+		}
+
+		if len(args) != 3 {
+			Must(fmt.Errorf("wrong number of arguments given. Usage: vault-helper init-token node [cluster ID] [role] [node name]"))
+		}
+
+		dest, err := cmd.Flags().GetString(kubernetes.FlagWrapDestPath)
+		if err != nil {
+			Must(fmt.Errorf("error parsing %s '%s': %v", kubernetes.FlagWrapDestPath, dest, err))
+		}
+
+		backoff, err := newBackoff(log)
+		if err != nil {
+			Must(err)
+		}
+
+		v, err := newVaultClient(nil, backoff)
+		if err != nil {
+			Must(err)
+		}
+
+		k := kubernetes.New(v, log)
+		k.SetClusterID(args[0])
+
+		token, err := k.NodeInitToken(args[1], args[2])
+		if err != nil {
+			Must(err)
+		}
+
+		if dest == "" {
+			fmt.Println(token)
+			return
+		}
+
+		abs, err := filepath.Abs(dest)
+		if err != nil {
+			Must(fmt.Errorf("error generating absoute path from destination '%s': %v", dest, err))
+		}
+		if err := file.WriteAtomic(abs, []byte(token), 0600); err != nil {
+			Must(err)
+		}
+		log.Infof("Init token written to: %s", abs)
+	},
+}
+
+func init() {
+	initTokenNodeCmd.Flags().StringP(kubernetes.FlagWrapDestPath, "d", "", "Write the init token to this file. Output to console if no path given (default <console>)")
+	initTokenCmd.AddCommand(initTokenNodeCmd)
+
+	initTokenWrapCmd.Flags().Duration(kubernetes.FlagWrapTTL, time.Minute*30, "TTL of the wrapping token, after which it can no longer be unwrapped")
+	initTokenWrapCmd.Flags().StringP(kubernetes.FlagWrapDestPath, "d", "", "Write the wrapping token to this file. Output to console if no path given (default <console>)")
+
+	initTokenCmd.AddCommand(initTokenWrapCmd)
+	RootCmd.AddCommand(initTokenCmd)
+}
@@ -0,0 +1,101 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/jetstack/vault-helper/pkg/kms"
+	"github.com/jetstack/vault-helper/pkg/kubernetes"
+)
+
+// kmsPluginCmd represents the kms-plugin command
+var kmsPluginCmd = &cobra.Command{
+	Use:   "kms-plugin [transit path]",
+	Short: "Serve the kubernetes KMS gRPC API on a unix socket, backed by a vault transit key.",
+	Run: func(cmd *cobra.Command, args []string) {
+		log, err := LogLevel(cmd)
+		if err != nil {
+			Must(err)
+		}
+
+		if len(args) != 1 {
+			Must(fmt.Errorf("wrong number of arguments given. Usage: vault-helper kms-plugin [transit path]"))
+		}
+
+		i, err := newInstanceToken(cmd)
+		if err != nil {
+			Must(err)
+		}
+
+		if err := i.TokenRenewRun(); err != nil {
+			Must(err)
+		}
+
+		k := kms.New(log, i)
+		k.SetTransitPath(filepath.Clean(args[0]))
+
+		if err := setFlagsKMS(k, cmd); err != nil {
+			Must(err)
+		}
+
+		if err := k.RunKMS(); err != nil {
+			Must(err)
+		}
+	},
+}
+
+func init() {
+	instanceTokenFlags(kmsPluginCmd)
+
+	kmsPluginCmd.PersistentFlags().String(kms.FlagSocketPath, "/var/run/vault-helper/kms.sock", "Path of the unix socket to serve the KMS API on")
+	kmsPluginCmd.Flag(kms.FlagSocketPath).Shorthand = "s"
+	kmsPluginCmd.PersistentFlags().String(kms.FlagKeyName, kubernetes.TransitKeyName, "Name of the transit key used for encryption")
+	kmsPluginCmd.Flag(kms.FlagKeyName).Shorthand = "k"
+	kmsPluginCmd.PersistentFlags().String(kms.FlagEncryptionConfigPath, "", "If set, write a kube-apiserver encryption config referencing the socket to this path (default <none>)")
+	kmsPluginCmd.Flag(kms.FlagEncryptionConfigPath).Shorthand = "e"
+	kmsPluginCmd.PersistentFlags().Duration(kms.FlagTokenRenewInterval, time.Hour, "Interval to renew the token at while serving")
+
+	RootCmd.AddCommand(kmsPluginCmd)
+}
+
+func setFlagsKMS(k *kms.KMS, cmd *cobra.Command) error {
+	value, err := cmd.PersistentFlags().GetString(kms.FlagSocketPath)
+	if err != nil {
+		return fmt.Errorf("error parsing %s '%s': %v", kms.FlagSocketPath, value, err)
+	}
+	abs, err := filepath.Abs(value)
+	if err != nil {
+		return fmt.Errorf("error generating absoute path from socket path '%s': %v", value, err)
+	}
+	k.SetSocketPath(abs)
+
+	value, err = cmd.PersistentFlags().GetString(kms.FlagKeyName)
+	if err != nil {
+		return fmt.Errorf("error parsing %s '%s': %v", kms.FlagKeyName, value, err)
+	}
+	k.SetKeyName(value)
+
+	value, err = cmd.PersistentFlags().GetString(kms.FlagEncryptionConfigPath)
+	if err != nil {
+		return fmt.Errorf("error parsing %s '%s': %v", kms.FlagEncryptionConfigPath, value, err)
+	}
+	if value != "" {
+		abs, err := filepath.Abs(value)
+		if err != nil {
+			return fmt.Errorf("error generating absoute path from encryption config path '%s': %v", value, err)
+		}
+		k.SetEncryptionConfigPath(abs)
+	}
+
+	interval, err := cmd.PersistentFlags().GetDuration(kms.FlagTokenRenewInterval)
+	if err != nil {
+		return fmt.Errorf("error parsing %s '%s': %v", kms.FlagTokenRenewInterval, interval, err)
+	}
+	k.SetTokenRenewInterval(interval)
+
+	return nil
+}
@@ -46,6 +46,18 @@ var kubeconfCmd = &cobra.Command{
 		u := kubeconfig.New(log, c)
 		u.SetKubeConfigPath(abs)
 
+		w, err := newWatcher(c, cmd)
+		if err != nil {
+			Must(err)
+		}
+		if w != nil {
+			w.SetOnRotate(u.RunKube)
+			if err := w.Run(stopSignal()); err != nil {
+				Must(err)
+			}
+			return
+		}
+
 		if err := c.RunCert(); err != nil {
 			Must(err)
 		}
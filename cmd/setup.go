@@ -6,10 +6,11 @@ import (
 	"fmt"
 	"time"
 
-	vault "github.com/hashicorp/vault/api"
 	"github.com/spf13/cobra"
 
+	"github.com/jetstack/vault-helper/pkg/instanceToken"
 	"github.com/jetstack/vault-helper/pkg/kubernetes"
+	"github.com/jetstack/vault-helper/pkg/vaultVersion"
 )
 
 // initCmd represents the init command
@@ -22,16 +23,18 @@ var SetupCmd = &cobra.Command{
 			Must(err)
 		}
 
-		v, err := vault.NewClient(nil)
+		backoff, err := newBackoff(log)
 		if err != nil {
 			Must(err)
 		}
 
-		k := kubernetes.New(v, log)
+		v, err := newVaultClient(nil, backoff)
 		if err != nil {
 			Must(err)
 		}
 
+		k := kubernetes.New(v, log)
+
 		if len(args) > 0 {
 			k.SetClusterID(args[0])
 		} else {
@@ -66,6 +69,11 @@ func InitSetupFlags() {
 	SetupCmd.PersistentFlags().String(kubernetes.FlagInitTokenMaster, "", "Set init-token-master (Default to new token)")
 	SetupCmd.PersistentFlags().String(kubernetes.FlagInitTokenAll, "", "Set init-token-all    (Default to new token)")
 
+	SetupCmd.PersistentFlags().Bool(kubernetes.FlagEnableTransit, false, "Mount a transit backend with a key for kms encryption of kubernetes secrets")
+	SetupCmd.PersistentFlags().Bool(kubernetes.FlagEnableSSH, false, "Mount ssh backends with host and user CAs, and allow nodes to sign their host keys")
+	SetupCmd.PersistentFlags().StringSlice(kubernetes.FlagSSHAllowedDomains, []string{"compute.internal", "ec2.internal"}, "Domains nodes are allowed to sign ssh host certificates for")
+	SetupCmd.PersistentFlags().Bool(kubernetes.FlagRestrictKubelet, false, "Only allow nodes to sign kubelet certificates for their own node name, requires node tokens created with --"+instanceToken.FlagNodeEntityAlias+" from the init token of the node (init-token node). Requires vault "+vaultVersion.MinEntityAliases+" or later")
+
 	RootCmd.AddCommand(SetupCmd)
 }
 
@@ -116,5 +124,29 @@ func setFlagsKubernetes(k *kubernetes.Kubernetes, cmd *cobra.Command) error {
 	}
 	k.FlagInitTokens.All = value
 
+	enable, err := cmd.PersistentFlags().GetBool(kubernetes.FlagEnableTransit)
+	if err != nil {
+		return fmt.Errorf("error parsing %s '%t': %s", kubernetes.FlagEnableTransit, enable, err)
+	}
+	k.EnableTransit = enable
+
+	enable, err = cmd.PersistentFlags().GetBool(kubernetes.FlagEnableSSH)
+	if err != nil {
+		return fmt.Errorf("error parsing %s '%t': %s", kubernetes.FlagEnableSSH, enable, err)
+	}
+	k.EnableSSH = enable
+
+	domains, err := cmd.PersistentFlags().GetStringSlice(kubernetes.FlagSSHAllowedDomains)
+	if err != nil {
+		return fmt.Errorf("error parsing %s '%s': %s", kubernetes.FlagSSHAllowedDomains, domains, err)
+	}
+	k.SSHAllowedDomains = domains
+
+	enable, err = cmd.PersistentFlags().GetBool(kubernetes.FlagRestrictKubelet)
+	if err != nil {
+		return fmt.Errorf("error parsing %s '%t': %s", kubernetes.FlagRestrictKubelet, enable, err)
+	}
+	k.RestrictKubelet = enable
+
 	return nil
 }
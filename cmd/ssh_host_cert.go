@@ -0,0 +1,78 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/jetstack/vault-helper/pkg/sshHostCert"
+)
+
+// sshHostCertCmd represents the ssh-host-cert command
+var sshHostCertCmd = &cobra.Command{
+	Use:   "ssh-host-cert [sign path]",
+	Short: "Sign the ssh host keys of this node and write the host certificates.",
+	Run: func(cmd *cobra.Command, args []string) {
+		log, err := LogLevel(cmd)
+		if err != nil {
+			Must(err)
+		}
+
+		if len(args) != 1 {
+			Must(fmt.Errorf("wrong number of arguments given. Usage: vault-helper ssh-host-cert [sign path]"))
+		}
+
+		i, err := newInstanceToken(cmd)
+		if err != nil {
+			Must(err)
+		}
+
+		if err := i.TokenRenewRun(); err != nil {
+			Must(err)
+		}
+
+		s := sshHostCert.New(log, i)
+		s.SetSignPath(filepath.Clean(args[0]))
+
+		if err := setFlagsSSHHostCert(s, cmd); err != nil {
+			Must(err)
+		}
+
+		if err := s.RunSSHHostCert(); err != nil {
+			Must(err)
+		}
+	},
+}
+
+func init() {
+	instanceTokenFlags(sshHostCertCmd)
+
+	sshHostCertCmd.PersistentFlags().String(sshHostCert.FlagHostKeyDir, "/etc/ssh", "Directory containing the ssh_host_*_key.pub files to sign")
+	sshHostCertCmd.Flag(sshHostCert.FlagHostKeyDir).Shorthand = "d"
+	sshHostCertCmd.PersistentFlags().StringSlice(sshHostCert.FlagPrincipals, []string{}, "Principals (hostnames) of the host certificates (default the FQDN of the host)")
+	sshHostCertCmd.Flag(sshHostCert.FlagPrincipals).Shorthand = "n"
+
+	RootCmd.AddCommand(sshHostCertCmd)
+}
+
+func setFlagsSSHHostCert(s *sshHostCert.SSHHostCert, cmd *cobra.Command) error {
+	value, err := cmd.PersistentFlags().GetString(sshHostCert.FlagHostKeyDir)
+	if err != nil {
+		return fmt.Errorf("error parsing %s '%s': %v", sshHostCert.FlagHostKeyDir, value, err)
+	}
+	abs, err := filepath.Abs(value)
+	if err != nil {
+		return fmt.Errorf("error generating absoute path from host key dir '%s': %v", value, err)
+	}
+	s.SetHostKeyDir(abs)
+
+	principals, err := cmd.PersistentFlags().GetStringSlice(sshHostCert.FlagPrincipals)
+	if err != nil {
+		return fmt.Errorf("error parsing %s '%s': %v", sshHostCert.FlagPrincipals, principals, err)
+	}
+	s.SetPrincipals(principals)
+
+	return nil
+}
@@ -2,7 +2,14 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/spf13/cobra"
+
+	"github.com/jetstack/vault-helper/pkg/instanceToken"
 )
 
 // initCmd represents the init command
@@ -16,7 +23,23 @@ var renewtokenCmd = &cobra.Command{
 			Must(err)
 		}
 
-		if err := i.TokenRenewRun(); err != nil {
+		if err := setFlagsRenewToken(i, cmd); err != nil {
+			Must(err)
+		}
+
+		d, err := cmd.PersistentFlags().GetBool(instanceToken.FlagDaemon)
+		if err != nil {
+			Must(fmt.Errorf("error parsing %s '%t': %v", instanceToken.FlagDaemon, d, err))
+		}
+
+		if !d {
+			if err := i.TokenRenewRun(); err != nil {
+				Must(err)
+			}
+			return
+		}
+
+		if err := i.TokenRenewDaemon(stopSignal()); err != nil {
 			Must(err)
 		}
 	},
@@ -24,5 +47,38 @@ var renewtokenCmd = &cobra.Command{
 
 func init() {
 	instanceTokenFlags(renewtokenCmd)
+
+	renewtokenCmd.PersistentFlags().Bool(instanceToken.FlagDaemon, false, "Keep running, renewing the token at a fraction of its TTL and notifying systemd")
+	renewtokenCmd.Flag(instanceToken.FlagDaemon).Shorthand = "d"
+	renewtokenCmd.PersistentFlags().Float64(instanceToken.FlagRenewFraction, 0.5, "Fraction of the token's TTL after which the daemon renews it")
+
 	RootCmd.AddCommand(renewtokenCmd)
 }
+
+func setFlagsRenewToken(i *instanceToken.InstanceToken, cmd *cobra.Command) error {
+	fraction, err := cmd.PersistentFlags().GetFloat64(instanceToken.FlagRenewFraction)
+	if err != nil {
+		return fmt.Errorf("error parsing %s '%f': %v", instanceToken.FlagRenewFraction, fraction, err)
+	}
+	if fraction <= 0 || fraction >= 1 {
+		return fmt.Errorf("invalid %s %f, must be between 0 and 1", instanceToken.FlagRenewFraction, fraction)
+	}
+	i.SetRenewFraction(fraction)
+
+	return nil
+}
+
+// stopSignal returns a channel which is closed on SIGTERM or SIGINT
+func stopSignal() <-chan struct{} {
+	stop := make(chan struct{})
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		<-signalChan
+		close(stop)
+	}()
+
+	return stop
+}
@@ -0,0 +1,87 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/jetstack/vault-helper/pkg/instanceToken"
+)
+
+// tokenCmd groups the commands on the stored token
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Inspect the token of this instance.",
+}
+
+// tokenStatusCmd represents the token status command
+var tokenStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Output the health of the stored token. Exits 0 if healthy, 1 if expiring, 2 if invalid and 3 if vault is unreachable or the status can't be checked.",
+	Run: func(cmd *cobra.Command, args []string) {
+		output, err := cmd.Flags().GetString(instanceToken.FlagStatusOutput)
+		if err != nil {
+			tokenStatusFail("text", fmt.Errorf("error parsing %s '%s': %v", instanceToken.FlagStatusOutput, output, err))
+		}
+		if output != "text" && output != "json" {
+			tokenStatusFail("text", fmt.Errorf("unknown output format '%s', valid formats are: text, json", output))
+		}
+
+		threshold, err := cmd.Flags().GetDuration(instanceToken.FlagStatusThreshold)
+		if err != nil {
+			tokenStatusFail(output, fmt.Errorf("error parsing %s '%s': %v", instanceToken.FlagStatusThreshold, threshold, err))
+		}
+
+		timeout, err := cmd.Flags().GetDuration(instanceToken.FlagStatusTimeout)
+		if err != nil {
+			tokenStatusFail(output, fmt.Errorf("error parsing %s '%s': %v", instanceToken.FlagStatusTimeout, timeout, err))
+		}
+
+		log, err := LogLevel(cmd)
+		if err != nil {
+			tokenStatusFail(output, err)
+		}
+
+		// probes need a quick answer, so vault is asked once
+		i, err := newInstanceTokenWithBackoff(cmd, log, nil)
+		if err != nil {
+			tokenStatusFail(output, err)
+		}
+		i.VaultClient().SetClientTimeout(timeout)
+
+		status := i.TokenStatus(threshold)
+		if err := status.Write(os.Stdout, output); err != nil {
+			Must(err)
+		}
+
+		os.Exit(status.ExitCode())
+	},
+}
+
+// tokenStatusFail outputs the status unreachable for errors setting up the
+// lookup, as the health of the token is unknown
+func tokenStatusFail(output string, err error) {
+	status := &instanceToken.TokenStatus{
+		Status: instanceToken.StatusUnreachable,
+		Error:  err.Error(),
+	}
+	if err := status.Write(os.Stdout, output); err != nil {
+		Must(err)
+	}
+
+	os.Exit(status.ExitCode())
+}
+
+func init() {
+	instanceTokenFlags(tokenStatusCmd)
+
+	tokenStatusCmd.Flags().StringP(instanceToken.FlagStatusOutput, "o", "text", "Output format: text or json")
+	tokenStatusCmd.Flags().Duration(instanceToken.FlagStatusThreshold, time.Hour, "Report the token as expiring if its TTL is below this threshold")
+	tokenStatusCmd.Flags().Duration(instanceToken.FlagStatusTimeout, 5*time.Second, "Report vault as unreachable if the lookup takes longer than this timeout. The lookup isn't retried")
+
+	tokenCmd.AddCommand(tokenStatusCmd)
+	RootCmd.AddCommand(tokenCmd)
+}
@@ -3,6 +3,7 @@ package main
 
 import (
 	"github.com/jetstack/vault-helper/cmd"
+	"github.com/jetstack/vault-helper/pkg/kms"
 	"github.com/jetstack/vault-helper/pkg/kubernetes"
 )
 
@@ -17,5 +18,6 @@ func main() {
 	cmd.Version.Commit = commit
 	cmd.Version.BuildDate = date
 	kubernetes.Version = version
+	kms.Version = version
 	cmd.Execute()
 }
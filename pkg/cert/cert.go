@@ -4,9 +4,11 @@ package cert
 import (
 	"encoding/pem"
 	"fmt"
+	"net"
 	"os"
 	"os/user"
 	"strconv"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
@@ -15,25 +17,52 @@ import (
 
 const FlagKeyBitSize = "key-bit-size"
 const FlagKeyType = "key-type"
+const FlagKeyFormat = "key-format"
 const FlagIpSans = "ip-sans"
 const FlagSanHosts = "san-hosts"
 const FlagOwner = "owner"
 const FlagGroup = "group"
 const FlagOrganisation = "organisation"
+const FlagCertFile = "cert-file"
+const FlagKeyFile = "key-file"
+const FlagCAFile = "ca-file"
 
 type Cert struct {
 	role         string
 	commonName   string
 	organisation []string
 	destination  string
+	certFile     string
+	keyFile      string
+	caFile       string
 	bitSize      int
 	pemSize      int
+	pemKeyType   string
+	pemKeyFormat string
 	keyType      string
+	keyFormat    string
 	ipSans       []string
 	sanHosts     []string
+	uriSans      []string
+	otherSans    []string
+	excludeCN    bool
+	ttl          time.Duration
+	mode         string
+	autoIPSans   bool
+	autoDNSSans  bool
+	ipInclude    []*net.IPNet
+	ipExclude    []*net.IPNet
 	owner        string
 	group        string
+	renewBefore  time.Duration
+	outputs      []*Output
+	passwordFile string
 	data         *pem.Block
+	newKey       bool
+
+	// SANs discovered by the last check of the certificate
+	discoveredIPSans   []string
+	discoveredSanHosts []string
 
 	Log           *logrus.Entry
 	instanceToken *instanceToken.InstanceToken
@@ -42,7 +71,8 @@ type Cert struct {
 func New(logger *logrus.Entry, i *instanceToken.InstanceToken) *Cert {
 	c := &Cert{
 		bitSize:       2048,
-		keyType:       "RSA",
+		keyType:       KeyTypeRSA,
+		renewBefore:   DefaultRenewBefore,
 		instanceToken: i,
 	}
 
@@ -54,15 +84,44 @@ func New(logger *logrus.Entry, i *instanceToken.InstanceToken) *Cert {
 }
 
 func (c *Cert) RunCert() error {
-	if err := c.EnsureKey(); err != nil {
-		return fmt.Errorf("error ensuring key: %v", err)
+	_, err := c.ensureCertificate("")
+	return err
+}
+
+// ensureCertificate ensures the key and requests a new certificate if the
+// existing certificate is not valid, or if a reason to rotate it is given.
+// It returns true if a new certificate has been written.
+func (c *Cert) ensureCertificate(rotate string) (issued bool, err error) {
+	if err := c.discoverSans(); err != nil {
+		return false, err
+	}
+
+	if c.Mode() == ModeIssue {
+		if err := c.loadExistingKey(); err != nil {
+			return false, err
+		}
+	} else if err := c.EnsureKey(); err != nil {
+		return false, fmt.Errorf("error ensuring key: %v", err)
+	}
+
+	reason, err := c.renewalReason()
+	if err != nil {
+		return false, fmt.Errorf("error verifying existing certificate: %v", err)
+	}
+	if reason == "" {
+		reason = rotate
 	}
+	if reason == "" {
+		c.Log.Infof("Certificate is valid, not requesting new certificate: %s", c.CertFile())
+		return false, nil
+	}
+	c.Log.Infof("Requesting new certificate: %s", reason)
 
 	if err := c.RequestCertificate(); err != nil {
-		return fmt.Errorf("error requesting certificate: %v", err)
+		return false, fmt.Errorf("error requesting certificate: %v", err)
 	}
 
-	return nil
+	return true, nil
 }
 
 func (c *Cert) DeleteFile(path string) error {
@@ -173,6 +232,40 @@ func (c *Cert) Destination() string {
 	return c.destination
 }
 
+// SetCertFile overrides the path of the certificate, relative paths are
+// relative to the directory of the destination
+func (c *Cert) SetCertFile(path string) {
+	c.certFile = path
+}
+
+// CertFile returns the path of the certificate, <destination>.pem by default
+func (c *Cert) CertFile() string {
+	return c.filePath(c.certFile, ".pem")
+}
+
+// SetKeyFile overrides the path of the key, relative paths are relative to
+// the directory of the destination
+func (c *Cert) SetKeyFile(path string) {
+	c.keyFile = path
+}
+
+// KeyFile returns the path of the key, <destination>-key.pem by default
+func (c *Cert) KeyFile() string {
+	return c.filePath(c.keyFile, "-key.pem")
+}
+
+// SetCAFile overrides the path of the CA certificate, relative paths are
+// relative to the directory of the destination
+func (c *Cert) SetCAFile(path string) {
+	c.caFile = path
+}
+
+// CAFile returns the path of the CA certificate, <destination>-ca.pem by
+// default
+func (c *Cert) CAFile() string {
+	return c.filePath(c.caFile, "-ca.pem")
+}
+
 func (c *Cert) SetBitSize(size int) {
 	c.bitSize = size
 }
@@ -187,6 +280,34 @@ func (c *Cert) PemSize() int {
 	return c.pemSize
 }
 
+// SetPemKeyType sets the type of the key loaded from file
+func (c *Cert) SetPemKeyType(keyType string) {
+	c.pemKeyType = keyType
+}
+func (c *Cert) PemKeyType() string {
+	return c.pemKeyType
+}
+
+// SetPemKeyFormat sets the format of the key loaded from file
+func (c *Cert) SetPemKeyFormat(format string) {
+	c.pemKeyFormat = format
+}
+func (c *Cert) PemKeyFormat() string {
+	return c.pemKeyFormat
+}
+
+// SetKeyFormat sets the encoding of the key file, which defaults to the usual
+// encoding of the key type
+func (c *Cert) SetKeyFormat(format string) {
+	c.keyFormat = format
+}
+func (c *Cert) KeyFormat() string {
+	if c.keyFormat == "" {
+		return DefaultKeyFormat(c.KeyType())
+	}
+	return c.keyFormat
+}
+
 func (c *Cert) SetKeyType(keyType string) {
 	c.keyType = keyType
 }
@@ -208,6 +329,82 @@ func (c *Cert) SanHosts() []string {
 	return c.sanHosts
 }
 
+func (c *Cert) SetURISans(uris []string) {
+	c.uriSans = uris
+}
+func (c *Cert) URISans() []string {
+	return c.uriSans
+}
+
+// SetOtherSans sets the other SANs in the vault format <oid>;UTF8:<value>
+func (c *Cert) SetOtherSans(sans []string) {
+	c.otherSans = sans
+}
+func (c *Cert) OtherSans() []string {
+	return c.otherSans
+}
+
+// SetExcludeCNFromSans requests the common name not to be added to the SANs
+func (c *Cert) SetExcludeCNFromSans(exclude bool) {
+	c.excludeCN = exclude
+}
+func (c *Cert) ExcludeCNFromSans() bool {
+	return c.excludeCN
+}
+
+// SetTTL sets the requested lifetime of the certificate, zero uses the ttl of
+// the role
+func (c *Cert) SetTTL(ttl time.Duration) {
+	c.ttl = ttl
+}
+func (c *Cert) TTL() time.Duration {
+	return c.ttl
+}
+
+// SetAutoIPSans adds the addresses of the interfaces to the IP SANs
+func (c *Cert) SetAutoIPSans(auto bool) {
+	c.autoIPSans = auto
+}
+func (c *Cert) AutoIPSans() bool {
+	return c.autoIPSans
+}
+
+// SetAutoDNSSans adds the hostname and FQDN to the host SANs
+func (c *Cert) SetAutoDNSSans(auto bool) {
+	c.autoDNSSans = auto
+}
+func (c *Cert) AutoDNSSans() bool {
+	return c.autoDNSSans
+}
+
+// SetAutoIPSansInclude limits the discovered IP SANs to these networks
+func (c *Cert) SetAutoIPSansInclude(nets []*net.IPNet) {
+	c.ipInclude = nets
+}
+func (c *Cert) AutoIPSansInclude() []*net.IPNet {
+	return c.ipInclude
+}
+
+// SetAutoIPSansExclude drops discovered IP SANs of these networks
+func (c *Cert) SetAutoIPSansExclude(nets []*net.IPNet) {
+	c.ipExclude = nets
+}
+func (c *Cert) AutoIPSansExclude() []*net.IPNet {
+	return c.ipExclude
+}
+
+// SetMode sets whether the key is generated locally and signed, or issued by
+// vault
+func (c *Cert) SetMode(mode string) {
+	c.mode = mode
+}
+func (c *Cert) Mode() string {
+	if c.mode == "" {
+		return ModeSign
+	}
+	return c.mode
+}
+
 func (c *Cert) SetOwner(owner string) {
 	c.owner = owner
 }
@@ -222,6 +419,28 @@ func (c *Cert) Group() string {
 	return c.group
 }
 
+// SetRenewBefore sets how long before expiry the certificate is renewed
+func (c *Cert) SetRenewBefore(renewBefore time.Duration) {
+	c.renewBefore = renewBefore
+}
+func (c *Cert) RenewBefore() time.Duration {
+	return c.renewBefore
+}
+
+func (c *Cert) SetOutputFormats(outputs []*Output) {
+	c.outputs = outputs
+}
+func (c *Cert) OutputFormats() []*Output {
+	return c.outputs
+}
+
+func (c *Cert) SetKeystorePasswordFile(path string) {
+	c.passwordFile = path
+}
+func (c *Cert) KeystorePasswordFile() string {
+	return c.passwordFile
+}
+
 func (c *Cert) SetData(data *pem.Block) {
 	c.data = data
 }
@@ -13,11 +13,13 @@ import (
 	"strings"
 
 	vault "github.com/hashicorp/vault/api"
+
+	"github.com/jetstack/vault-helper/pkg/file"
 )
 
 func (c *Cert) RequestCertificate() error {
-	ipSans := strings.Join(c.IPSans(), ",")
-	hosts := strings.Join(c.SanHosts(), ",")
+	ipSans := strings.Join(c.allIPSans(), ",")
+	hosts := strings.Join(c.allSanHosts(), ",")
 
 	path := filepath.Clean(c.Role())
 
@@ -26,8 +28,29 @@ func (c *Cert) RequestCertificate() error {
 		"ip_sans":     ipSans,
 		"alt_names":   hosts,
 	}
-	sec, err := c.writeCSR(path, data)
-	if err != nil {
+	if len(c.URISans()) > 0 {
+		data["uri_sans"] = strings.Join(c.URISans(), ",")
+	}
+	if len(c.OtherSans()) > 0 {
+		data["other_sans"] = strings.Join(c.OtherSans(), ",")
+	}
+	if c.ExcludeCNFromSans() {
+		data["exclude_cn_from_sans"] = true
+	}
+	if c.TTL() > 0 {
+		data["ttl"] = c.TTL().String()
+	}
+
+	var sec *vault.Secret
+	var err error
+	if c.Mode() == ModeIssue {
+		if path, err = issuePath(path); err != nil {
+			return err
+		}
+		if sec, err = c.writeIssue(path, data); err != nil {
+			return fmt.Errorf("error issuing certificate from vault at '%s': %v", path, err)
+		}
+	} else if sec, err = c.writeCSR(path, data); err != nil {
 		return fmt.Errorf("error writing CSR to vault at '%s': %v", path, err)
 	}
 
@@ -35,6 +58,11 @@ func (c *Cert) RequestCertificate() error {
 	if err != nil {
 		return fmt.Errorf("failed to decode secret from CSR: %v", err)
 	}
+	if c.Mode() == ModeIssue {
+		if err := c.decodeIssuedKey(sec); err != nil {
+			return fmt.Errorf("failed to decode issued key: %v", err)
+		}
+	}
 
 	if cert == "" {
 		return errors.New("no certificate received")
@@ -45,14 +73,12 @@ func (c *Cert) RequestCertificate() error {
 
 	c.Log.Infof("New certificate received for: %s", c.CommonName())
 
-	certPath := c.certPath()
-	caPath := c.caPath()
-
-	if err := c.storeCertificate(certPath, cert); err != nil {
-		return fmt.Errorf("error storing certificate at path '%s': %v", certPath, err)
+	if err := c.verifyIssued(cert); err != nil {
+		return fmt.Errorf("certificate issued by role '%s' doesn't match the request: %v", c.Role(), err)
 	}
-	if err := c.storeCertificate(caPath, certCA); err != nil {
-		return fmt.Errorf("error storing ca certificate at path '%s': %v", caPath, err)
+
+	if err := c.storeFiles(cert, certCA); err != nil {
+		return fmt.Errorf("error storing certificate: %v", err)
 	}
 
 	return nil
@@ -79,24 +105,6 @@ func (c *Cert) checkExistingCerts(path string) (exist bool, err error) {
 	return true, nil
 }
 
-func (c *Cert) verifyCertificates() error {
-	conf := vault.DefaultConfig()
-	conf.Address = c.InstanceToken().VaultClient().Address()
-
-	tConf := &vault.TLSConfig{
-		CAPath:     filepath.Clean(filepath.Dir(c.Destination())),
-		CACert:     filepath.Clean(c.Destination() + "-ca.pem"),
-		ClientCert: filepath.Clean(c.Destination() + ".pem"),
-		ClientKey:  filepath.Clean(c.Destination() + "-key.pem"),
-	}
-
-	if err := conf.ConfigureTLS(tConf); err != nil {
-		return fmt.Errorf("error verifying cert: %v", err)
-	}
-
-	return nil
-}
-
 func (c *Cert) decodeSec(sec *vault.Secret) (cert string, certCA string, err error) {
 	if sec == nil {
 		return "", "", errors.New("no secret returned from vault")
@@ -113,9 +121,9 @@ func (c *Cert) decodeSec(sec *vault.Secret) (cert string, certCA string, err err
 	}
 
 	if certCAField, ok := sec.Data["ca_chain"]; ok {
-		certCAs, ok := certCAField.([]string)
-		if !ok {
-			return "", "", errors.New("failed to convert ca chain certificiate field to string")
+		certCAs, err := caChain(certCAField)
+		if err != nil {
+			return "", "", err
 		}
 		certCA = strings.Join(certCAs, "\n")
 	} else {
@@ -133,21 +141,43 @@ func (c *Cert) decodeSec(sec *vault.Secret) (cert string, certCA string, err err
 	return cert, certCA, err
 }
 
+// caChain converts the ca chain field, which is decoded from the json
+// response of vault as a list of interfaces
+func caChain(field interface{}) ([]string, error) {
+	switch chain := field.(type) {
+	case []string:
+		return chain, nil
+	case []interface{}:
+		certCAs := make([]string, len(chain))
+		for n, certCA := range chain {
+			s, ok := certCA.(string)
+			if !ok {
+				return nil, fmt.Errorf("failed to convert ca chain certificate %d to string", n+1)
+			}
+			certCAs[n] = strings.TrimSpace(s)
+		}
+		return certCAs, nil
+	}
+
+	return nil, errors.New("failed to convert ca chain field to list of strings")
+}
+
 func (c *Cert) createCSR() (csr []byte, err error) {
 	names := pkix.Name{
 		CommonName:   c.CommonName(),
 		Organization: c.Organisation(),
 	}
-	var csrTemplate = x509.CertificateRequest{
-		Subject:            names,
-		SignatureAlgorithm: x509.SHA512WithRSA,
-	}
 
-	key, err := x509.ParsePKCS1PrivateKey(c.Data().Bytes)
+	key, _, err := parsePrivateKey(c.Data())
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse private key bytes: %v", err)
 	}
 
+	var csrTemplate = x509.CertificateRequest{
+		Subject:            names,
+		SignatureAlgorithm: signatureAlgorithm(key),
+	}
+
 	csrCertificate, err := x509.CreateCertificateRequest(rand.Reader, &csrTemplate, key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create CSR: %v", err)
@@ -176,30 +206,72 @@ func (c *Cert) writeCSR(path string, data map[string]interface{}) (secret *vault
 	return c.InstanceToken().VaultClient().Logical().Write(path, data)
 }
 
-func (c *Cert) storeCertificate(path, cert string) error {
-	fi, err := os.Create(path)
+type certFile struct {
+	name string
+	path string
+	data []byte
+	perm os.FileMode
+}
+
+// storeFiles stages the certificate, the ca certificate, a new key and the
+// output files, then moves them into place together. The previous files are
+// kept as .bak.
+func (c *Cert) storeFiles(cert, certCA string) error {
+	files := []certFile{
+		{"Certificate", c.CertFile(), []byte(cert), 0644},
+		{"CA certificate", c.CAFile(), []byte(certCA), 0644},
+	}
+	if c.newKey {
+		files = append(files, certFile{"Key", c.KeyFile(), pem.EncodeToMemory(c.Data()), 0600})
+	}
+
+	outputs, err := c.outputFiles(cert, certCA)
 	if err != nil {
-		return fmt.Errorf("failed to open file '%s': %v", path, err)
+		return err
 	}
-	defer fi.Close()
+	files = append(files, outputs...)
 
-	if _, err := fi.Write([]byte(cert)); err != nil {
-		return fmt.Errorf("failed to write certificate to file '%s': %v", path, err)
+	// staged files are removed on failure, or moved into place
+	var staged []file.StagedFile
+	defer func() {
+		for _, s := range staged {
+			os.Remove(s.Staged)
+		}
+	}()
+
+	for _, f := range files {
+		tmpPath, err := file.Stage(f.path, f.data, f.perm)
+		if err != nil {
+			return err
+		}
+		staged = append(staged, file.StagedFile{Path: f.path, Staged: tmpPath})
+
+		if err := c.WritePermissions(tmpPath, f.perm); err != nil {
+			return fmt.Errorf("failed to set permissons of file '%s': %s", f.path, err)
+		}
 	}
 
-	if err := c.WritePermissions(path, os.FileMode(0644)); err != nil {
-		return fmt.Errorf("failed to set permissons of certificate file '%s': %s", path, err)
+	if err := file.ReplaceAll(staged); err != nil {
+		return err
 	}
+	c.newKey = false
 
-	c.Log.Infof("Certificate written to: %s", path)
+	for _, f := range files {
+		c.Log.Infof("%s written to: %s", f.name, f.path)
+	}
 
 	return nil
 }
 
-func (c *Cert) certPath() string {
-	return filepath.Clean(c.Destination() + ".pem")
-}
+// filePath returns the override path, resolved against the directory of the
+// destination, or the destination with the default suffix
+func (c *Cert) filePath(override, suffix string) string {
+	switch {
+	case override == "":
+		return filepath.Clean(c.Destination() + suffix)
+	case filepath.IsAbs(override):
+		return filepath.Clean(override)
+	}
 
-func (c *Cert) caPath() string {
-	return filepath.Clean(c.Destination() + "-ca.pem")
+	return filepath.Join(filepath.Dir(c.Destination()), override)
 }
@@ -0,0 +1,152 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package cert
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const FlagAutoIPSans = "auto-ip-sans"
+const FlagAutoDNSSans = "auto-dns-sans"
+const FlagAutoIPSansInclude = "auto-ip-sans-include"
+const FlagAutoIPSansExclude = "auto-ip-sans-exclude"
+
+// interval of checking discovered SANs for changes with --watch
+const sanDiscoveryInterval = time.Minute
+
+// lookups of the host, replaced in tests
+var (
+	interfaceAddrs = net.InterfaceAddrs
+	hostname       = os.Hostname
+	lookupCNAME    = net.LookupCNAME
+)
+
+// ParseCIDRs parses the CIDRs of the include and exclude filters of the
+// discovered IP SANs
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr '%s': %v", cidr, err)
+		}
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+func (c *Cert) discoversSans() bool {
+	return c.AutoIPSans() || c.AutoDNSSans()
+}
+
+// discoverSans discovers the IP addresses of the interfaces and the names of
+// the host, replacing the previously discovered SANs
+func (c *Cert) discoverSans() error {
+	c.discoveredIPSans, c.discoveredSanHosts = nil, nil
+
+	if c.AutoIPSans() {
+		ips, err := discoverIPs(c.AutoIPSansInclude(), c.AutoIPSansExclude())
+		if err != nil {
+			return fmt.Errorf("error discovering ip sans: %v", err)
+		}
+		c.Log.Debugf("Discovered ip sans: %s", strings.Join(ips, ","))
+		c.discoveredIPSans = ips
+	}
+
+	if c.AutoDNSSans() {
+		hosts, err := discoverHostnames()
+		if err != nil {
+			return fmt.Errorf("error discovering host sans: %v", err)
+		}
+		c.Log.Debugf("Discovered host sans: %s", strings.Join(hosts, ","))
+		c.discoveredSanHosts = hosts
+	}
+
+	return nil
+}
+
+// discoverIPs returns the sorted global unicast addresses of the interfaces,
+// which are in any of the included networks, if given, and none of the
+// excluded networks
+func discoverIPs(include, exclude []*net.IPNet) ([]string, error) {
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []string
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() {
+			continue
+		}
+		if len(include) > 0 && !containsIP(include, ipNet.IP) {
+			continue
+		}
+		if containsIP(exclude, ipNet.IP) {
+			continue
+		}
+		ips = append(ips, ipNet.IP.String())
+	}
+	sort.Strings(ips)
+
+	return mergeSans(ips), nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// discoverHostnames returns the hostname and, if it resolves, the FQDN
+func discoverHostnames() ([]string, error) {
+	name, err := hostname()
+	if err != nil {
+		return nil, err
+	}
+	names := []string{strings.ToLower(name)}
+
+	if cname, err := lookupCNAME(name); err == nil {
+		if fqdn := strings.ToLower(strings.TrimSuffix(cname, ".")); fqdn != "" {
+			names = append(names, fqdn)
+		}
+	}
+
+	return mergeSans(names), nil
+}
+
+// allIPSans returns the requested and the discovered IP SANs
+func (c *Cert) allIPSans() []string {
+	return mergeSans(c.IPSans(), c.discoveredIPSans)
+}
+
+// allSanHosts returns the requested and the discovered host SANs
+func (c *Cert) allSanHosts() []string {
+	return mergeSans(c.SanHosts(), c.discoveredSanHosts)
+}
+
+// mergeSans returns the SANs without duplicates, keeping their order
+func mergeSans(sans ...[]string) []string {
+	seen := make(map[string]bool)
+	var merged []string
+	for _, s := range sans {
+		for _, san := range s {
+			if !seen[san] {
+				seen[san] = true
+				merged = append(merged, san)
+			}
+		}
+	}
+
+	return merged
+}
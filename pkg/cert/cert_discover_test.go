@@ -0,0 +1,144 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package cert
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeHost replaces the lookups of the host until the returned func is called
+func fakeHost(t *testing.T, addrs []string, name, cname string) func() {
+	var ipNets []net.Addr
+	for _, addr := range addrs {
+		ip, ipNet, err := net.ParseCIDR(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ipNet.IP = ip
+		ipNets = append(ipNets, ipNet)
+	}
+
+	origAddrs, origHostname, origCNAME := interfaceAddrs, hostname, lookupCNAME
+	interfaceAddrs = func() ([]net.Addr, error) { return ipNets, nil }
+	hostname = func() (string, error) { return name, nil }
+	lookupCNAME = func(host string) (string, error) {
+		if cname == "" {
+			return "", errors.New("no such host")
+		}
+		return cname, nil
+	}
+
+	return func() {
+		interfaceAddrs, hostname, lookupCNAME = origAddrs, origHostname, origCNAME
+	}
+}
+
+func TestCert_Discover_IPs(t *testing.T) {
+	defer fakeHost(t, []string{
+		"127.0.0.1/8",
+		"::1/128",
+		"fe80::1/64",
+		"169.254.1.1/16",
+		"10.0.1.5/24",
+		"172.17.0.1/16",
+		"192.168.10.2/24",
+		"2001:db8::5/64",
+	}, "node1", "")()
+
+	for _, r := range []struct {
+		include, exclude []string
+		exp              string
+	}{
+		{exp: "10.0.1.5,172.17.0.1,192.168.10.2,2001:db8::5"},
+		{include: []string{"10.0.0.0/8", "192.168.0.0/16"}, exp: "10.0.1.5,192.168.10.2"},
+		{exclude: []string{"172.17.0.0/16", "2001:db8::/32"}, exp: "10.0.1.5,192.168.10.2"},
+		{include: []string{"10.0.0.0/8"}, exclude: []string{"10.0.1.0/24"}, exp: ""},
+	} {
+		include, err := ParseCIDRs(r.include)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exclude, err := ParseCIDRs(r.exclude)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ips, err := discoverIPs(include, exclude)
+		if err != nil {
+			t.Fatalf("error discovering ips: %v", err)
+		}
+		if act := strings.Join(ips, ","); r.exp != act {
+			t.Errorf("unexpected ips for include=%v exclude=%v exp=%s got=%s", r.include, r.exclude, r.exp, act)
+		}
+	}
+
+	if _, err := ParseCIDRs([]string{"10.0.0.1"}); err == nil {
+		t.Error("expected error parsing address without prefix length")
+	}
+}
+
+func TestCert_Discover_Hostnames(t *testing.T) {
+	for _, r := range []struct {
+		name, cname, exp string
+	}{
+		{"node1", "node1.example.com.", "node1,node1.example.com"},
+		{"Node1", "", "node1"},
+		{"node1.example.com", "node1.example.com.", "node1.example.com"},
+	} {
+		restore := fakeHost(t, nil, r.name, r.cname)
+		hosts, err := discoverHostnames()
+		restore()
+		if err != nil {
+			t.Fatalf("error discovering hostnames: %v", err)
+		}
+		if act := strings.Join(hosts, ","); r.exp != act {
+			t.Errorf("unexpected hostnames exp=%s got=%s", r.exp, act)
+		}
+	}
+}
+
+// Discovered SANs are merged with the requested SANs, and changes of the
+// discovered SANs require a new certificate
+func TestCert_Discover_Renew(t *testing.T) {
+	defer fakeHost(t, []string{"127.0.0.1/8", "10.0.1.5/24"}, "node1", "")()
+
+	c := newVerifyTestCert(t)
+	c.SetAutoIPSans(true)
+	c.SetAutoDNSSans(true)
+	tmpl := validTemplate()
+	tmpl.DNSNames = append(tmpl.DNSNames, "node1")
+	tmpl.IPAddresses = append(tmpl.IPAddresses, net.ParseIP("10.0.1.5"))
+	newTestCA(t).sign(t, c, tmpl)
+
+	if err := c.discoverSans(); err != nil {
+		t.Fatalf("error discovering sans: %v", err)
+	}
+	if exp, act := "127.0.0.1,10.0.1.5", strings.Join(c.allIPSans(), ","); exp != act {
+		t.Errorf("unexpected ip sans exp=%s got=%s", exp, act)
+	}
+	if exp, act := "k8s.example.com,node1", strings.Join(c.allSanHosts(), ","); exp != act {
+		t.Errorf("unexpected host sans exp=%s got=%s", exp, act)
+	}
+
+	reason, err := c.renewalReason()
+	if err != nil {
+		t.Fatalf("error verifying certificate: %v", err)
+	}
+	if reason != "" {
+		t.Errorf("expected valid certificate to be kept, got reason: %s", reason)
+	}
+
+	defer fakeHost(t, []string{"127.0.0.1/8", "10.0.1.6/24"}, "node1", "")()
+	if err := c.discoverSans(); err != nil {
+		t.Fatalf("error discovering sans: %v", err)
+	}
+	reason, err = c.renewalReason()
+	if err != nil {
+		t.Fatalf("error verifying certificate: %v", err)
+	}
+	if !strings.Contains(reason, "ip sans have changed") {
+		t.Errorf("expected changed ip sans as reason, got: %s", reason)
+	}
+}
@@ -2,58 +2,70 @@
 package cert
 
 import (
-	"bufio"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+
+	"github.com/jetstack/vault-helper/pkg/file"
 )
 
-// Ensure -key.pem exists, and has correct size and key type
+// Ensure the key file exists, and has correct size and key type. A new key is
+// only kept in memory until the certificate for it has been issued.
 func (c *Cert) EnsureKey() error {
+	keyType, err := NormaliseKeyType(c.KeyType())
+	if err != nil {
+		return err
+	}
+	c.SetKeyType(keyType)
+	if err := validateKeyBitSize(c.KeyType(), c.BitSize()); err != nil {
+		return err
+	}
+	if err := validateKeyFormat(c.KeyType(), c.KeyFormat()); err != nil {
+		return err
+	}
+
 	if err := c.ensureDestination(); err != nil {
 		return fmt.Errorf("error ensuring destination: %v", err)
 	}
 
-	path := c.Destination() + "-key.pem"
-	_, err := os.Stat(path)
+	path := c.KeyFile()
+	_, err = os.Stat(path)
 
 	// Path doesn't exist
 	if err != nil && os.IsNotExist(err) {
 		c.Log.Debug("Pem file doesn't exist")
 		c.Log.Infof("Key doesn't exist at path: %s", path)
-		if err := c.genAndWriteKey(path); err != nil {
-			return err
-		}
-		return c.WritePermissions(path, os.FileMode(0600))
+		return c.generateNewKey()
 	}
 
 	//Path Exists
-	c.Log.Debug("Pem file exists '-key.pem'")
+	c.Log.Debug("Pem file exists")
 	if err := c.loadKeyFromFile(path); err != nil {
-		return fmt.Errorf("failed to load rsa key from file '%s': %v", path, err)
+		return fmt.Errorf("failed to load key from file '%s': %v", path, err)
 	}
+	c.newKey = false
 
-	if c.KeyType() != c.Data().Type {
-		c.Log.Warnf("key doesn't match expected type at path '%s'. exp=%s got=%s", path, c.KeyType(), c.Data().Type)
+	if c.KeyType() != c.PemKeyType() {
+		c.Log.Warnf("key doesn't match expected type at path '%s'. exp=%s got=%s", path, c.KeyType(), c.PemKeyType())
 		// Wrong key type
-		// Delete File, Generate new and write to file
-		if err := c.DeleteFile(path); err != nil {
-			return err
-		}
-		return c.genAndWriteKey(path)
+		// Generate new, replacing the file with the certificate
+		return c.generateNewKey()
 	}
 	if c.BitSize() != c.PemSize() {
 		c.Log.Infof("key doesn't match expected size at path '%s'. exp=%d got=%d", path, c.BitSize(), c.PemSize())
 		//Wrong bit size
-		// Delete file, generate new and write to file
-		if err := c.DeleteFile(path); err != nil {
+		// Generate new, replacing the file with the certificate
+		return c.generateNewKey()
+	}
+	if c.KeyFormat() != c.PemKeyFormat() {
+		c.Log.Infof("key doesn't match expected format at path '%s'. exp=%s got=%s", path, c.KeyFormat(), c.PemKeyFormat())
+		// Wrong format
+		// Keep the key, write it in the expected format
+		if err := c.reencodeKey(path); err != nil {
 			return err
 		}
-		return c.genAndWriteKey(path)
 	}
 
 	return c.WritePermissions(path, os.FileMode(0600))
@@ -73,7 +85,7 @@ func (c *Cert) ensureDestination() error {
 	if err != nil && os.IsNotExist(err) {
 		os.MkdirAll(dir, os.FileMode(0750))
 		c.Log.Debugf("Destination directory doesn't exist. Directory created: %s", dir)
-		return nil
+		return c.ensureFileDirectories()
 	}
 
 	// Exists but is not a directory
@@ -88,20 +100,33 @@ func (c *Cert) ensureDestination() error {
 
 	c.Log.Debugf("Destination directory exists")
 
+	return c.ensureFileDirectories()
+}
+
+// Ensure the directories of overridden file paths outside of the destination
+// directory exist. Their permissions are left unchanged.
+func (c *Cert) ensureFileDirectories() error {
+	for _, path := range []string{c.CertFile(), c.KeyFile(), c.CAFile()} {
+		dir := filepath.Dir(path)
+		if dir == filepath.Dir(c.Destination()) {
+			continue
+		}
+
+		if err := os.MkdirAll(dir, os.FileMode(0750)); err != nil {
+			return fmt.Errorf("failed to create directory '%s': %v", dir, err)
+		}
+	}
+
 	return nil
 }
 
-//Generate new key and write to file
-func (c *Cert) genAndWriteKey(path string) error {
-	c.Log.Infof("Generating new RSA key")
+//Generate new key, written to file with the certificate
+func (c *Cert) generateNewKey() error {
+	c.Log.Infof("Generating new %s key", c.KeyType())
 	if err := c.generateKey(); err != nil {
 		return fmt.Errorf("error generating key: %v", err)
 	}
-
-	if err := c.writeKeyToFile(path); err != nil {
-		return fmt.Errorf("error saving key to file '%s': %v", path, err)
-	}
-	c.Log.Infof("Key written to file: %s", path)
+	c.newKey = true
 
 	return nil
 }
@@ -109,61 +134,43 @@ func (c *Cert) genAndWriteKey(path string) error {
 func (c *Cert) loadKeyFromFile(path string) error {
 
 	// Load PEM
-	pemfile, err := os.Open(path)
+	pembytes, err := ioutil.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("unable to open file for reading '%s': %v", path, err)
+		return fmt.Errorf("unable to read file '%s': %v", path, err)
 	}
 
-	// need to convert pemfile to []byte for decoding
-	pemfileinfo, err := pemfile.Stat()
-	if err != nil {
-		return fmt.Errorf("unable to get pem file info '%s': %v", path, err)
+	data, _ := pem.Decode(pembytes)
+	if data == nil {
+		return fmt.Errorf("failed to decode pem file '%s'", path)
 	}
 
-	size := pemfileinfo.Size()
-	pembytes := make([]byte, size)
-
-	// read pemfile content into pembytes
-	buffer := bufio.NewReader(pemfile)
-	_, err = buffer.Read(pembytes)
+	key, format, err := parsePrivateKey(data)
 	if err != nil {
-		return fmt.Errorf("unable to read pembyte from file: %v", err)
-	}
-
-	data, rest := pem.Decode([]byte(pembytes))
-	if err != nil {
-		return fmt.Errorf("failed to decode pem file. There was data left: %s", rest)
+		return fmt.Errorf("failed to parse private key bytes: %v", err)
 	}
 
-	k, err := x509.ParsePKCS1PrivateKey(data.Bytes)
+	keyType, size, err := keyTypeAndSize(key)
 	if err != nil {
-		return fmt.Errorf("failed to parse private key bytes: %v", err)
+		return err
 	}
-
-	c.SetPemSize(k.N.BitLen())
+	c.SetPemKeyType(keyType)
+	c.SetPemSize(size)
+	c.SetPemKeyFormat(format)
 
 	c.SetData(data)
-	c.SetKeyType(data.Type)
-
-	pemfile.Close()
-	if err != nil {
-		return fmt.Errorf("unable to close pemfile: %v", err)
-	}
 
 	return nil
 }
 
 func (c *Cert) generateKey() error {
-	size := c.BitSize()
-	key, err := rsa.GenerateKey(rand.Reader, size)
+	key, err := generatePrivateKey(c.KeyType(), c.BitSize())
 	if err != nil {
-		return fmt.Errorf("failed to generate rsa key: %v", err)
+		return fmt.Errorf("failed to generate %s key: %v", c.KeyType(), err)
 	}
 
-	key_bytes := x509.MarshalPKCS1PrivateKey(key)
-	key_pem := &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: key_bytes,
+	key_pem, err := marshalPrivateKey(key, c.KeyFormat())
+	if err != nil {
+		return fmt.Errorf("failed to encode %s key: %v", c.KeyType(), err)
 	}
 
 	c.SetData(key_pem)
@@ -171,20 +178,23 @@ func (c *Cert) generateKey() error {
 	return nil
 }
 
-// Save PEM file
-func (c *Cert) writeKeyToFile(path string) error {
-	pemfile, err := os.Create(path)
+// Write the loaded key to file in the expected format
+func (c *Cert) reencodeKey(path string) error {
+	key, _, err := parsePrivateKey(c.Data())
 	if err != nil {
-		return fmt.Errorf("failed to create pem key file for writting: %v", err)
+		return fmt.Errorf("failed to parse private key bytes: %v", err)
 	}
 
-	if err := pem.Encode(pemfile, c.Data()); err != nil {
-		return fmt.Errorf("failed to encode key to pem file at'%s': %v", path, err)
+	key_pem, err := marshalPrivateKey(key, c.KeyFormat())
+	if err != nil {
+		return fmt.Errorf("failed to encode %s key: %v", c.KeyType(), err)
 	}
+	c.SetData(key_pem)
 
-	if err := pemfile.Close(); err != nil {
-		return fmt.Errorf("error closing pem file at '%s': %v", path, err)
+	if err := file.WriteAtomic(path, pem.EncodeToMemory(c.Data()), 0600); err != nil {
+		return fmt.Errorf("error saving key to file '%s': %v", path, err)
 	}
+	c.Log.Infof("Key written to file as %s: %s", c.KeyFormat(), path)
 
 	return nil
 }
@@ -0,0 +1,133 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package cert
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const FlagReloadExec = "reload-exec"
+const FlagReloadPidFile = "reload-pidfile"
+const FlagReloadSignal = "reload-signal"
+const FlagReloadSystemdUnit = "reload-systemd-unit"
+
+var signals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"TERM": syscall.SIGTERM,
+}
+
+// Hook is run after a certificate has been rotated, so that the consumers of
+// the certificate reload it
+type Hook interface {
+	Run() error
+	String() string
+}
+
+type execHook struct {
+	command string
+}
+
+// NewExecHook returns a hook running command with /bin/sh
+func NewExecHook(command string) Hook {
+	return &execHook{command: command}
+}
+
+func (h *execHook) Run() error {
+	out, err := exec.Command("/bin/sh", "-c", h.command).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error running command '%s': %v: %s", h.command, err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+func (h *execHook) String() string {
+	return fmt.Sprintf("exec '%s'", h.command)
+}
+
+type signalHook struct {
+	pidFile string
+	signal  syscall.Signal
+}
+
+// NewSignalHook returns a hook sending signal, such as HUP, SIGUSR1 or 10, to
+// the process of the pid in pidFile
+func NewSignalHook(pidFile, signal string) (Hook, error) {
+	sig, err := ParseSignal(signal)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signalHook{pidFile: pidFile, signal: sig}, nil
+}
+
+func (h *signalHook) Run() error {
+	dat, err := ioutil.ReadFile(h.pidFile)
+	if err != nil {
+		return fmt.Errorf("error reading pid file '%s': %v", h.pidFile, err)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(dat)))
+	if err != nil || pid <= 0 {
+		return fmt.Errorf("invalid pid in pid file '%s': '%s'", h.pidFile, strings.TrimSpace(string(dat)))
+	}
+
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return fmt.Errorf("error finding process %d: %v", pid, err)
+	}
+	if err := p.Signal(h.signal); err != nil {
+		return fmt.Errorf("error sending signal %s to process %d: %v", h.signal, pid, err)
+	}
+
+	return nil
+}
+
+func (h *signalHook) String() string {
+	return fmt.Sprintf("signal %s to pid of '%s'", h.signal, h.pidFile)
+}
+
+type systemdHook struct {
+	unit string
+}
+
+// NewSystemdHook returns a hook restarting the systemd unit
+func NewSystemdHook(unit string) Hook {
+	return &systemdHook{unit: unit}
+}
+
+func (h *systemdHook) Run() error {
+	out, err := exec.Command("systemctl", "restart", h.unit).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error restarting systemd unit '%s': %v: %s", h.unit, err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+func (h *systemdHook) String() string {
+	return fmt.Sprintf("restart of systemd unit '%s'", h.unit)
+}
+
+// ParseSignal parses a signal name, with or without the SIG prefix, or number
+func ParseSignal(signal string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(signal); err == nil && n > 0 {
+		return syscall.Signal(n), nil
+	}
+
+	if sig, ok := signals[strings.TrimPrefix(strings.ToUpper(signal), "SIG")]; ok {
+		return sig, nil
+	}
+
+	return 0, fmt.Errorf("unsupported signal '%s', supported signals are: HUP, INT, QUIT, KILL, USR1, USR2, TERM or a signal number", signal)
+}
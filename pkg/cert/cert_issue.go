@@ -0,0 +1,130 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package cert
+
+import (
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+const FlagMode = "mode"
+
+const (
+	// ModeSign generates the key locally and signs a CSR with the sign
+	// endpoint of the role
+	ModeSign = "sign"
+	// ModeIssue lets vault generate the key with the issue endpoint of the
+	// role, returning the private key once
+	ModeIssue = "issue"
+)
+
+func NormaliseMode(mode string) (string, error) {
+	switch m := strings.ToLower(mode); m {
+	case ModeSign, ModeIssue:
+		return m, nil
+	}
+
+	return "", fmt.Errorf("unsupported mode '%s', supported modes are: %s, %s", mode, ModeSign, ModeIssue)
+}
+
+// issuePath returns the issue path of the role's sign path
+func issuePath(role string) (string, error) {
+	if strings.Contains(role, "/issue/") {
+		return role, nil
+	}
+
+	i := strings.LastIndex(role, "/sign/")
+	if i <= 0 {
+		return "", fmt.Errorf("role path '%s' is not a sign or issue path of a pki backend", role)
+	}
+
+	return role[:i] + "/issue/" + role[i+len("/sign/"):], nil
+}
+
+// loadExistingKey loads the key issued with the existing certificate, which
+// is only used to verify the certificate. No key is generated.
+func (c *Cert) loadExistingKey() error {
+	if err := c.ensureDestination(); err != nil {
+		return fmt.Errorf("error ensuring destination: %v", err)
+	}
+
+	c.SetData(nil)
+	c.newKey = false
+
+	path := c.KeyFile()
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		c.Log.Infof("Key doesn't exist at path: %s", path)
+		return nil
+	}
+
+	if err := c.loadKeyFromFile(path); err != nil {
+		c.Log.Warnf("failed to load key from file '%s': %v", path, err)
+		c.SetData(nil)
+		return nil
+	}
+
+	// the key of the role is kept, only its encoding follows the key format
+	if c.keyFormat != "" && c.keyFormat != c.PemKeyFormat() {
+		if err := validateKeyFormat(c.PemKeyType(), c.keyFormat); err != nil {
+			return err
+		}
+		c.Log.Infof("key doesn't match expected format at path '%s'. exp=%s got=%s", path, c.keyFormat, c.PemKeyFormat())
+		if err := c.reencodeKey(path); err != nil {
+			return err
+		}
+		c.SetPemKeyFormat(c.keyFormat)
+	}
+
+	return nil
+}
+
+func (c *Cert) writeIssue(path string, data map[string]interface{}) (*vault.Secret, error) {
+	return c.InstanceToken().VaultClient().Logical().Write(path, data)
+}
+
+// decodeIssuedKey decodes the private key returned by the issue endpoint,
+// encoded in the key format, as the new key of the certificate
+func (c *Cert) decodeIssuedKey(sec *vault.Secret) error {
+	keyField, ok := sec.Data["private_key"].(string)
+	if !ok || keyField == "" {
+		return errors.New("private key field not found")
+	}
+
+	block, _ := pem.Decode([]byte(keyField))
+	if block == nil {
+		return errors.New("failed to decode private key")
+	}
+	key, _, err := parsePrivateKey(block)
+	if err != nil {
+		return fmt.Errorf("failed to parse private key bytes: %v", err)
+	}
+
+	keyType, size, err := keyTypeAndSize(key)
+	if err != nil {
+		return err
+	}
+	format := c.keyFormat
+	if format == "" {
+		format = DefaultKeyFormat(keyType)
+	}
+	if err := validateKeyFormat(keyType, format); err != nil {
+		return err
+	}
+
+	keyPEM, err := marshalPrivateKey(key, format)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s key: %v", keyType, err)
+	}
+
+	c.SetPemKeyType(keyType)
+	c.SetPemSize(size)
+	c.SetPemKeyFormat(format)
+	c.SetData(keyPEM)
+	c.newKey = true
+
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package cert
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+func TestCert_Issue_Path(t *testing.T) {
+	for role, exp := range map[string]string{
+		"test-cluster/pki/k8s/sign/kube-apiserver":  "test-cluster/pki/k8s/issue/kube-apiserver",
+		"test-cluster/pki/k8s/issue/kube-apiserver": "test-cluster/pki/k8s/issue/kube-apiserver",
+		"test-cluster/pki/sign/sign/sign":           "test-cluster/pki/sign/issue/sign",
+	} {
+		path, err := issuePath(role)
+		if err != nil {
+			t.Errorf("error rewriting role '%s': %v", role, err)
+		}
+		if exp != path {
+			t.Errorf("unexpected issue path exp=%s got=%s", exp, path)
+		}
+	}
+
+	if _, err := issuePath("test-cluster/pki/k8s/roles/kube-apiserver"); err == nil {
+		t.Error("expected error for role without sign path")
+	}
+	if _, err := NormaliseMode("csr"); err == nil {
+		t.Error("expected error for unsupported mode")
+	}
+}
+
+// An issued key is stored with the certificate, and is only read afterwards
+func TestCert_Issue_Key(t *testing.T) {
+	c := newKeyTestCert(t, KeyTypeRSA, 2048)
+	c.SetRole("test-role")
+	c.SetMode(ModeIssue)
+	c.SetKeyFormat(KeyFormatPKCS8)
+
+	if err := c.loadExistingKey(); err != nil {
+		t.Fatalf("error loading key: %v", err)
+	}
+	if c.Data() != nil {
+		t.Fatal("expected no key to be generated")
+	}
+
+	// vault generates an ecdsa key for the role
+	issued := newVerifyTestCert(t)
+	ca := newTestCA(t)
+	ca.sign(t, issued, validTemplate())
+	cert, err := ioutil.ReadFile(issued.CertFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sec := &vault.Secret{Data: map[string]interface{}{
+		"private_key": string(pem.EncodeToMemory(issued.Data())),
+	}}
+	if err := c.decodeIssuedKey(sec); err != nil {
+		t.Fatalf("error decoding issued key: %v", err)
+	}
+	if exp, act := "PRIVATE KEY", c.Data().Type; exp != act {
+		t.Errorf("unexpected pem type of issued key exp=%s got=%s", exp, act)
+	}
+
+	c.SetSanHosts([]string{"k8s.example.com"})
+	c.SetIPSans([]string{"127.0.0.1"})
+	c.SetOrganisation([]string{"system:masters"})
+	if err := c.storeFiles(string(cert), string(ca.pem)); err != nil {
+		t.Fatalf("error storing files: %v", err)
+	}
+	fi, err := os.Stat(c.KeyFile())
+	if err != nil {
+		t.Fatalf("expected issued key to be written: %v", err)
+	}
+	if exp, act := os.FileMode(0600), fi.Mode().Perm(); exp != act {
+		t.Errorf("unexpected mode of key exp=%s got=%s", exp, act)
+	}
+
+	if err := c.loadExistingKey(); err != nil {
+		t.Fatalf("error loading key: %v", err)
+	}
+	if c.PemKeyType() != KeyTypeECDSA || c.newKey {
+		t.Errorf("expected issued ecdsa key to be loaded, got %s", c.PemKeyType())
+	}
+	reason, err := c.renewalReason()
+	if err != nil {
+		t.Fatalf("error verifying certificate: %v", err)
+	}
+	if reason != "" {
+		t.Errorf("expected valid certificate to be kept, got reason: %s", reason)
+	}
+
+	// a changed key format rewrites the existing key
+	c.SetKeyFormat(KeyFormatSEC1)
+	if err := c.loadExistingKey(); err != nil {
+		t.Fatalf("error loading key: %v", err)
+	}
+	if err := c.loadKeyFromFile(c.KeyFile()); err != nil {
+		t.Fatalf("error reading rewritten key: %v", err)
+	}
+	if exp, act := KeyFormatSEC1, c.PemKeyFormat(); exp != act {
+		t.Errorf("unexpected format of rewritten key exp=%s got=%s", exp, act)
+	}
+	if reason, err := c.renewalReason(); err != nil || reason != "" {
+		t.Errorf("expected rewritten key to keep the certificate, got: %s %v", reason, err)
+	}
+
+	if err := os.Remove(c.KeyFile()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.loadExistingKey(); err != nil {
+		t.Fatalf("error loading key: %v", err)
+	}
+	if reason, err := c.renewalReason(); err != nil || reason != "no key exists" {
+		t.Errorf("expected missing key as reason, got: %s %v", reason, err)
+	}
+}
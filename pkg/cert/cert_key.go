@@ -0,0 +1,240 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package cert
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+const (
+	KeyTypeRSA     = "rsa"
+	KeyTypeECDSA   = "ecdsa"
+	KeyTypeEd25519 = "ed25519"
+)
+
+const (
+	KeyFormatPKCS1 = "pkcs1"
+	KeyFormatPKCS8 = "pkcs8"
+	KeyFormatSEC1  = "sec1"
+)
+
+// NormaliseKeyType returns the key type in lower case, so that the former
+// default "RSA" is still accepted
+func NormaliseKeyType(keyType string) (string, error) {
+	switch t := strings.ToLower(keyType); t {
+	case KeyTypeRSA, KeyTypeECDSA, KeyTypeEd25519:
+		return t, nil
+	}
+
+	return "", fmt.Errorf("unsupported key type '%s', supported types are: %s, %s, %s", keyType, KeyTypeRSA, KeyTypeECDSA, KeyTypeEd25519)
+}
+
+// DefaultKeyBitSize returns the bit size used if none is given for the key type
+func DefaultKeyBitSize(keyType string) int {
+	switch strings.ToLower(keyType) {
+	case KeyTypeECDSA, KeyTypeEd25519:
+		return 256
+	}
+
+	return 2048
+}
+
+func validateKeyBitSize(keyType string, size int) error {
+	switch keyType {
+	case KeyTypeRSA:
+		if size < 1024 {
+			return fmt.Errorf("rsa key bit size %d is too small, must be at least 1024", size)
+		}
+	case KeyTypeECDSA:
+		if _, err := ellipticCurve(size); err != nil {
+			return err
+		}
+	case KeyTypeEd25519:
+		if size != 256 {
+			return fmt.Errorf("ed25519 keys have a fixed bit size of 256, got %d", size)
+		}
+	}
+
+	return nil
+}
+
+// DefaultKeyFormat returns the encoding used if none is given for the key
+// type: PKCS#1 for RSA, SEC 1 for ECDSA and PKCS#8, the only encoding of
+// Ed25519 keys, for Ed25519
+func DefaultKeyFormat(keyType string) string {
+	switch strings.ToLower(keyType) {
+	case KeyTypeECDSA:
+		return KeyFormatSEC1
+	case KeyTypeEd25519:
+		return KeyFormatPKCS8
+	}
+
+	return KeyFormatPKCS1
+}
+
+func validateKeyFormat(keyType, format string) error {
+	switch format {
+	case KeyFormatPKCS8:
+		return nil
+	case KeyFormatPKCS1:
+		if keyType == KeyTypeRSA {
+			return nil
+		}
+	case KeyFormatSEC1:
+		if keyType == KeyTypeECDSA {
+			return nil
+		}
+	default:
+		return fmt.Errorf("unsupported key format '%s', supported formats are: %s, %s, %s", format, KeyFormatPKCS1, KeyFormatPKCS8, KeyFormatSEC1)
+	}
+
+	return fmt.Errorf("key format %s is not supported for %s keys", format, keyType)
+}
+
+func ellipticCurve(size int) (elliptic.Curve, error) {
+	switch size {
+	case 256:
+		return elliptic.P256(), nil
+	case 384:
+		return elliptic.P384(), nil
+	case 521:
+		return elliptic.P521(), nil
+	}
+
+	return nil, fmt.Errorf("unsupported ecdsa key bit size %d, supported sizes are: 256, 384, 521", size)
+}
+
+func generatePrivateKey(keyType string, size int) (crypto.Signer, error) {
+	switch keyType {
+	case KeyTypeRSA:
+		return rsa.GenerateKey(rand.Reader, size)
+	case KeyTypeECDSA:
+		curve, err := ellipticCurve(size)
+		if err != nil {
+			return nil, err
+		}
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	case KeyTypeEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+
+	return nil, fmt.Errorf("unsupported key type '%s'", keyType)
+}
+
+// marshalPrivateKey encodes the key in the given format
+func marshalPrivateKey(key crypto.Signer, format string) (*pem.Block, error) {
+	if format == KeyFormatPKCS8 {
+		b, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: "PRIVATE KEY", Bytes: b}, nil
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if format == KeyFormatPKCS1 {
+			return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}, nil
+		}
+	case *ecdsa.PrivateKey:
+		if format == KeyFormatSEC1 {
+			b, err := x509.MarshalECPrivateKey(k)
+			if err != nil {
+				return nil, err
+			}
+			return &pem.Block{Type: "EC PRIVATE KEY", Bytes: b}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unable to encode private key %T as %s", key, format)
+}
+
+type keyParser struct {
+	format string
+	parse  func([]byte) (interface{}, error)
+}
+
+var keyParsers = map[string]keyParser{
+	"RSA PRIVATE KEY": {KeyFormatPKCS1, func(b []byte) (interface{}, error) { return x509.ParsePKCS1PrivateKey(b) }},
+	"EC PRIVATE KEY":  {KeyFormatSEC1, func(b []byte) (interface{}, error) { return x509.ParseECPrivateKey(b) }},
+	"PRIVATE KEY":     {KeyFormatPKCS8, x509.ParsePKCS8PrivateKey},
+}
+
+// parsePrivateKey parses PKCS#1, PKCS#8 and SEC 1 keys, returning the format
+// of the key. The parser matching the pem block type is tried first, then the
+// others, as not every tool writes the block type of the encoding.
+func parsePrivateKey(block *pem.Block) (key crypto.Signer, format string, err error) {
+	parsers := []keyParser{}
+	if p, ok := keyParsers[block.Type]; ok {
+		parsers = append(parsers, p)
+	}
+	for _, t := range []string{"PRIVATE KEY", "RSA PRIVATE KEY", "EC PRIVATE KEY"} {
+		if t != block.Type {
+			parsers = append(parsers, keyParsers[t])
+		}
+	}
+
+	var firstErr error
+	for _, p := range parsers {
+		k, err := p.parse(block.Bytes)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		signer, ok := k.(crypto.Signer)
+		if !ok {
+			return nil, "", fmt.Errorf("unsupported private key %T", k)
+		}
+		if _, _, err := keyTypeAndSize(signer); err != nil {
+			return nil, "", err
+		}
+
+		return signer, p.format, nil
+	}
+
+	return nil, "", fmt.Errorf("unable to parse pem block '%s' as PKCS#1, PKCS#8 or SEC 1 private key: %v", block.Type, firstErr)
+}
+
+func keyTypeAndSize(key crypto.Signer) (keyType string, size int, err error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return KeyTypeRSA, k.N.BitLen(), nil
+	case *ecdsa.PrivateKey:
+		return KeyTypeECDSA, k.Curve.Params().BitSize, nil
+	case ed25519.PrivateKey:
+		return KeyTypeEd25519, 256, nil
+	}
+
+	return "", 0, fmt.Errorf("unsupported private key %T", key)
+}
+
+func signatureAlgorithm(key crypto.Signer) x509.SignatureAlgorithm {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return x509.SHA512WithRSA
+	case *ecdsa.PrivateKey:
+		switch k.Curve.Params().BitSize {
+		case 384:
+			return x509.ECDSAWithSHA384
+		case 521:
+			return x509.ECDSAWithSHA512
+		}
+		return x509.ECDSAWithSHA256
+	case ed25519.PrivateKey:
+		return x509.PureEd25519
+	}
+
+	return x509.UnknownSignatureAlgorithm
+}
@@ -0,0 +1,313 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package cert
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newKeyTestCert(t *testing.T, keyType string, size int) *Cert {
+	dir, err := ioutil.TempDir("", "test-cert-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tempDirs = append(tempDirs, dir)
+
+	c := New(logrus.NewEntry(logrus.New()), nil)
+	c.SetCommonName("k8s")
+	c.SetDestination(dir + "/test")
+	c.SetKeyType(keyType)
+	c.SetBitSize(size)
+
+	usr, err := user.Current()
+	if err != nil {
+		t.Fatalf("error getting info on current user: %v", err)
+	}
+	c.SetOwner(usr.Username)
+	c.SetGroup(usr.Username)
+
+	return c
+}
+
+// writeKey writes the key of c to file, as done once the certificate for a new
+// key has been issued
+func writeKey(t *testing.T, c *Cert) {
+	if err := ioutil.WriteFile(c.KeyFile(), pem.EncodeToMemory(c.Data()), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCert_Key_Types(t *testing.T) {
+	for _, k := range []struct {
+		keyType   string
+		size      int
+		pemType   string
+		algorithm x509.SignatureAlgorithm
+	}{
+		{"RSA", 2048, "RSA PRIVATE KEY", x509.SHA512WithRSA},
+		{KeyTypeECDSA, 256, "EC PRIVATE KEY", x509.ECDSAWithSHA256},
+		{KeyTypeECDSA, 384, "EC PRIVATE KEY", x509.ECDSAWithSHA384},
+		{KeyTypeECDSA, 521, "EC PRIVATE KEY", x509.ECDSAWithSHA512},
+		{KeyTypeEd25519, 256, "PRIVATE KEY", x509.PureEd25519},
+	} {
+		c := newKeyTestCert(t, k.keyType, k.size)
+
+		if err := c.EnsureKey(); err != nil {
+			t.Fatalf("%s %d: error ensuring key: %v", k.keyType, k.size, err)
+		}
+		if exp, act := k.pemType, c.Data().Type; exp != act {
+			t.Errorf("%s %d: unexpected pem type exp=%s got=%s", k.keyType, k.size, exp, act)
+		}
+
+		// existing key of the right type and size is kept
+		writeKey(t, c)
+		data := c.Data()
+		if err := c.EnsureKey(); err != nil {
+			t.Fatalf("%s %d: error ensuring existing key: %v", k.keyType, k.size, err)
+		}
+		if string(data.Bytes) != string(c.Data().Bytes) {
+			t.Errorf("%s %d: expected existing key to be kept", k.keyType, k.size)
+		}
+
+		csr, err := c.createCSR()
+		if err != nil {
+			t.Fatalf("%s %d: error creating CSR: %v", k.keyType, k.size, err)
+		}
+		block, _ := pem.Decode(csr)
+		req, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil {
+			t.Fatalf("%s %d: error parsing CSR: %v", k.keyType, k.size, err)
+		}
+		if exp, act := k.algorithm, req.SignatureAlgorithm; exp != act {
+			t.Errorf("%s %d: unexpected CSR signature algorithm exp=%s got=%s", k.keyType, k.size, exp, act)
+		}
+		if err := req.CheckSignature(); err != nil {
+			t.Errorf("%s %d: invalid CSR signature: %v", k.keyType, k.size, err)
+		}
+	}
+}
+
+// Existing key of another type or size is replaced
+func TestCert_Key_Mismatch(t *testing.T) {
+	c := newKeyTestCert(t, KeyTypeRSA, 2048)
+	if err := c.EnsureKey(); err != nil {
+		t.Fatalf("error ensuring key: %v", err)
+	}
+	writeKey(t, c)
+
+	c.SetKeyType(KeyTypeECDSA)
+	c.SetBitSize(384)
+	if err := c.EnsureKey(); err != nil {
+		t.Fatalf("error ensuring key: %v", err)
+	}
+	writeKey(t, c)
+	if err := c.loadKeyFromFile(c.Destination() + "-key.pem"); err != nil {
+		t.Fatalf("error loading key: %v", err)
+	}
+	if exp, act := KeyTypeECDSA, c.PemKeyType(); exp != act {
+		t.Errorf("unexpected key type exp=%s got=%s", exp, act)
+	}
+	if exp, act := 384, c.PemSize(); exp != act {
+		t.Errorf("unexpected key size exp=%d got=%d", exp, act)
+	}
+
+	c.SetBitSize(256)
+	if err := c.EnsureKey(); err != nil {
+		t.Fatalf("error ensuring key: %v", err)
+	}
+	writeKey(t, c)
+	if err := c.loadKeyFromFile(c.Destination() + "-key.pem"); err != nil {
+		t.Fatalf("error loading key: %v", err)
+	}
+	if exp, act := 256, c.PemSize(); exp != act {
+		t.Errorf("unexpected key size exp=%d got=%d", exp, act)
+	}
+}
+
+func TestCert_Key_Invalid(t *testing.T) {
+	for _, k := range []struct {
+		keyType string
+		size    int
+	}{
+		{"dsa", 2048},
+		{KeyTypeECDSA, 2048},
+		{KeyTypeEd25519, 512},
+		{KeyTypeRSA, 512},
+	} {
+		c := newKeyTestCert(t, k.keyType, k.size)
+		if err := c.EnsureKey(); err == nil {
+			t.Errorf("expected error for key type %s with bit size %d", k.keyType, k.size)
+		}
+	}
+}
+
+func TestCert_Key_Formats(t *testing.T) {
+	for _, k := range []struct {
+		keyType string
+		size    int
+		format  string
+		pemType string
+	}{
+		{KeyTypeRSA, 2048, KeyFormatPKCS8, "PRIVATE KEY"},
+		{KeyTypeECDSA, 256, KeyFormatPKCS8, "PRIVATE KEY"},
+		{KeyTypeEd25519, 256, KeyFormatPKCS8, "PRIVATE KEY"},
+		{KeyTypeRSA, 2048, KeyFormatPKCS1, "RSA PRIVATE KEY"},
+		{KeyTypeECDSA, 256, KeyFormatSEC1, "EC PRIVATE KEY"},
+	} {
+		c := newKeyTestCert(t, k.keyType, k.size)
+		c.SetKeyFormat(k.format)
+
+		if err := c.EnsureKey(); err != nil {
+			t.Fatalf("%s %s: error ensuring key: %v", k.keyType, k.format, err)
+		}
+		writeKey(t, c)
+		if err := c.loadKeyFromFile(c.Destination() + "-key.pem"); err != nil {
+			t.Fatalf("%s %s: error loading key: %v", k.keyType, k.format, err)
+		}
+		if exp, act := k.pemType, c.Data().Type; exp != act {
+			t.Errorf("%s %s: unexpected pem type exp=%s got=%s", k.keyType, k.format, exp, act)
+		}
+		if exp, act := k.format, c.PemKeyFormat(); exp != act {
+			t.Errorf("%s %s: unexpected key format exp=%s got=%s", k.keyType, k.format, exp, act)
+		}
+	}
+
+	for _, k := range []struct {
+		keyType string
+		format  string
+	}{
+		{KeyTypeRSA, KeyFormatSEC1},
+		{KeyTypeECDSA, KeyFormatPKCS1},
+		{KeyTypeEd25519, KeyFormatPKCS1},
+		{KeyTypeRSA, "der"},
+	} {
+		c := newKeyTestCert(t, k.keyType, DefaultKeyBitSize(k.keyType))
+		c.SetKeyFormat(k.format)
+		if err := c.EnsureKey(); err == nil {
+			t.Errorf("expected error for key type %s with format %s", k.keyType, k.format)
+		}
+	}
+}
+
+// Pre-provisioned PKCS#8 key is kept and written in the expected format
+func TestCert_Key_Existing_Format(t *testing.T) {
+	c := newKeyTestCert(t, KeyTypeRSA, 2048)
+	c.SetKeyFormat(KeyFormatPKCS8)
+	if err := c.EnsureKey(); err != nil {
+		t.Fatalf("error ensuring key: %v", err)
+	}
+	writeKey(t, c)
+	pkcs8, _, err := parsePrivateKey(c.Data())
+	if err != nil {
+		t.Fatalf("error parsing key: %v", err)
+	}
+
+	c.SetKeyFormat("")
+	if err := c.EnsureKey(); err != nil {
+		t.Fatalf("error ensuring key: %v", err)
+	}
+	writeKey(t, c)
+	if err := c.loadKeyFromFile(c.Destination() + "-key.pem"); err != nil {
+		t.Fatalf("error loading key: %v", err)
+	}
+	if exp, act := "RSA PRIVATE KEY", c.Data().Type; exp != act {
+		t.Errorf("unexpected pem type exp=%s got=%s", exp, act)
+	}
+	pkcs1, _, err := parsePrivateKey(c.Data())
+	if err != nil {
+		t.Fatalf("error parsing key: %v", err)
+	}
+	if pkcs1.(*rsa.PrivateKey).N.Cmp(pkcs8.(*rsa.PrivateKey).N) != 0 {
+		t.Error("expected existing key to be kept when changing format")
+	}
+
+	// PKCS#8 key with the PKCS#1 pem block type is parsed, not regenerated
+	block, err := marshalPrivateKey(pkcs1, KeyFormatPKCS8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.Type = "RSA PRIVATE KEY"
+	key, format, err := parsePrivateKey(block)
+	if err != nil {
+		t.Fatalf("error parsing mislabeled key: %v", err)
+	}
+	if exp, act := KeyFormatPKCS8, format; exp != act {
+		t.Errorf("unexpected key format exp=%s got=%s", exp, act)
+	}
+	if key.(*rsa.PrivateKey).N.Cmp(pkcs1.(*rsa.PrivateKey).N) != 0 {
+		t.Error("unexpected key parsed from mislabeled pem block")
+	}
+}
+
+// New key is not written before the certificate has been issued
+func TestCert_Key_Staged(t *testing.T) {
+	c := newKeyTestCert(t, KeyTypeRSA, 2048)
+	if err := c.EnsureKey(); err != nil {
+		t.Fatalf("error ensuring key: %v", err)
+	}
+	if !c.newKey {
+		t.Error("expected key to be new")
+	}
+	if _, err := os.Stat(c.KeyFile()); !os.IsNotExist(err) {
+		t.Errorf("expected new key not to be written, got: %v", err)
+	}
+
+	writeKey(t, c)
+	old, err := ioutil.ReadFile(c.KeyFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.SetKeyType(KeyTypeECDSA)
+	c.SetBitSize(256)
+	if err := c.EnsureKey(); err != nil {
+		t.Fatalf("error ensuring key: %v", err)
+	}
+	if !c.newKey {
+		t.Error("expected key to be new")
+	}
+	dat, err := ioutil.ReadFile(c.KeyFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(old) != string(dat) {
+		t.Error("expected existing key to be kept until the certificate is issued")
+	}
+
+	// key is written with the certificate, keeping the previous key
+	if err := c.storeFiles("new-cert", "new-ca"); err != nil {
+		t.Fatalf("error storing files: %v", err)
+	}
+	if err := c.loadKeyFromFile(c.KeyFile()); err != nil {
+		t.Fatalf("error loading key: %v", err)
+	}
+	if exp, act := KeyTypeECDSA, c.PemKeyType(); exp != act {
+		t.Errorf("unexpected key type exp=%s got=%s", exp, act)
+	}
+	dat, err = ioutil.ReadFile(c.KeyFile() + ".bak")
+	if err != nil {
+		t.Fatalf("error reading key backup: %v", err)
+	}
+	if string(old) != string(dat) {
+		t.Error("expected previous key to be kept as backup")
+	}
+	for path, exp := range map[string]string{c.CertFile(): "new-cert", c.CAFile(): "new-ca"} {
+		dat, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(dat) != exp {
+			t.Errorf("unexpected content of %s exp=%s got=%s", path, exp, dat)
+		}
+	}
+	if c.newKey {
+		t.Error("expected key not to be new once written")
+	}
+}
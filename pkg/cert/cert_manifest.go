@@ -0,0 +1,290 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package cert
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+
+	"github.com/jetstack/vault-helper/pkg/instanceToken"
+)
+
+const FlagParallelism = "parallelism"
+
+const DefaultParallelism = 4
+
+// Manifest lists the certificates issued by a single run of certs apply
+type Manifest struct {
+	Certificates []ManifestEntry `yaml:"certificates"`
+}
+
+// ManifestEntry describes a certificate of a manifest, with the same settings
+// as the flags of the cert command. Relative destinations are relative to the
+// directory of the manifest.
+type ManifestEntry struct {
+	Role                 string        `yaml:"role"`
+	CommonName           string        `yaml:"commonName"`
+	Destination          string        `yaml:"destination"`
+	SanHosts             []string      `yaml:"sanHosts"`
+	IPSans               []string      `yaml:"ipSans"`
+	AutoIPSans           bool          `yaml:"autoIPSans"`
+	AutoIPSansInclude    []string      `yaml:"autoIPSansInclude"`
+	AutoIPSansExclude    []string      `yaml:"autoIPSansExclude"`
+	AutoDNSSans          bool          `yaml:"autoDNSSans"`
+	URISans              []string      `yaml:"uriSans"`
+	OtherSans            []string      `yaml:"otherSans"`
+	ExcludeCNFromSans    bool          `yaml:"excludeCNFromSans"`
+	TTL                  time.Duration `yaml:"ttl"`
+	Organisation         []string      `yaml:"organisation"`
+	Mode                 string        `yaml:"mode"`
+	Owner                string        `yaml:"owner"`
+	Group                string        `yaml:"group"`
+	KeyType              string        `yaml:"keyType"`
+	KeyBitSize           int           `yaml:"keyBitSize"`
+	KeyFormat            string        `yaml:"keyFormat"`
+	CertFile             string        `yaml:"certFile"`
+	KeyFile              string        `yaml:"keyFile"`
+	CAFile               string        `yaml:"caFile"`
+	OutputFormats        []string      `yaml:"outputFormats"`
+	KeystorePasswordFile string        `yaml:"keystorePasswordFile"`
+	RenewBefore          time.Duration `yaml:"renewBefore"`
+}
+
+// LoadManifest reads a manifest from a yaml file
+func LoadManifest(path string) (*Manifest, error) {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest '%s': %v", path, err)
+	}
+
+	m := new(Manifest)
+	if err := yaml.UnmarshalStrict(dat, m); err != nil {
+		return nil, fmt.Errorf("error parsing manifest '%s': %v", path, err)
+	}
+	if len(m.Certificates) == 0 {
+		return nil, fmt.Errorf("manifest '%s' contains no certificates", path)
+	}
+
+	dir := filepath.Dir(path)
+	for n := range m.Certificates {
+		e := &m.Certificates[n]
+		if e.Role == "" || e.CommonName == "" || e.Destination == "" {
+			return nil, fmt.Errorf("certificate %d of manifest '%s' requires role, commonName and destination", n+1, path)
+		}
+		if mode, err := NormaliseMode(e.Mode); err == nil && mode == ModeIssue && (e.KeyType != "" || e.KeyBitSize != 0) {
+			return nil, fmt.Errorf("certificate %d of manifest '%s' can't set keyType or keyBitSize with mode issue, the key type and size are set by the role", n+1, path)
+		}
+		if !filepath.IsAbs(e.Destination) {
+			e.Destination = filepath.Join(dir, e.Destination)
+		}
+	}
+
+	if err := m.checkFiles(); err != nil {
+		return nil, fmt.Errorf("error in manifest '%s': %v", path, err)
+	}
+
+	return m, nil
+}
+
+// checkFiles returns an error if entries share a destination or any of the
+// certificate, key and CA files, as certificates applied in parallel would
+// overwrite each other's files
+func (m *Manifest) checkFiles() error {
+	destinations := make(map[string]int)
+	files := make(map[string]int)
+
+	for n, e := range m.Certificates {
+		c := new(Cert)
+		c.SetDestination(filepath.Clean(e.Destination))
+		c.SetCertFile(e.CertFile)
+		c.SetKeyFile(e.KeyFile)
+		c.SetCAFile(e.CAFile)
+
+		if other, ok := destinations[c.Destination()]; ok {
+			return fmt.Errorf("certificates %d and %d have the same destination '%s'", other+1, n+1, c.Destination())
+		}
+		destinations[c.Destination()] = n
+
+		for _, path := range []string{c.CertFile(), c.KeyFile(), c.CAFile()} {
+			if other, ok := files[path]; ok {
+				return fmt.Errorf("certificates %d and %d both write the file '%s'", other+1, n+1, path)
+			}
+			files[path] = n
+		}
+	}
+
+	return nil
+}
+
+// Certs returns a Cert for each entry of the manifest, sharing the instance
+// token
+func (m *Manifest) Certs(logger *logrus.Entry, i *instanceToken.InstanceToken) ([]*Cert, error) {
+	var certs []*Cert
+	for _, e := range m.Certificates {
+		c, err := e.cert(logger, i)
+		if err != nil {
+			return nil, fmt.Errorf("error in certificate '%s': %v", e.Destination, err)
+		}
+		certs = append(certs, c)
+	}
+
+	return certs, nil
+}
+
+func (e *ManifestEntry) cert(logger *logrus.Entry, i *instanceToken.InstanceToken) (*Cert, error) {
+	if logger != nil {
+		logger = logger.WithField("destination", e.Destination)
+	}
+	c := New(logger, i)
+
+	c.SetRole(e.Role)
+	c.SetCommonName(e.CommonName)
+	c.SetDestination(filepath.Clean(e.Destination))
+	c.SetSanHosts(e.SanHosts)
+	c.SetIPSans(e.IPSans)
+	c.SetAutoIPSans(e.AutoIPSans)
+	c.SetAutoDNSSans(e.AutoDNSSans)
+	c.SetURISans(e.URISans)
+	c.SetExcludeCNFromSans(e.ExcludeCNFromSans)
+	c.SetTTL(e.TTL)
+	c.SetOrganisation(e.Organisation)
+	c.SetOwner(e.Owner)
+	c.SetGroup(e.Group)
+	c.SetCertFile(e.CertFile)
+	c.SetKeyFile(e.KeyFile)
+	c.SetCAFile(e.CAFile)
+	c.SetKeystorePasswordFile(e.KeystorePasswordFile)
+
+	include, err := ParseCIDRs(e.AutoIPSansInclude)
+	if err != nil {
+		return nil, err
+	}
+	c.SetAutoIPSansInclude(include)
+
+	exclude, err := ParseCIDRs(e.AutoIPSansExclude)
+	if err != nil {
+		return nil, err
+	}
+	c.SetAutoIPSansExclude(exclude)
+
+	otherSans, err := NormaliseOtherSans(e.OtherSans)
+	if err != nil {
+		return nil, err
+	}
+	c.SetOtherSans(otherSans)
+
+	if e.Mode != "" {
+		mode, err := NormaliseMode(e.Mode)
+		if err != nil {
+			return nil, err
+		}
+		c.SetMode(mode)
+	}
+
+	keyType := e.KeyType
+	if keyType == "" {
+		keyType = KeyTypeRSA
+	}
+	keyType, err = NormaliseKeyType(keyType)
+	if err != nil {
+		return nil, err
+	}
+	c.SetKeyType(keyType)
+
+	if e.KeyBitSize != 0 {
+		c.SetBitSize(e.KeyBitSize)
+	} else {
+		c.SetBitSize(DefaultKeyBitSize(keyType))
+	}
+	c.SetKeyFormat(strings.ToLower(e.KeyFormat))
+
+	if e.RenewBefore != 0 {
+		c.SetRenewBefore(e.RenewBefore)
+	}
+
+	var outputs []*Output
+	for _, s := range e.OutputFormats {
+		o, err := ParseOutput(s)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, o)
+	}
+	c.SetOutputFormats(outputs)
+
+	return c, nil
+}
+
+// Result is the outcome of applying a certificate of a manifest
+type Result struct {
+	Destination string
+	CommonName  string
+	Issued      bool
+	Err         error
+}
+
+// Apply ensures the certificates, running up to parallelism at once. The
+// results are in the order of the certificates.
+func Apply(certs []*Cert, parallelism int) []Result {
+	return apply(certs, parallelism, func(c *Cert) (bool, error) {
+		return c.ensureCertificate("")
+	})
+}
+
+func apply(certs []*Cert, parallelism int, run func(*Cert) (bool, error)) []Result {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	results := make([]Result, len(certs))
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+
+	for n, c := range certs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(n int, c *Cert) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			issued, err := run(c)
+			results[n] = Result{c.Destination(), c.CommonName(), issued, err}
+		}(n, c)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// WriteResults writes a line per certificate and returns an error if any
+// certificate failed
+func WriteResults(w io.Writer, results []Result) error {
+	var failed int
+	for _, r := range results {
+		status := "valid"
+		switch {
+		case r.Err != nil:
+			status = fmt.Sprintf("failed: %v", r.Err)
+			failed++
+		case r.Issued:
+			status = "issued"
+		}
+
+		if _, err := fmt.Fprintf(w, "%s (%s): %s\n", r.Destination, r.CommonName, status); err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d certificates failed", failed, len(results))
+	}
+
+	return nil
+}
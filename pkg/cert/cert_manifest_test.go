@@ -0,0 +1,142 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package cert
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func writeManifest(t *testing.T, manifest string) string {
+	dir, err := ioutil.TempDir("", "test-cert-manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tempDirs = append(tempDirs, dir)
+
+	path := filepath.Join(dir, "manifest.yaml")
+	if err := ioutil.WriteFile(path, []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestCert_Manifest_Load(t *testing.T) {
+	path := writeManifest(t, `certificates:
+- role: cluster/pki/k8s/sign/kube-apiserver
+  commonName: kube-apiserver
+  destination: apiserver
+  sanHosts: [kubernetes.default]
+  ipSans: [10.0.0.1]
+  outputFormats: [fullchain]
+  renewBefore: 168h
+- role: cluster/pki/etcd-k8s/sign/client
+  commonName: etcd-client
+  destination: /etc/vault/etcd-client
+  keyType: ecdsa
+  organisation: [system:masters]
+`)
+
+	m, err := LoadManifest(path)
+	if err != nil {
+		t.Fatalf("error loading manifest: %v", err)
+	}
+	certs, err := m.Certs(nil, nil)
+	if err != nil {
+		t.Fatalf("error creating certificates: %v", err)
+	}
+	if exp, act := 2, len(certs); exp != act {
+		t.Fatalf("unexpected number of certificates exp=%d got=%d", exp, act)
+	}
+
+	c := certs[0]
+	if exp, act := filepath.Join(filepath.Dir(path), "apiserver"), c.Destination(); exp != act {
+		t.Errorf("unexpected destination exp=%s got=%s", exp, act)
+	}
+	if exp, act := 168*time.Hour, c.RenewBefore(); exp != act {
+		t.Errorf("unexpected renew before exp=%s got=%s", exp, act)
+	}
+	if c.KeyType() != KeyTypeRSA || c.BitSize() != 2048 || len(c.OutputFormats()) != 1 {
+		t.Errorf("unexpected certificate settings: %s %d %v", c.KeyType(), c.BitSize(), c.OutputFormats())
+	}
+
+	c = certs[1]
+	if c.KeyType() != KeyTypeECDSA || c.BitSize() != DefaultKeyBitSize(KeyTypeECDSA) || c.RenewBefore() != DefaultRenewBefore {
+		t.Errorf("unexpected certificate settings: %s %d %s", c.KeyType(), c.BitSize(), c.RenewBefore())
+	}
+
+	for _, manifest := range []string{
+		"certificates: []\n",
+		"certificates:\n- role: r\n  commonName: cn\n",
+		"certificates:\n- role: r\n  commonName: cn\n  destination: d\n  sans: [a]\n",
+		// files shared between certificates
+		"certificates:\n- role: r\n  commonName: a\n  destination: d\n- role: r\n  commonName: b\n  destination: ./d\n",
+		"certificates:\n- role: r\n  commonName: a\n  destination: a\n  caFile: ca.pem\n- role: r\n  commonName: b\n  destination: b\n  caFile: ca.pem\n",
+		"certificates:\n- role: r\n  commonName: a\n  destination: a\n- role: r\n  commonName: b\n  destination: b\n  keyFile: a-key.pem\n",
+		"certificates:\n- role: r\n  commonName: a\n  destination: a\n  certFile: tls.pem\n  keyFile: tls.pem\n",
+		// key type set by the role
+		"certificates:\n- role: r\n  commonName: cn\n  destination: d\n  mode: issue\n  keyType: ecdsa\n",
+	} {
+		if _, err := LoadManifest(writeManifest(t, manifest)); err == nil {
+			t.Errorf("expected error loading manifest: %s", manifest)
+		}
+	}
+}
+
+func TestCert_Manifest_Apply(t *testing.T) {
+	var certs []*Cert
+	for _, cn := range []string{"a", "b", "c", "d", "e"} {
+		c := New(nil, nil)
+		c.SetCommonName(cn)
+		c.SetDestination("/etc/vault/" + cn)
+		certs = append(certs, c)
+	}
+
+	var lock sync.Mutex
+	var running, maxRunning int
+	results := apply(certs, 2, func(c *Cert) (bool, error) {
+		lock.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		lock.Unlock()
+
+		time.Sleep(time.Millisecond * 10)
+
+		lock.Lock()
+		running--
+		lock.Unlock()
+
+		switch c.CommonName() {
+		case "b":
+			return false, nil
+		case "d":
+			return false, errors.New("permission denied")
+		}
+		return true, nil
+	})
+
+	if maxRunning > 2 {
+		t.Errorf("expected at most 2 certificates at once, got %d", maxRunning)
+	}
+
+	var out bytes.Buffer
+	if err := WriteResults(&out, results); err == nil {
+		t.Error("expected error for failed certificate")
+	}
+	exp := `/etc/vault/a (a): issued
+/etc/vault/b (b): valid
+/etc/vault/c (c): issued
+/etc/vault/d (d): failed: permission denied
+/etc/vault/e (e): issued
+`
+	if out.String() != exp {
+		t.Errorf("unexpected results exp=%s got=%s", exp, out.String())
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package cert
+
+import (
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jetstack/vault-helper/pkg/keystore"
+)
+
+const FlagOutputFormats = "output-formats"
+const FlagKeystorePasswordFile = "keystore-password-file"
+
+const (
+	OutputFormatFullChain  = "fullchain"
+	OutputFormatCombined   = "combined"
+	OutputFormatDER        = "der"
+	OutputFormatPKCS12     = "pkcs12"
+	OutputFormatJKS        = "jks"
+	OutputFormatTrustStore = "truststore"
+)
+
+// default file name suffix and mode of the output formats, files containing
+// the key are only readable by the owner
+var outputDefaults = map[string]struct {
+	suffix string
+	mode   os.FileMode
+}{
+	OutputFormatFullChain:  {"-fullchain.pem", 0644},
+	OutputFormatCombined:   {"-combined.pem", 0600},
+	OutputFormatDER:        {".der", 0644},
+	OutputFormatPKCS12:     {".p12", 0600},
+	OutputFormatJKS:        {".jks", 0600},
+	OutputFormatTrustStore: {"-truststore.jks", 0644},
+}
+
+// Output is an additional file written from each issued certificate. An empty
+// path defaults to a file next to the destination, relative paths are
+// relative to the directory of the destination.
+type Output struct {
+	Format string
+	Path   string
+	Mode   os.FileMode
+}
+
+// ParseOutput parses an output format of the form format[=path[:mode]], such
+// as "combined=/etc/haproxy/certs/site.pem:0600"
+func ParseOutput(s string) (*Output, error) {
+	format, path := s, ""
+	if i := strings.Index(s, "="); i >= 0 {
+		format, path = s[:i], s[i+1:]
+	}
+
+	o := &Output{Format: strings.ToLower(format)}
+	defaults, ok := outputDefaults[o.Format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported output format '%s', supported formats are: %s, %s, %s, %s, %s, %s", format,
+			OutputFormatFullChain, OutputFormatCombined, OutputFormatDER, OutputFormatPKCS12, OutputFormatJKS, OutputFormatTrustStore)
+	}
+	o.Mode = defaults.mode
+
+	if i := strings.LastIndex(path, ":"); i >= 0 {
+		mode, err := strconv.ParseUint(path[i+1:], 8, 32)
+		if err != nil || mode > 0777 {
+			return nil, fmt.Errorf("invalid file mode '%s' of output format '%s', expected octal mode such as 0640", path[i+1:], format)
+		}
+		o.Mode = os.FileMode(mode)
+		path = path[:i]
+	}
+	o.Path = path
+
+	return o, nil
+}
+
+// outputPath returns the path of the output file
+func (c *Cert) outputPath(o *Output) string {
+	return c.filePath(o.Path, outputDefaults[o.Format].suffix)
+}
+
+// outputFiles returns the output files of the certificate, its chain and the
+// key, plus the generated password of the keystores
+func (c *Cert) outputFiles(cert, certCA string) ([]certFile, error) {
+	var files []certFile
+	var password string
+
+	for _, o := range c.OutputFormats() {
+		var name string
+		var data []byte
+		var err error
+
+		switch o.Format {
+		case OutputFormatFullChain:
+			name = "Full chain"
+			data = []byte(joinPEM(cert, certCA))
+		case OutputFormatCombined:
+			name = "Combined key and certificate"
+			data = []byte(joinPEM(string(pem.EncodeToMemory(c.Data())), cert, certCA))
+		case OutputFormatDER:
+			block, _ := pem.Decode([]byte(cert))
+			if block == nil {
+				return nil, errors.New("failed to decode certificate")
+			}
+			name = "DER certificate"
+			data = block.Bytes
+		case OutputFormatPKCS12, OutputFormatJKS, OutputFormatTrustStore:
+			if password == "" {
+				var passwordFile *certFile
+				if password, passwordFile, err = c.keystorePassword(); err != nil {
+					return nil, err
+				}
+				if passwordFile != nil {
+					files = append(files, *passwordFile)
+				}
+			}
+			name, data, err = c.encodeKeystore(o.Format, cert, certCA, password)
+			if err != nil {
+				return nil, fmt.Errorf("error encoding %s: %v", o.Format, err)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported output format '%s'", o.Format)
+		}
+
+		files = append(files, certFile{name, c.outputPath(o), data, o.Mode})
+	}
+
+	return files, nil
+}
+
+func (c *Cert) encodeKeystore(format, cert, certCA, password string) (name string, data []byte, err error) {
+	leaf, err := parseCertificate([]byte(cert))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+	chain, err := parseCertificates([]byte(certCA))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse ca certificates: %v", err)
+	}
+
+	if format == OutputFormatTrustStore {
+		data, err = keystore.EncodeJKSTrustStore(chain, "ca", password)
+		return "Java truststore", data, err
+	}
+
+	key, _, err := parsePrivateKey(c.Data())
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse private key bytes: %v", err)
+	}
+
+	if format == OutputFormatJKS {
+		data, err = keystore.EncodeJKS(key, leaf, chain, c.CommonName(), password)
+		return "Java keystore", data, err
+	}
+
+	data, err = keystore.EncodePKCS12(key, leaf, chain, c.CommonName(), password)
+	return "PKCS#12 bundle", data, err
+}
+
+// keystorePassword reads the password of the keystores from the password
+// file. Without a password file, the password is read from a file next to the
+// destination, or generated and returned to be written there.
+func (c *Cert) keystorePassword() (password string, generated *certFile, err error) {
+	path := c.KeystorePasswordFile()
+	if path == "" {
+		path = c.defaultPasswordPath()
+	}
+
+	dat, err := ioutil.ReadFile(path)
+	if err == nil {
+		if password = strings.TrimSpace(string(dat)); password == "" {
+			return "", nil, fmt.Errorf("keystore password file '%s' is empty", path)
+		}
+		return password, nil, nil
+	}
+	if !os.IsNotExist(err) || c.KeystorePasswordFile() != "" {
+		return "", nil, fmt.Errorf("error reading keystore password file '%s': %v", path, err)
+	}
+
+	if password, err = keystore.GeneratePassword(); err != nil {
+		return "", nil, fmt.Errorf("error generating keystore password: %v", err)
+	}
+
+	return password, &certFile{"Keystore password", path, []byte(password + "\n"), 0600}, nil
+}
+
+func (c *Cert) defaultPasswordPath() string {
+	return filepath.Clean(c.Destination() + "-keystore-password")
+}
+
+func (c *Cert) hasKeystoreOutput() bool {
+	for _, o := range c.OutputFormats() {
+		switch o.Format {
+		case OutputFormatPKCS12, OutputFormatJKS, OutputFormatTrustStore:
+			return true
+		}
+	}
+
+	return false
+}
+
+// missingOutput returns the path of the first output file which doesn't
+// exist, or an empty string. A missing generated keystore password requires
+// new keystores.
+func (c *Cert) missingOutput() string {
+	var paths []string
+	for _, o := range c.OutputFormats() {
+		paths = append(paths, c.outputPath(o))
+	}
+	if c.hasKeystoreOutput() && c.KeystorePasswordFile() == "" {
+		paths = append(paths, c.defaultPasswordPath())
+	}
+
+	for _, path := range paths {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+	}
+
+	return ""
+}
+
+// joinPEM joins pem data, each ending with a single newline
+func joinPEM(pems ...string) string {
+	var out string
+	for _, p := range pems {
+		if p = strings.TrimSpace(p); p != "" {
+			out += p + "\n"
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,234 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package cert
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+func TestCert_Output_Parse(t *testing.T) {
+	for s, exp := range map[string]Output{
+		"fullchain":                  {OutputFormatFullChain, "", 0644},
+		"Combined":                   {OutputFormatCombined, "", 0600},
+		"der=cert.der":               {OutputFormatDER, "cert.der", 0644},
+		"combined=/etc/site.pem:640": {OutputFormatCombined, "/etc/site.pem", 0640},
+		"fullchain=:0600":            {OutputFormatFullChain, "", 0600},
+		"pkcs12":                     {OutputFormatPKCS12, "", 0600},
+		"truststore=trust.jks:0640":  {OutputFormatTrustStore, "trust.jks", 0640},
+	} {
+		o, err := ParseOutput(s)
+		if err != nil {
+			t.Errorf("error parsing output format '%s': %v", s, err)
+			continue
+		}
+		if *o != exp {
+			t.Errorf("unexpected output format for '%s' exp=%+v got=%+v", s, exp, *o)
+		}
+	}
+
+	for _, s := range []string{"p12", "der=cert.der:rw", "der=cert.der:1777"} {
+		if _, err := ParseOutput(s); err == nil {
+			t.Errorf("expected error parsing output format '%s'", s)
+		}
+	}
+}
+
+func TestCert_Output_Files(t *testing.T) {
+	c := newVerifyTestCert(t)
+	ca := newTestCA(t)
+	ca.sign(t, c, validTemplate())
+
+	var outputs []*Output
+	for _, s := range []string{"fullchain", "combined=combined/site.pem:0640", "der"} {
+		o, err := ParseOutput(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, o)
+	}
+	c.SetOutputFormats(outputs)
+	if err := os.Mkdir(filepath.Join(filepath.Dir(c.Destination()), "combined"), 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	// missing output files are written with a new certificate
+	reason, err := c.renewalReason()
+	if err != nil {
+		t.Fatalf("error verifying certificate: %v", err)
+	}
+	if !strings.Contains(reason, "output file") {
+		t.Errorf("expected missing output file as reason, got: %s", reason)
+	}
+
+	cert, err := ioutil.ReadFile(c.CertFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.storeFiles(string(cert), string(ca.pem)); err != nil {
+		t.Fatalf("error storing files: %v", err)
+	}
+
+	fullchain := filepath.Clean(c.Destination() + "-fullchain.pem")
+	dat, err := ioutil.ReadFile(fullchain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := joinPEM(string(cert), string(ca.pem)); string(dat) != exp {
+		t.Errorf("unexpected full chain exp=%s got=%s", exp, dat)
+	}
+
+	combined := filepath.Join(filepath.Dir(c.Destination()), "combined", "site.pem")
+	dat, err = ioutil.ReadFile(combined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for block, rest := pem.Decode(dat); block != nil; block, rest = pem.Decode(rest) {
+		types = append(types, block.Type)
+	}
+	if exp, act := "EC PRIVATE KEY,CERTIFICATE,CERTIFICATE", strings.Join(types, ","); exp != act {
+		t.Errorf("unexpected pem blocks in combined file exp=%s got=%s", exp, act)
+	}
+	fi, err := os.Stat(combined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := os.FileMode(0640), fi.Mode().Perm(); exp != act {
+		t.Errorf("unexpected mode of combined file exp=%s got=%s", exp, act)
+	}
+
+	dat, err = ioutil.ReadFile(c.Destination() + ".der")
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.ParseCertificate(dat)
+	if err != nil {
+		t.Fatalf("error parsing der certificate: %v", err)
+	}
+	block, _ := pem.Decode(cert)
+	if !bytes.Equal(der.Raw, block.Bytes) {
+		t.Error("unexpected der certificate")
+	}
+
+	reason, err = c.renewalReason()
+	if err != nil {
+		t.Fatalf("error verifying certificate: %v", err)
+	}
+	if reason != "" {
+		t.Errorf("expected valid certificate to be kept, got reason: %s", reason)
+	}
+}
+
+// The ca chain of vault's json response is a list of interfaces, holding the
+// intermediate and root CA
+func TestCert_Output_FullChain(t *testing.T) {
+	c := newVerifyTestCert(t)
+	intermediate, root := newTestCA(t), newTestCA(t)
+	intermediate.sign(t, c, validTemplate())
+
+	o, err := ParseOutput("fullchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetOutputFormats([]*Output{o})
+
+	cert, err := ioutil.ReadFile(c.CertFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec := &vault.Secret{Data: map[string]interface{}{
+		"certificate": string(cert),
+		"ca_chain":    []interface{}{string(intermediate.pem), string(root.pem)},
+	}}
+	outCert, outCA, err := c.decodeSec(sec)
+	if err != nil {
+		t.Fatalf("error decoding secret: %v", err)
+	}
+	if err := c.storeFiles(outCert, outCA); err != nil {
+		t.Fatalf("error storing files: %v", err)
+	}
+
+	dat, err := ioutil.ReadFile(filepath.Clean(c.Destination() + "-fullchain.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(dat), strings.TrimSpace(string(intermediate.pem))) {
+		t.Errorf("expected full chain to contain the intermediate CA, got=%s", dat)
+	}
+	if exp := joinPEM(string(cert), string(intermediate.pem), string(root.pem)); string(dat) != exp {
+		t.Errorf("unexpected full chain exp=%s got=%s", exp, dat)
+	}
+
+	sec.Data["ca_chain"] = []interface{}{string(intermediate.pem), 1}
+	if _, _, err := c.decodeSec(sec); err == nil {
+		t.Error("expected error for invalid ca chain")
+	}
+}
+
+func TestCert_Output_Keystores(t *testing.T) {
+	c := newVerifyTestCert(t)
+	ca := newTestCA(t)
+	ca.sign(t, c, validTemplate())
+
+	var outputs []*Output
+	for _, s := range []string{"pkcs12", "jks", "truststore"} {
+		o, err := ParseOutput(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, o)
+	}
+	c.SetOutputFormats(outputs)
+
+	cert, err := ioutil.ReadFile(c.CertFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.storeFiles(string(cert), string(ca.pem)); err != nil {
+		t.Fatalf("error storing files: %v", err)
+	}
+
+	for path, exp := range map[string]os.FileMode{
+		c.Destination() + ".p12":               0600,
+		c.Destination() + ".jks":               0600,
+		c.Destination() + "-truststore.jks":    0644,
+		c.Destination() + "-keystore-password": 0600,
+	} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("expected output file: %v", err)
+		}
+		if act := fi.Mode().Perm(); exp != act {
+			t.Errorf("unexpected mode of '%s' exp=%s got=%s", path, exp, act)
+		}
+	}
+
+	// the generated password is reused with the next certificate
+	password, err := ioutil.ReadFile(c.Destination() + "-keystore-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.storeFiles(string(cert), string(ca.pem)); err != nil {
+		t.Fatalf("error storing files: %v", err)
+	}
+	dat, err := ioutil.ReadFile(c.Destination() + "-keystore-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(password, dat) {
+		t.Error("expected generated keystore password to be reused")
+	}
+
+	c.SetKeystorePasswordFile(filepath.Join(filepath.Dir(c.Destination()), "password"))
+	if err := c.storeFiles(string(cert), string(ca.pem)); err == nil {
+		t.Error("expected error with missing keystore password file")
+	}
+}
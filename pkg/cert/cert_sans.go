@@ -0,0 +1,180 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package cert
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const FlagTTL = "ttl"
+const FlagURISans = "uri-sans"
+const FlagOtherSans = "other-sans"
+const FlagExcludeCNFromSans = "exclude-cn-from-sans"
+
+// tolerance of the lifetime of an issued certificate against the requested
+// ttl, vault backdates certificates by 30s
+const ttlTolerance = time.Minute
+
+var oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+// NormaliseOtherSans validates other SANs in the vault format
+// <oid>;<type>:<value> and normalises the UTF-8 type to UTF8, the only type
+// supported by vault
+func NormaliseOtherSans(sans []string) ([]string, error) {
+	var normalised []string
+	for _, san := range sans {
+		oid, typeValue, ok := cut(san, ";")
+		if !ok || !validOID(oid) {
+			return nil, fmt.Errorf("invalid other san '%s', expected <oid>;UTF8:<value>", san)
+		}
+
+		typ, value, ok := cut(typeValue, ":")
+		if !ok || (strings.ToUpper(typ) != "UTF8" && strings.ToUpper(typ) != "UTF-8") {
+			return nil, fmt.Errorf("invalid other san '%s', only the UTF8 type is supported", san)
+		}
+
+		normalised = append(normalised, oid+";UTF8:"+value)
+	}
+
+	return normalised, nil
+}
+
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+func validOID(oid string) bool {
+	parts := strings.Split(oid, ".")
+	if len(parts) < 2 {
+		return false
+	}
+	for _, p := range parts {
+		if p == "" || strings.Trim(p, "0123456789") != "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// otherSans returns the UTF8 other names of the subject alternative names of
+// the certificate in the vault format
+func otherSans(cert *x509.Certificate) ([]string, error) {
+	var sans []string
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+
+		var names asn1.RawValue
+		if _, err := asn1.Unmarshal(ext.Value, &names); err != nil {
+			return nil, err
+		}
+
+		rest := names.Bytes
+		for len(rest) > 0 {
+			var name asn1.RawValue
+			var err error
+			if rest, err = asn1.Unmarshal(rest, &name); err != nil {
+				return nil, err
+			}
+			// otherName [0] { type-id OID, value [0] EXPLICIT ANY }
+			if name.Class != asn1.ClassContextSpecific || name.Tag != 0 {
+				continue
+			}
+
+			var other struct {
+				ID    asn1.ObjectIdentifier
+				Value asn1.RawValue `asn1:"explicit,tag:0"`
+			}
+			if _, err := asn1.UnmarshalWithParams(name.FullBytes, &other, "tag:0"); err != nil {
+				return nil, err
+			}
+			var value string
+			if _, err := asn1.UnmarshalWithParams(other.Value.Bytes, &value, "utf8"); err != nil {
+				continue
+			}
+
+			sans = append(sans, other.ID.String()+";UTF8:"+value)
+		}
+	}
+
+	return sans, nil
+}
+
+func uriSans(cert *x509.Certificate) []string {
+	var uris []string
+	for _, uri := range cert.URIs {
+		uris = append(uris, uri.String())
+	}
+
+	return uris
+}
+
+func ipSans(cert *x509.Certificate) []string {
+	var ips []string
+	for _, ip := range cert.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+
+	return ips
+}
+
+// normaliseIPs returns the IP addresses in the notation used by certificates
+func normaliseIPs(ips []string) []string {
+	var normalised []string
+	for _, ip := range ips {
+		if parsed := net.ParseIP(ip); parsed != nil {
+			ip = parsed.String()
+		}
+		normalised = append(normalised, ip)
+	}
+
+	return normalised
+}
+
+// verifyIssued checks the issued certificate contains the requested SANs and
+// ttl, which the role may drop without failing the request
+func (c *Cert) verifyIssued(pemCert string) error {
+	cert, err := parseCertificate([]byte(pemCert))
+	if err != nil {
+		return fmt.Errorf("failed to parse certificate: %v", err)
+	}
+
+	if missing := missing(c.allSanHosts(), cert.DNSNames); len(missing) > 0 {
+		return fmt.Errorf("certificate is missing host sans '%s'", strings.Join(missing, ","))
+	}
+	if missing := missing(normaliseIPs(c.allIPSans()), ipSans(cert)); len(missing) > 0 {
+		return fmt.Errorf("certificate is missing ip sans '%s'", strings.Join(missing, ","))
+	}
+	if missing := missing(c.URISans(), uriSans(cert)); len(missing) > 0 {
+		return fmt.Errorf("certificate is missing uri sans '%s'", strings.Join(missing, ","))
+	}
+
+	others, err := otherSans(cert)
+	if err != nil {
+		return fmt.Errorf("failed to parse other sans: %v", err)
+	}
+	if missing := missing(c.OtherSans(), others); len(missing) > 0 {
+		return fmt.Errorf("certificate is missing other sans '%s'", strings.Join(missing, ","))
+	}
+
+	if c.ExcludeCNFromSans() && len(missing([]string{c.CommonName()}, cert.DNSNames)) == 0 {
+		return fmt.Errorf("certificate contains the common name '%s' as san", c.CommonName())
+	}
+
+	if ttl := c.TTL(); ttl > 0 {
+		if remaining := time.Until(cert.NotAfter); remaining < ttl-ttlTolerance || remaining > ttl+ttlTolerance {
+			return fmt.Errorf("certificate expires at %s, not after the requested ttl of %s", cert.NotAfter.Format(time.RFC3339), ttl)
+		}
+	}
+
+	return nil
+}
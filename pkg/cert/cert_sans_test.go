@@ -0,0 +1,150 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package cert
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCert_Sans_NormaliseOther(t *testing.T) {
+	sans, err := NormaliseOtherSans([]string{"1.3.6.1.4.1.311.20.2.3;utf-8:k8s@example.com", "1.2.3;UTF8:a:b"})
+	if err != nil {
+		t.Fatalf("error normalising other sans: %v", err)
+	}
+	if exp, act := "1.3.6.1.4.1.311.20.2.3;UTF8:k8s@example.com,1.2.3;UTF8:a:b", strings.Join(sans, ","); exp != act {
+		t.Errorf("unexpected other sans exp=%s got=%s", exp, act)
+	}
+
+	for _, san := range []string{"k8s@example.com", "1.2.3:UTF8:a", "1.2.x;UTF8:a", "1.2.3;IA5:a", "1.2.3;UTF8"} {
+		if _, err := NormaliseOtherSans([]string{san}); err == nil {
+			t.Errorf("expected error normalising other san '%s'", san)
+		}
+	}
+}
+
+// sanExtension returns the subject alternative name extension of the names
+// of the template with an additional UTF8 other name, which replaces the
+// extension created from the template
+func sanExtension(t *testing.T, tmpl *x509.Certificate, oid asn1.ObjectIdentifier, value string) pkix.Extension {
+	utf8Value, err := asn1.MarshalWithParams(value, "utf8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherName, err := asn1.MarshalWithParams(struct {
+		ID    asn1.ObjectIdentifier
+		Value asn1.RawValue
+	}{oid, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: utf8Value}}, "tag:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []asn1.RawValue{{FullBytes: otherName}}
+	for _, name := range tmpl.DNSNames {
+		names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, Bytes: []byte(name)})
+	}
+	for _, uri := range tmpl.URIs {
+		names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 6, Bytes: []byte(uri.String())})
+	}
+	for _, ip := range tmpl.IPAddresses {
+		names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 7, Bytes: ip.To4()})
+	}
+
+	ext, err := asn1.Marshal(names)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pkix.Extension{Id: oidSubjectAltName, Value: ext}
+}
+
+func TestCert_Sans_VerifyIssued(t *testing.T) {
+	uri, err := url.Parse("spiffe://cluster.local/k8s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oid := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3}
+
+	for _, r := range []struct {
+		name   string
+		err    string
+		tmpl   func(tmpl *x509.Certificate)
+		modify func(c *Cert)
+	}{
+		{name: "valid"},
+		{
+			name:   "host sans",
+			err:    "missing host sans 'k8s.example.org'",
+			modify: func(c *Cert) { c.SetSanHosts([]string{"k8s.example.com", "k8s.example.org"}) },
+		},
+		{
+			name:   "ip sans",
+			err:    "missing ip sans '10.0.0.1'",
+			modify: func(c *Cert) { c.SetIPSans([]string{"127.0.0.1", "10.0.0.1"}) },
+		},
+		{
+			name:   "uri sans",
+			err:    "missing uri sans",
+			tmpl:   func(tmpl *x509.Certificate) { tmpl.URIs = nil },
+			modify: func(c *Cert) { c.SetURISans([]string{uri.String()}) },
+		},
+		{
+			name:   "other sans",
+			err:    "missing other sans '1.2.3.4;UTF8:k8s'",
+			modify: func(c *Cert) { c.SetOtherSans([]string{"1.2.3.4;UTF8:k8s"}) },
+		},
+		{
+			name:   "exclude common name",
+			err:    "contains the common name",
+			modify: func(c *Cert) { c.SetExcludeCNFromSans(true) },
+		},
+		{
+			name:   "ttl",
+			err:    "not after the requested ttl",
+			modify: func(c *Cert) { c.SetTTL(time.Hour) },
+		},
+		{
+			name: "short ttl",
+			tmpl: func(tmpl *x509.Certificate) {
+				tmpl.NotBefore = time.Now().Add(-time.Second * 30)
+				tmpl.NotAfter = time.Now().Add(time.Hour)
+			},
+			modify: func(c *Cert) { c.SetTTL(time.Hour) },
+		},
+	} {
+		t.Run(r.name, func(t *testing.T) {
+			c := newVerifyTestCert(t)
+			c.SetURISans([]string{uri.String()})
+			c.SetOtherSans([]string{"1.3.6.1.4.1.311.20.2.3;UTF8:k8s@example.com"})
+
+			tmpl := validTemplate()
+			tmpl.URIs = []*url.URL{uri}
+			if r.tmpl != nil {
+				r.tmpl(tmpl)
+			}
+			tmpl.ExtraExtensions = []pkix.Extension{sanExtension(t, tmpl, oid, "k8s@example.com")}
+			newTestCA(t).sign(t, c, tmpl)
+			if r.modify != nil {
+				r.modify(c)
+			}
+
+			cert, err := ioutil.ReadFile(c.CertFile())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = c.verifyIssued(string(cert))
+			switch {
+			case r.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case r.err != "" && (err == nil || !strings.Contains(err.Error(), r.err)):
+				t.Errorf("unexpected error exp=%s got=%v", r.err, err)
+			}
+		})
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"syscall"
 	"testing"
+	"time"
 
 	vault "github.com/hashicorp/vault/api"
 	"github.com/sirupsen/logrus"
@@ -228,7 +229,8 @@ func TestCert_ConfigPath(t *testing.T) {
 	}
 }
 
-// Test if already existing valid certificate and key, key is kept and certificate renewed
+// Test if already existing valid certificate and key, key and certificate are
+// kept until the certificate expires within renew before
 func TestCert_Exist_NoChange(t *testing.T) {
 	dir, err := ioutil.TempDir("", "test-cluster-dir")
 	if err != nil {
@@ -287,8 +289,8 @@ func TestCert_Exist_NoChange(t *testing.T) {
 	if err != nil {
 		t.Fatalf("error reading from certificate file path: '%s': %v", dotPem, err)
 	}
-	if string(datDotPem) == string(datDotPemAfter) {
-		t.Errorf("certificate has not been changed after cert call: %s", dotPem)
+	if string(datDotPem) != string(datDotPemAfter) {
+		t.Errorf("certificate has been changed after cert call even though it is valid: %s", dotPem)
 	}
 
 	datCAPemAfter, err := ioutil.ReadFile(caPem)
@@ -306,6 +308,20 @@ func TestCert_Exist_NoChange(t *testing.T) {
 	if string(datKeyPem) != string(datKeyPemAfter) {
 		t.Errorf("key has been changed after cert call even though it exists: %s", keyPem)
 	}
+
+	// certificate expires within renew before
+	c.SetRenewBefore(time.Hour * 24 * 365)
+	if err := c.RunCert(); err != nil {
+		t.Fatalf("error running cert: %v", err)
+	}
+
+	datDotPemRenewed, err := ioutil.ReadFile(dotPem)
+	if err != nil {
+		t.Fatalf("error reading from certificate file path: '%s': %v", dotPem, err)
+	}
+	if string(datDotPem) == string(datDotPemRenewed) {
+		t.Errorf("certificate has not been changed after cert call even though it expires within renew before: %s", dotPem)
+	}
 }
 
 func TestCert_Busy_Vault(t *testing.T) {
@@ -386,6 +402,21 @@ func TestCert_Busy_Vault(t *testing.T) {
 		t.Fatalf("key has been changed after cert call even though it exists %s", keyPem)
 	}
 
+	// new key is not written without a certificate
+	c.SetKeyType(KeyTypeECDSA)
+	c.SetBitSize(256)
+	if err := c.RunCert(); err == nil {
+		t.Fatalf("expected error, got none")
+	}
+
+	datKeyPemAfter, err = ioutil.ReadFile(keyPem)
+	if err != nil {
+		t.Fatalf("error reading from certificate file path: '%s': %v", keyPem, err)
+	}
+	if string(datKeyPem) != string(datKeyPemAfter) {
+		t.Fatalf("key has been changed even though no certificate has been issued %s", keyPem)
+	}
+
 }
 
 // Init Cert for testing
@@ -0,0 +1,244 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package cert
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+const FlagRenewBefore = "renew-before"
+
+const DefaultRenewBefore = time.Hour * 72
+
+// renewalReason checks the existing certificate against the key, the current
+// CA of the PKI and the requested names. It returns why a new certificate is
+// needed, or an empty string if the existing certificate can be kept.
+func (c *Cert) renewalReason() (reason string, err error) {
+	exists, err := c.checkExistingCerts(c.CertFile())
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "no certificate exists", nil
+	}
+
+	cert, err := readCertificate(c.CertFile())
+	if err != nil {
+		return fmt.Sprintf("existing certificate is invalid: %v", err), nil
+	}
+
+	// without a local key, the key is issued with the certificate
+	if c.Data() == nil {
+		return "no key exists", nil
+	}
+
+	if path := c.missingOutput(); path != "" {
+		return fmt.Sprintf("output file '%s' doesn't exist", path), nil
+	}
+
+	if remaining := time.Until(cert.NotAfter); remaining < c.RenewBefore() {
+		return fmt.Sprintf("certificate expires at %s, within %s", cert.NotAfter.Format(time.RFC3339), c.RenewBefore()), nil
+	}
+
+	if lifetime := cert.NotAfter.Sub(cert.NotBefore); c.TTL() > 0 && lifetime > c.TTL()+ttlTolerance {
+		return fmt.Sprintf("certificate lifetime of %s exceeds the ttl of %s", lifetime, c.TTL()), nil
+	}
+
+	if reason, err := c.keyMismatch(cert); err != nil || reason != "" {
+		return reason, err
+	}
+
+	if reason, err := c.caMismatch(cert); err != nil || reason != "" {
+		return reason, err
+	}
+
+	return c.namesMismatch(cert), nil
+}
+
+func (c *Cert) keyMismatch(cert *x509.Certificate) (string, error) {
+	key, _, err := parsePrivateKey(c.Data())
+	if err != nil {
+		return "", fmt.Errorf("failed to parse private key bytes: %v", err)
+	}
+
+	pub, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal public key: %v", err)
+	}
+	certPub, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return "certificate public key is not supported", nil
+	}
+
+	if !bytes.Equal(pub, certPub) {
+		return "certificate doesn't match the private key", nil
+	}
+
+	return "", nil
+}
+
+// caMismatch checks the certificate is signed by the stored CA, which has to
+// be the current CA of the PKI
+func (c *Cert) caMismatch(cert *x509.Certificate) (string, error) {
+	ca, err := readCertificate(c.CAFile())
+	if err != nil {
+		return fmt.Sprintf("existing ca certificate is invalid: %v", err), nil
+	}
+
+	if err := cert.CheckSignatureFrom(ca); err != nil {
+		return "certificate is not signed by the stored ca certificate", nil
+	}
+
+	current, err := c.currentCA()
+	if err != nil {
+		return "", fmt.Errorf("error reading current ca certificate: %v", err)
+	}
+	if current != nil && !bytes.Equal(current.Raw, ca.Raw) {
+		return "ca certificate of the pki has changed", nil
+	}
+
+	return "", nil
+}
+
+// currentCA reads the CA certificate of the PKI backend of the role. Nil is
+// returned if the role path is not a sign or issue path of a PKI backend.
+func (c *Cert) currentCA() (*x509.Certificate, error) {
+	mount := pkiMount(c.Role())
+	if mount == "" {
+		c.Log.Debugf("Unable to find pki mount of role '%s', not checking for ca changes", c.Role())
+		return nil, nil
+	}
+
+	sec, err := c.InstanceToken().VaultClient().Logical().Read(mount + "/cert/ca")
+	if err != nil {
+		return nil, err
+	}
+	if sec == nil {
+		return nil, errors.New("no ca certificate returned from vault")
+	}
+
+	pemCA, ok := sec.Data["certificate"].(string)
+	if !ok {
+		return nil, errors.New("failed to convert ca certificate field to string")
+	}
+
+	return parseCertificate([]byte(pemCA))
+}
+
+func (c *Cert) namesMismatch(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != c.CommonName() {
+		return fmt.Sprintf("common name has changed from '%s' to '%s'", cert.Subject.CommonName, c.CommonName())
+	}
+
+	// vault adds the common name to the DNS names
+	hosts := c.allSanHosts()
+	expHosts := append([]string{c.CommonName()}, hosts...)
+	if !subset(hosts, cert.DNSNames) || !subset(cert.DNSNames, expHosts) {
+		return fmt.Sprintf("host sans have changed from '%s' to '%s'", strings.Join(cert.DNSNames, ","), strings.Join(hosts, ","))
+	}
+
+	certIPs, expIPs := ipSans(cert), normaliseIPs(c.allIPSans())
+	if !subset(expIPs, certIPs) || !subset(certIPs, expIPs) {
+		return fmt.Sprintf("ip sans have changed from '%s' to '%s'", strings.Join(certIPs, ","), strings.Join(expIPs, ","))
+	}
+
+	certURIs := uriSans(cert)
+	if !subset(c.URISans(), certURIs) || !subset(certURIs, c.URISans()) {
+		return fmt.Sprintf("uri sans have changed from '%s' to '%s'", strings.Join(certURIs, ","), strings.Join(c.URISans(), ","))
+	}
+
+	certOthers, err := otherSans(cert)
+	if err != nil {
+		return fmt.Sprintf("other sans are invalid: %v", err)
+	}
+	if !subset(c.OtherSans(), certOthers) || !subset(certOthers, c.OtherSans()) {
+		return fmt.Sprintf("other sans have changed from '%s' to '%s'", strings.Join(certOthers, ","), strings.Join(c.OtherSans(), ","))
+	}
+
+	// the organisation may be set by the role, so only requested organisations
+	// are checked
+	if !subset(c.Organisation(), cert.Subject.Organization) {
+		return fmt.Sprintf("organisation has changed from '%s' to '%s'", strings.Join(cert.Subject.Organization, ","), strings.Join(c.Organisation(), ","))
+	}
+
+	return ""
+}
+
+// pkiMount returns the mount path of the PKI backend of a sign or issue path
+func pkiMount(role string) string {
+	for _, sep := range []string{"/sign/", "/issue/"} {
+		if i := strings.LastIndex(role, sep); i > 0 {
+			return role[:i]
+		}
+	}
+
+	return ""
+}
+
+func readCertificate(path string) (*x509.Certificate, error) {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseCertificate(dat)
+}
+
+// parseCertificate parses the first certificate of pem data
+func parseCertificate(dat []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(dat)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no pem certificate found")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// parseCertificates parses all certificates of pem data
+func parseCertificates(dat []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(dat); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("no pem certificate found")
+	}
+
+	return certs, nil
+}
+
+// subset returns true if all items of a are in b
+func subset(a, b []string) bool {
+	return len(missing(a, b)) == 0
+}
+
+// missing returns the items of a which are not in b
+func missing(a, b []string) []string {
+	sorted := append([]string{}, b...)
+	sort.Strings(sorted)
+
+	var missing []string
+	for _, item := range a {
+		i := sort.SearchStrings(sorted, item)
+		if i == len(sorted) || sorted[i] != item {
+			missing = append(missing, item)
+		}
+	}
+
+	return missing
+}
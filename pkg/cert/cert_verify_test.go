@@ -0,0 +1,297 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package cert
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	key  crypto.Signer
+	cert *x509.Certificate
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour * 24 * 365),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCA{
+		key:  key,
+		cert: cert,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// sign writes a certificate for the key of c, as issued by vault, to the
+// destination of c
+func (ca *testCA) sign(t *testing.T, c *Cert, tmpl *x509.Certificate) {
+	key, _, err := parsePrivateKey(c.Data())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl.SerialNumber = big.NewInt(2)
+	if tmpl.NotAfter.IsZero() {
+		tmpl.NotBefore = time.Now().Add(-time.Hour)
+		tmpl.NotAfter = time.Now().Add(time.Hour * 24 * 30)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(c.CertFile(), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(c.CAFile(), ca.pem, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newVerifyTestCert(t *testing.T) *Cert {
+	c := newKeyTestCert(t, KeyTypeECDSA, 256)
+	c.SetRole("test-role")
+	c.SetSanHosts([]string{"k8s.example.com"})
+	c.SetIPSans([]string{"127.0.0.1"})
+	c.SetOrganisation([]string{"system:masters"})
+	c.SetRenewBefore(DefaultRenewBefore)
+
+	if err := c.EnsureKey(); err != nil {
+		t.Fatalf("error ensuring key: %v", err)
+	}
+	writeKey(t, c)
+
+	return c
+}
+
+func validTemplate() *x509.Certificate {
+	return &x509.Certificate{
+		Subject: pkix.Name{
+			CommonName:   "k8s",
+			Organization: []string{"system:masters"},
+		},
+		DNSNames:    []string{"k8s", "k8s.example.com"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	}
+}
+
+func TestCert_Verify_Valid(t *testing.T) {
+	c := newVerifyTestCert(t)
+	newTestCA(t).sign(t, c, validTemplate())
+
+	reason, err := c.renewalReason()
+	if err != nil {
+		t.Fatalf("error verifying certificate: %v", err)
+	}
+	if reason != "" {
+		t.Errorf("expected valid certificate to be kept, got reason: %s", reason)
+	}
+}
+
+// Roles like the kubelet role force their own organisation, which is not
+// requested and must not cause the certificate to be renewed
+func TestCert_Verify_RoleOrganisation(t *testing.T) {
+	c := newVerifyTestCert(t)
+	c.SetOrganisation(nil)
+	tmpl := validTemplate()
+	tmpl.Subject.Organization = []string{"system:nodes"}
+	newTestCA(t).sign(t, c, tmpl)
+
+	reason, err := c.renewalReason()
+	if err != nil {
+		t.Fatalf("error verifying certificate: %v", err)
+	}
+	if reason != "" {
+		t.Errorf("expected certificate with role organisation to be kept, got reason: %s", reason)
+	}
+}
+
+func TestCert_Verify_Renew(t *testing.T) {
+	for _, r := range []struct {
+		name   string
+		reason string
+		tmpl   func(tmpl *x509.Certificate)
+		modify func(t *testing.T, c *Cert)
+	}{
+		{
+			name:   "missing",
+			reason: "no certificate exists",
+			modify: func(t *testing.T, c *Cert) { c.SetDestination(c.Destination() + "-missing") },
+		},
+		{
+			name:   "expiring",
+			reason: "certificate expires at",
+			tmpl: func(tmpl *x509.Certificate) {
+				tmpl.NotBefore = time.Now().Add(-time.Hour)
+				tmpl.NotAfter = time.Now().Add(time.Hour)
+			},
+		},
+		{
+			name:   "key",
+			reason: "doesn't match the private key",
+			modify: func(t *testing.T, c *Cert) {
+				if err := c.generateKey(); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name:   "ca",
+			reason: "not signed by the stored ca",
+			modify: func(t *testing.T, c *Cert) {
+				if err := ioutil.WriteFile(c.CAFile(), newTestCA(t).pem, 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name:   "common name",
+			reason: "common name has changed",
+			modify: func(t *testing.T, c *Cert) { c.SetCommonName("k8s-new") },
+		},
+		{
+			name:   "host sans added",
+			reason: "host sans have changed",
+			modify: func(t *testing.T, c *Cert) { c.SetSanHosts([]string{"k8s.example.com", "k8s.example.org"}) },
+		},
+		{
+			name:   "host sans removed",
+			reason: "host sans have changed",
+			modify: func(t *testing.T, c *Cert) { c.SetSanHosts([]string{}) },
+		},
+		{
+			name:   "ip sans",
+			reason: "ip sans have changed",
+			modify: func(t *testing.T, c *Cert) { c.SetIPSans([]string{"127.0.0.1", "10.0.0.1"}) },
+		},
+		{
+			name:   "uri sans",
+			reason: "uri sans have changed",
+			modify: func(t *testing.T, c *Cert) { c.SetURISans([]string{"spiffe://cluster.local/k8s"}) },
+		},
+		{
+			name:   "other sans",
+			reason: "other sans have changed",
+			modify: func(t *testing.T, c *Cert) { c.SetOtherSans([]string{"1.3.6.1.4.1.311.20.2.3;UTF8:k8s@example.com"}) },
+		},
+		{
+			name:   "ttl",
+			reason: "exceeds the ttl",
+			modify: func(t *testing.T, c *Cert) { c.SetTTL(time.Hour * 24) },
+		},
+		{
+			name:   "organisation",
+			reason: "organisation has changed",
+			modify: func(t *testing.T, c *Cert) { c.SetOrganisation([]string{"system:nodes"}) },
+		},
+	} {
+		t.Run(r.name, func(t *testing.T) {
+			c := newVerifyTestCert(t)
+			tmpl := validTemplate()
+			if r.tmpl != nil {
+				r.tmpl(tmpl)
+			}
+			newTestCA(t).sign(t, c, tmpl)
+			if r.modify != nil {
+				r.modify(t, c)
+			}
+
+			reason, err := c.renewalReason()
+			if err != nil {
+				t.Fatalf("error verifying certificate: %v", err)
+			}
+			if !strings.Contains(reason, r.reason) {
+				t.Errorf("unexpected reason exp=%s got=%s", r.reason, reason)
+			}
+		})
+	}
+}
+
+func TestCert_Verify_PKIMount(t *testing.T) {
+	for role, mount := range map[string]string{
+		"test-cluster/pki/k8s/sign/kube-apiserver":  "test-cluster/pki/k8s",
+		"test-cluster/pki/etcd/issue/server":        "test-cluster/pki/etcd",
+		"test-cluster/pki/k8s/roles/kube-apiserver": "",
+	} {
+		if act := pkiMount(role); act != mount {
+			t.Errorf("unexpected pki mount of role %s exp=%s got=%s", role, mount, act)
+		}
+	}
+}
+
+func TestCert_Verify_FileOverrides(t *testing.T) {
+	c := newKeyTestCert(t, KeyTypeECDSA, 256)
+	c.SetRole("test-role")
+	c.SetSanHosts([]string{"k8s.example.com"})
+	c.SetIPSans([]string{"127.0.0.1"})
+	c.SetOrganisation([]string{"system:masters"})
+
+	dir := filepath.Dir(c.Destination())
+	c.SetCertFile("tls/tls.crt")
+	c.SetKeyFile("tls/tls.key")
+	c.SetCAFile(filepath.Join(dir, "ca", "ca.crt"))
+
+	for exp, act := range map[string]string{
+		filepath.Join(dir, "tls", "tls.crt"): c.CertFile(),
+		filepath.Join(dir, "tls", "tls.key"): c.KeyFile(),
+		filepath.Join(dir, "ca", "ca.crt"):   c.CAFile(),
+	} {
+		if exp != act {
+			t.Errorf("unexpected file path exp=%s got=%s", exp, act)
+		}
+	}
+
+	if err := c.EnsureKey(); err != nil {
+		t.Fatalf("error ensuring key: %v", err)
+	}
+	writeKey(t, c)
+	newTestCA(t).sign(t, c, validTemplate())
+
+	// the existing key and certificate at the custom paths are kept
+	if err := c.EnsureKey(); err != nil {
+		t.Fatalf("error ensuring key: %v", err)
+	}
+	if c.newKey {
+		t.Error("expected existing key to be reused")
+	}
+	reason, err := c.renewalReason()
+	if err != nil {
+		t.Fatalf("error verifying certificate: %v", err)
+	}
+	if reason != "" {
+		t.Errorf("expected valid certificate to be kept, got reason: %s", reason)
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package cert
+
+import (
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/jetstack/vault-helper/pkg/retry"
+)
+
+const FlagWatch = "watch"
+const FlagCertRenewFraction = "cert-renew-fraction"
+
+const DefaultCertRenewFraction = 0.7
+
+// failed rotations are retried with a backoff between these durations
+const (
+	watchMinBackoff = time.Second * 10
+	watchMaxBackoff = time.Minute * 10
+)
+
+// Watcher keeps a certificate fresh, re-issuing it at a fraction of its
+// lifetime and running hooks after each rotation
+type Watcher struct {
+	cert     *Cert
+	fraction float64
+	hooks    []Hook
+	onRotate func() error
+
+	Log     *logrus.Entry
+	backoff *retry.Backoff
+
+	// written is false until onRotate succeeded for the current certificate
+	written bool
+	// reload is true until the hooks succeeded for the current certificate
+	reload bool
+	// rotateAt is the rotation time of the certificate with rotateSerial
+	rotateAt     time.Time
+	rotateSerial *big.Int
+}
+
+func NewWatcher(logger *logrus.Entry, c *Cert) *Watcher {
+	w := &Watcher{
+		cert:     c,
+		fraction: DefaultCertRenewFraction,
+		backoff:  retry.New(logger),
+	}
+	w.backoff.SetMinBackoff(watchMinBackoff)
+	w.backoff.SetMaxBackoff(watchMaxBackoff)
+
+	if logger != nil {
+		w.Log = logger
+	}
+
+	return w
+}
+
+// Run ensures the certificate and keeps rotating it until stop is closed.
+// The token is renewed alongside. Failed rotations, including failed hooks,
+// are retried with backoff.
+func (w *Watcher) Run(stop <-chan struct{}) error {
+	if i := w.cert.InstanceToken(); i != nil {
+		go func() {
+			if err := i.TokenRenewDaemon(stop); err != nil {
+				w.Log.Errorf("error renewing token: %v", err)
+			}
+		}()
+	}
+
+	rotate := ""
+	failures := 0
+	var logged time.Time
+
+	for {
+		wait, err := w.sync(rotate)
+		if err != nil {
+			wait = w.backoff.Duration(failures)
+			failures++
+			w.Log.Errorf("error rotating certificate, retrying in %s: %v", wait, err)
+		} else {
+			failures = 0
+			rotate = fmt.Sprintf("certificate reached %.0f%% of its lifetime", w.fraction*100)
+			if !logged.Equal(w.rotateAt) {
+				w.Log.Infof("Next certificate rotation in %s", wait)
+				logged = w.rotateAt
+			}
+
+			// discovered SANs are checked for changes until the rotation
+			if w.cert.discoversSans() && wait > sanDiscoveryInterval {
+				wait = sanDiscoveryInterval
+				rotate = ""
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-stop:
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+// sync ensures the certificate, rotating it if a reason is given, and runs
+// onRotate and the hooks for a new certificate. It returns the time to wait
+// until the next rotation.
+func (w *Watcher) sync(rotate string) (time.Duration, error) {
+	// a certificate which hasn't been fully rolled out yet is not rotated
+	if w.reload || !w.written {
+		rotate = ""
+	}
+
+	issued, err := w.cert.ensureCertificate(rotate)
+	if err != nil {
+		return 0, err
+	}
+	if issued {
+		w.written = false
+		w.reload = true
+	}
+
+	if !w.written && w.onRotate != nil {
+		if err := w.onRotate(); err != nil {
+			return 0, err
+		}
+	}
+	w.written = true
+
+	if w.reload {
+		if err := w.runHooks(); err != nil {
+			return 0, err
+		}
+		w.reload = false
+	}
+
+	cert, err := readCertificate(w.cert.CertFile())
+	if err != nil {
+		return 0, fmt.Errorf("error reading certificate '%s': %v", w.cert.CertFile(), err)
+	}
+	if w.rotateSerial == nil || w.rotateSerial.Cmp(cert.SerialNumber) != 0 {
+		w.rotateAt = time.Now().Add(w.RotateInterval(cert))
+		w.rotateSerial = cert.SerialNumber
+	}
+
+	if wait := time.Until(w.rotateAt); wait > time.Second {
+		return wait, nil
+	}
+
+	return time.Second, nil
+}
+
+func (w *Watcher) runHooks() error {
+	for _, h := range w.hooks {
+		w.Log.Infof("Running reload hook: %s", h)
+		if err := h.Run(); err != nil {
+			return fmt.Errorf("error running reload hook %s: %v", h, err)
+		}
+	}
+
+	return nil
+}
+
+// RotateInterval returns the time to wait before re-issuing the certificate,
+// at the renew fraction of its lifetime minus up to 10% jitter
+func (w *Watcher) RotateInterval(cert *x509.Certificate) time.Duration {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	at := cert.NotBefore.Add(time.Duration(float64(lifetime) * w.fraction))
+
+	wait := time.Until(at)
+	if jitter := int64(wait / 10); jitter > 0 {
+		wait -= time.Duration(rand.Int63n(jitter))
+	}
+
+	if wait < time.Second {
+		wait = time.Second
+	}
+
+	return wait
+}
+
+// SetFraction sets the fraction of the certificate's lifetime after which
+// it is re-issued
+func (w *Watcher) SetFraction(fraction float64) {
+	w.fraction = fraction
+}
+func (w *Watcher) Fraction() float64 {
+	return w.fraction
+}
+
+func (w *Watcher) AddHook(h Hook) {
+	w.hooks = append(w.hooks, h)
+}
+func (w *Watcher) Hooks() []Hook {
+	return w.hooks
+}
+
+// SetOnRotate sets a function run on start and for every new certificate,
+// before the hooks
+func (w *Watcher) SetOnRotate(f func() error) {
+	w.onRotate = f
+}
+
+func (w *Watcher) SetBackoff(b *retry.Backoff) {
+	w.backoff = b
+}
+func (w *Watcher) Backoff() *retry.Backoff {
+	return w.backoff
+}
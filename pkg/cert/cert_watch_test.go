@@ -0,0 +1,190 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package cert
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type fakeHook struct {
+	runs int
+	err  error
+}
+
+func (h *fakeHook) Run() error {
+	h.runs++
+	return h.err
+}
+
+func (h *fakeHook) String() string {
+	return "fake"
+}
+
+func TestCert_Watch_Interval(t *testing.T) {
+	w := NewWatcher(nil, nil)
+	w.SetFraction(0.5)
+
+	now := time.Now()
+	cert := &x509.Certificate{
+		NotBefore: now.Add(-time.Hour * 24),
+		NotAfter:  now.Add(time.Hour * 24 * 3),
+	}
+	for n := 0; n < 20; n++ {
+		wait := w.RotateInterval(cert)
+		if wait > time.Hour*24 || wait < time.Hour*21 {
+			t.Errorf("unexpected rotate interval: %s", wait)
+		}
+	}
+
+	// past the renew fraction
+	cert.NotBefore = now.Add(-time.Hour * 24 * 3)
+	if wait := w.RotateInterval(cert); wait != time.Second {
+		t.Errorf("unexpected rotate interval for certificate past its renew fraction: %s", wait)
+	}
+}
+
+// Existing valid certificate is kept, onRotate runs once and hooks only for
+// a new certificate
+func TestCert_Watch_Sync(t *testing.T) {
+	c := newVerifyTestCert(t)
+	newTestCA(t).sign(t, c, validTemplate())
+
+	w := NewWatcher(c.Log, c)
+	written := 0
+	w.SetOnRotate(func() error {
+		written++
+		return nil
+	})
+	hook := &fakeHook{}
+	w.AddHook(hook)
+
+	var rotateAt time.Time
+	for n := 0; n < 2; n++ {
+		wait, err := w.sync("")
+		if err != nil {
+			t.Fatalf("error syncing certificate: %v", err)
+		}
+		if wait <= time.Second {
+			t.Errorf("unexpected wait for valid certificate: %s", wait)
+		}
+		// the rotation time is kept for the same certificate
+		if n > 0 && !rotateAt.Equal(w.rotateAt) {
+			t.Errorf("unexpected rotation time exp=%s got=%s", rotateAt, w.rotateAt)
+		}
+		rotateAt = w.rotateAt
+	}
+	if exp, act := 1, written; exp != act {
+		t.Errorf("unexpected number of onRotate runs exp=%d got=%d", exp, act)
+	}
+	if exp, act := 0, hook.runs; exp != act {
+		t.Errorf("unexpected number of hook runs exp=%d got=%d", exp, act)
+	}
+
+	// failed hooks are retried without rotating the certificate again
+	w.reload = true
+	hook.err = errors.New("reload failed")
+	if _, err := w.sync("rotate"); err == nil {
+		t.Error("expected error for failed hook")
+	}
+	hook.err = nil
+	if _, err := w.sync("rotate"); err != nil {
+		t.Fatalf("error syncing certificate: %v", err)
+	}
+	if exp, act := 2, hook.runs; exp != act {
+		t.Errorf("unexpected number of hook runs exp=%d got=%d", exp, act)
+	}
+	if w.reload {
+		t.Error("expected reload to be done")
+	}
+}
+
+func TestCert_Hook_Exec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-cert-hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tempDirs = append(tempDirs, dir)
+	path := filepath.Join(dir, "reloaded")
+
+	if err := NewExecHook("touch " + path).Run(); err != nil {
+		t.Fatalf("error running exec hook: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected exec hook to create file: %v", err)
+	}
+
+	if err := NewExecHook("exit 3").Run(); err == nil {
+		t.Error("expected error for failing command")
+	}
+}
+
+func TestCert_Hook_Signal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-cert-hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tempDirs = append(tempDirs, dir)
+	pidFile := filepath.Join(dir, "pid")
+
+	if err := ioutil.WriteFile(pidFile, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGUSR1)
+	defer signal.Stop(signalChan)
+
+	h, err := NewSignalHook(pidFile, "SIGUSR1")
+	if err != nil {
+		t.Fatalf("error creating signal hook: %v", err)
+	}
+	if err := h.Run(); err != nil {
+		t.Fatalf("error running signal hook: %v", err)
+	}
+
+	select {
+	case <-signalChan:
+	case <-time.After(time.Second * 5):
+		t.Error("signal not received")
+	}
+
+	h, err = NewSignalHook(filepath.Join(dir, "missing"), "HUP")
+	if err != nil {
+		t.Fatalf("error creating signal hook: %v", err)
+	}
+	if err := h.Run(); err == nil {
+		t.Error("expected error for missing pid file")
+	}
+}
+
+func TestCert_Hook_ParseSignal(t *testing.T) {
+	for s, exp := range map[string]syscall.Signal{
+		"HUP":     syscall.SIGHUP,
+		"sighup":  syscall.SIGHUP,
+		"SIGUSR2": syscall.SIGUSR2,
+		"15":      syscall.SIGTERM,
+	} {
+		sig, err := ParseSignal(s)
+		if err != nil {
+			t.Errorf("error parsing signal %s: %v", s, err)
+			continue
+		}
+		if sig != exp {
+			t.Errorf("unexpected signal for %s exp=%s got=%s", s, exp, sig)
+		}
+	}
+
+	for _, s := range []string{"", "RELOAD", "-1"} {
+		if _, err := ParseSignal(s); err == nil {
+			t.Errorf("expected error parsing signal '%s'", s)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package file
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const BackupSuffix = ".bak"
+
+// StagedFile is a file written to a temporary path, to be moved into place
+type StagedFile struct {
+	Path   string
+	Staged string
+}
+
+// WriteAtomic writes data to a temporary file in the same directory and
+// renames it to path once synced to disk, so that readers and crashes never
+// see a partially written file
+func WriteAtomic(path string, data []byte, perm os.FileMode) error {
+	tmpPath, err := Stage(path, data, perm)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("error renaming file '%s' to '%s': %v", tmpPath, path, err)
+	}
+
+	return SyncDir(filepath.Dir(path))
+}
+
+// Stage writes data to a temporary file in the directory of path, synced to
+// disk, and returns the path of the temporary file
+func Stage(path string, data []byte, perm os.FileMode) (string, error) {
+	dir := filepath.Dir(path)
+
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return "", fmt.Errorf("error creating temporary file in '%s': %v", dir, err)
+	}
+	tmpPath := f.Name()
+
+	// removes the temporary file in case of any failure
+	staged := false
+	defer func() {
+		if !staged {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return "", fmt.Errorf("error writing to temporary file '%s': %v", tmpPath, err)
+	}
+
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return "", fmt.Errorf("error changing permissons of file '%s' to %#o: %v", tmpPath, perm, err)
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return "", fmt.Errorf("error syncing file '%s': %v", tmpPath, err)
+	}
+
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("error closing file '%s': %v", tmpPath, err)
+	}
+	staged = true
+
+	return tmpPath, nil
+}
+
+// ReplaceAll moves all staged files into place. The previous files are kept
+// with BackupSuffix. If any file can't be moved into place, the files already
+// replaced are rolled back, so that either all or none of the files are
+// replaced. Staged files which haven't been moved are removed.
+func ReplaceAll(files []StagedFile) error {
+	defer func() {
+		for _, f := range files {
+			os.Remove(f.Staged)
+		}
+	}()
+
+	// hard links keep the previous files in place until they are replaced
+	existed := make([]bool, len(files))
+	for n, f := range files {
+		backup := f.Path + BackupSuffix
+		if err := os.Remove(backup); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing backup file '%s': %v", backup, err)
+		}
+
+		err := os.Link(f.Path, backup)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error creating backup file '%s': %v", backup, err)
+		}
+		existed[n] = true
+	}
+
+	for n, f := range files {
+		if err := os.Rename(f.Staged, f.Path); err != nil {
+			err = fmt.Errorf("error renaming file '%s' to '%s': %v", f.Staged, f.Path, err)
+			if rerr := rollback(files[:n], existed); rerr != nil {
+				return fmt.Errorf("%v, rollback failed: %v", err, rerr)
+			}
+			return err
+		}
+	}
+
+	for _, dir := range dirs(files) {
+		if err := SyncDir(dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rollback restores the backups of the replaced files, or removes the files
+// which didn't exist before
+func rollback(files []StagedFile, existed []bool) error {
+	for n, f := range files {
+		if !existed[n] {
+			if err := os.Remove(f.Path); err != nil {
+				return fmt.Errorf("error removing file '%s': %v", f.Path, err)
+			}
+			continue
+		}
+
+		if err := os.Rename(f.Path+BackupSuffix, f.Path); err != nil {
+			return fmt.Errorf("error restoring file '%s' from backup: %v", f.Path, err)
+		}
+	}
+
+	return nil
+}
+
+func dirs(files []StagedFile) []string {
+	var dirs []string
+	seen := make(map[string]bool)
+	for _, f := range files {
+		if dir := filepath.Dir(f.Path); !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return dirs
+}
+
+// SyncDir persists renames and removals of files inside the directory
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("error opening directory '%s': %v", dir, err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("error syncing directory '%s': %v", dir, err)
+	}
+
+	return nil
+}
+
+// Lock takes an exclusive advisory lock on the lock file at path, blocking
+// until it is acquired. The returned function releases the lock.
+func Lock(path string) (unlock func(), err error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening lock file '%s': %v", path, err)
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error locking file '%s': %v", path, err)
+	}
+
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,194 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "vault-helper-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestWriteAtomic(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(path, []byte("old-token-which-is-longer"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteAtomic(path, []byte("new-token"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "new-token", string(b); exp != act {
+		t.Errorf("unexpected content exp=%s got=%s", exp, act)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := os.FileMode(0600), fi.Mode().Perm(); exp != act {
+		t.Errorf("unexpected permissions exp=%s got=%s", exp, act)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expected only the written file in directory, got %d files", len(files))
+	}
+}
+
+func TestLock(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, ".lock")
+
+	unlock, err := Lock(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		unlock, err := Lock(path)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return
+		}
+		close(locked)
+		unlock()
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("lock acquired while held")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	unlock()
+
+	select {
+	case <-locked:
+	case <-time.After(time.Second * 5):
+		t.Fatal("lock not acquired after release")
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestReplaceAll(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	cert := filepath.Join(dir, "cert.pem")
+	key := filepath.Join(dir, "cert-key.pem")
+	if err := ioutil.WriteFile(cert, []byte("old-cert"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var files []StagedFile
+	for path, data := range map[string]string{cert: "new-cert", key: "new-key"} {
+		staged, err := Stage(path, []byte(data), 0600)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if exp, act := "old-cert", readFile(t, cert); exp != act {
+			t.Errorf("file replaced while staging exp=%s got=%s", exp, act)
+		}
+		files = append(files, StagedFile{Path: path, Staged: staged})
+	}
+
+	if err := ReplaceAll(files); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if exp, act := "new-cert", readFile(t, cert); exp != act {
+		t.Errorf("unexpected content exp=%s got=%s", exp, act)
+	}
+	if exp, act := "new-key", readFile(t, key); exp != act {
+		t.Errorf("unexpected content exp=%s got=%s", exp, act)
+	}
+	if exp, act := "old-cert", readFile(t, cert+BackupSuffix); exp != act {
+		t.Errorf("unexpected backup content exp=%s got=%s", exp, act)
+	}
+	if _, err := os.Stat(key + BackupSuffix); !os.IsNotExist(err) {
+		t.Errorf("expected no backup of new file, got: %v", err)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := 3, len(entries); exp != act {
+		t.Errorf("unexpected number of files in directory exp=%d got=%d", exp, act)
+	}
+}
+
+// Failure to move a file into place rolls back the files already replaced
+func TestReplaceAll_Rollback(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	cert := filepath.Join(dir, "cert.pem")
+	key := filepath.Join(dir, "cert-key.pem")
+	ca := filepath.Join(dir, "cert-ca.pem")
+	for _, path := range []string{cert, ca} {
+		if err := ioutil.WriteFile(path, []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var files []StagedFile
+	for _, path := range []string{cert, key, ca} {
+		staged, err := Stage(path, []byte("new"), 0600)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		files = append(files, StagedFile{Path: path, Staged: staged})
+	}
+	if err := os.Remove(files[2].Staged); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ReplaceAll(files); err == nil {
+		t.Fatal("expected error for missing staged file")
+	}
+
+	for _, path := range []string{cert, ca} {
+		if exp, act := "old", readFile(t, path); exp != act {
+			t.Errorf("unexpected content of %s exp=%s got=%s", path, exp, act)
+		}
+	}
+	if _, err := os.Stat(key); !os.IsNotExist(err) {
+		t.Errorf("expected new file to be removed, got: %v", err)
+	}
+	for _, f := range files {
+		if _, err := os.Stat(f.Staged); !os.IsNotExist(err) {
+			t.Errorf("expected staged file to be removed, got: %v", err)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package instanceToken
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+const (
+	FlagAuthMethod       = "auth-method"
+	FlagAuthMount        = "auth-mount"
+	FlagAuthRole         = "auth-role"
+	FlagAuthRoleIDPath   = "auth-role-id-path"
+	FlagAuthSecretIDPath = "auth-secret-id-path"
+	FlagAuthJWTPath      = "auth-jwt-path"
+	FlagAuthClientCert   = "auth-client-cert"
+	FlagAuthClientKey    = "auth-client-key"
+)
+
+// methods to get a token with. With the token method, the token is created
+// from the init token. With all others, the token is the login token.
+const (
+	AuthMethodToken      = "token"
+	AuthMethodAppRole    = "approle"
+	AuthMethodCert       = "cert"
+	AuthMethodKubernetes = "kubernetes"
+)
+
+const DefaultJWTPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// Authenticator logs in to vault, returning the login token
+type Authenticator interface {
+	Login(vaultClient *vault.Client) (token string, err error)
+}
+
+var _ Authenticator = &appRoleAuthenticator{}
+var _ Authenticator = &certAuthenticator{}
+var _ Authenticator = &kubernetesAuthenticator{}
+
+type appRoleAuthenticator struct {
+	mount        string
+	roleIDPath   string
+	secretIDPath string
+}
+
+func (a *appRoleAuthenticator) Login(vaultClient *vault.Client) (string, error) {
+	roleID, err := readCredential(a.roleIDPath)
+	if err != nil {
+		return "", err
+	}
+	secretID, err := readCredential(a.secretIDPath)
+	if err != nil {
+		return "", err
+	}
+
+	s, err := vaultClient.Logical().Write(loginPath(a.mount), map[string]interface{}{
+		"role_id":   roleID,
+		"secret_id": secretID,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error logging in with AppRole: %v", err)
+	}
+
+	return loginToken(s, AuthMethodAppRole)
+}
+
+// certAuthenticator logs in with the TLS client certificate of the vault
+// client
+type certAuthenticator struct {
+	mount string
+	name  string
+}
+
+func (c *certAuthenticator) Login(vaultClient *vault.Client) (string, error) {
+	data := map[string]interface{}{}
+	if c.name != "" {
+		data["name"] = c.name
+	}
+
+	s, err := vaultClient.Logical().Write(loginPath(c.mount), data)
+	if err != nil {
+		return "", fmt.Errorf("error logging in with TLS certificate: %v", err)
+	}
+
+	return loginToken(s, AuthMethodCert)
+}
+
+// kubernetesAuthenticator logs in with a Kubernetes service account token
+type kubernetesAuthenticator struct {
+	mount   string
+	role    string
+	jwtPath string
+}
+
+func (k *kubernetesAuthenticator) Login(vaultClient *vault.Client) (string, error) {
+	if k.role == "" {
+		return "", fmt.Errorf("a role is required to log in with kubernetes: --%s", FlagAuthRole)
+	}
+
+	jwt, err := readCredential(k.jwtPath)
+	if err != nil {
+		return "", err
+	}
+
+	s, err := vaultClient.Logical().Write(loginPath(k.mount), map[string]interface{}{
+		"role": k.role,
+		"jwt":  jwt,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error logging in with kubernetes: %v", err)
+	}
+
+	return loginToken(s, AuthMethodKubernetes)
+}
+
+func loginPath(mount string) string {
+	return filepath.Join("auth", mount, "login")
+}
+
+func loginToken(s *vault.Secret, method string) (string, error) {
+	if s == nil || s.Auth == nil || s.Auth.ClientToken == "" {
+		return "", fmt.Errorf("no token returned from %s login", method)
+	}
+
+	return s.Auth.ClientToken, nil
+}
+
+func readCredential(path string) (string, error) {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading credential from file '%s': %v", path, err)
+	}
+
+	credential := strings.TrimSpace(string(dat))
+	if credential == "" {
+		return "", fmt.Errorf("credential file '%s' is empty", path)
+	}
+
+	return credential, nil
+}
+
+// Authenticator returns the authenticator of the auth method. There is none
+// for the token method.
+func (i *InstanceToken) Authenticator() (Authenticator, error) {
+	switch i.AuthMethod() {
+	case AuthMethodToken:
+		return nil, nil
+	case AuthMethodAppRole:
+		return &appRoleAuthenticator{
+			mount:        i.AuthMount(),
+			roleIDPath:   i.AuthRoleIDPath(),
+			secretIDPath: i.AuthSecretIDPath(),
+		}, nil
+	case AuthMethodCert:
+		return &certAuthenticator{
+			mount: i.AuthMount(),
+			name:  i.AuthRole(),
+		}, nil
+	case AuthMethodKubernetes:
+		return &kubernetesAuthenticator{
+			mount:   i.AuthMount(),
+			role:    i.AuthRole(),
+			jwtPath: i.AuthJWTPath(),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown auth method '%s', valid methods are: %s", i.AuthMethod(), strings.Join([]string{AuthMethodToken, AuthMethodAppRole, AuthMethodCert, AuthMethodKubernetes}, ", "))
+}
+
+// loginNew creates a new token by logging in with the auth method
+func (i *InstanceToken) loginNew() error {
+	authenticator, err := i.Authenticator()
+	if err != nil {
+		return err
+	}
+	if authenticator == nil {
+		return errors.New("no authenticator for auth method token")
+	}
+
+	// login must not be sent with a stale token
+	i.vaultClient.ClearToken()
+
+	token, err := authenticator.Login(i.vaultClient)
+	if err != nil {
+		return err
+	}
+
+	i.SetToken(token)
+	i.Log.Infof("Logged in with auth method %s at auth/%s", i.AuthMethod(), i.AuthMount())
+
+	return nil
+}
+
+func (i *InstanceToken) SetAuthMethod(method string) {
+	i.authMethod = method
+}
+
+func (i *InstanceToken) AuthMethod() (method string) {
+	if i.authMethod == "" {
+		return AuthMethodToken
+	}
+	return i.authMethod
+}
+
+// SetAuthMount sets the mount path of the auth backend, which defaults to the
+// name of the auth method
+func (i *InstanceToken) SetAuthMount(mount string) {
+	i.authMount = mount
+}
+
+func (i *InstanceToken) AuthMount() (mount string) {
+	if i.authMount == "" {
+		return i.AuthMethod()
+	}
+	return i.authMount
+}
+
+// SetAuthRole sets the role to log in with, the certificate role name for the
+// cert method
+func (i *InstanceToken) SetAuthRole(role string) {
+	i.authRole = role
+}
+
+func (i *InstanceToken) AuthRole() (role string) {
+	return i.authRole
+}
+
+func (i *InstanceToken) SetAuthRoleIDPath(path string) {
+	i.authRoleIDPath = path
+}
+
+func (i *InstanceToken) AuthRoleIDPath() (path string) {
+	if i.authRoleIDPath == "" {
+		return i.AppRoleRoleIDFilePath()
+	}
+	return i.authRoleIDPath
+}
+
+func (i *InstanceToken) SetAuthSecretIDPath(path string) {
+	i.authSecretIDPath = path
+}
+
+func (i *InstanceToken) AuthSecretIDPath() (path string) {
+	if i.authSecretIDPath == "" {
+		return i.AppRoleSecretIDFilePath()
+	}
+	return i.authSecretIDPath
+}
+
+func (i *InstanceToken) SetAuthJWTPath(path string) {
+	i.authJWTPath = path
+}
+
+func (i *InstanceToken) AuthJWTPath() (path string) {
+	if i.authJWTPath == "" {
+		return DefaultJWTPath
+	}
+	return i.authJWTPath
+}
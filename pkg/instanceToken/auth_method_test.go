@@ -0,0 +1,217 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package instanceToken_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+
+	"github.com/jetstack/vault-helper/pkg/instanceToken"
+)
+
+// fakeLoginVault logs in with the expected login data at path, returning
+// numbered tokens. Lookups of tokens other than the last are denied.
+func fakeLoginVault(t *testing.T, path string, expData map[string]string) (*httptest.Server, *int) {
+	logins := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case path:
+			data := make(map[string]interface{})
+			if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+				t.Errorf("error decoding request: %v", err)
+			}
+			for k, v := range expData {
+				if data[k] != v {
+					t.Errorf("unexpected login data %s exp=%s got=%v", k, v, data[k])
+				}
+			}
+
+			logins++
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"auth": map[string]interface{}{
+					"client_token": fmt.Sprintf("login-token-%d", logins),
+				},
+			})
+
+		case "/v1/auth/token/lookup-self":
+			if r.Header.Get("X-Vault-Token") != fmt.Sprintf("login-token-%d", logins) {
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"permission denied"}})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{"renewable": false},
+			})
+
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	return server, &logins
+}
+
+func newLoginInstanceToken(t *testing.T, address string) *instanceToken.InstanceToken {
+	v, err := vault.NewClient(&vault.Config{Address: address, HttpClient: http.DefaultClient})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := instanceToken.New(v, logrus.NewEntry(logrus.New()))
+	i.SetVaultConfigPath(tmpDir(t))
+
+	return i
+}
+
+func TestAuthMethod_AppRole(t *testing.T) {
+	server, logins := fakeLoginVault(t, "/v1/auth/approle/login", map[string]string{
+		"role_id":   "my-role-id",
+		"secret_id": "my-secret-id",
+	})
+	defer server.Close()
+
+	i := newLoginInstanceToken(t, server.URL)
+	i.SetAuthMethod(instanceToken.AuthMethodAppRole)
+	for path, credential := range map[string]string{
+		i.AppRoleRoleIDFilePath():   "my-role-id\n",
+		i.AppRoleSecretIDFilePath(): "my-secret-id\n",
+	} {
+		if err := ioutil.WriteFile(path, []byte(credential), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := i.TokenRenewRun(); err != nil {
+		t.Fatalf("error logging in: %v", err)
+	}
+	if exp, act := "login-token-1", i.Token(); exp != act {
+		t.Errorf("unexpected token exp=%s got=%s", exp, act)
+	}
+	token, err := i.TokenRetrieve()
+	if err != nil {
+		t.Fatalf("error retrieving token: %v", err)
+	}
+	if exp, act := "login-token-1", token; exp != act {
+		t.Errorf("unexpected stored token exp=%s got=%s", exp, act)
+	}
+	if _, err := os.Stat(i.InitTokenFilePath()); !os.IsNotExist(err) {
+		t.Error("expected no init token file with auth method approle")
+	}
+
+	// stored token is valid - no new login
+	if err := i.TokenRenewRun(); err != nil {
+		t.Fatalf("error renewing token: %v", err)
+	}
+	if exp, act := 1, *logins; exp != act {
+		t.Errorf("unexpected number of logins exp=%d got=%d", exp, act)
+	}
+
+	// stored token is rejected - log in again
+	if err := i.WriteTokenFile(i.TokenFilePath(), "revoked-token"); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.TokenRenewRun(); err != nil {
+		t.Fatalf("error re-bootstrapping token: %v", err)
+	}
+	if exp, act := "login-token-2", i.Token(); exp != act {
+		t.Errorf("unexpected token exp=%s got=%s", exp, act)
+	}
+}
+
+// AppRole credentials are used at the configured mount to create a token
+// from with the token auth method
+func TestAuthMethod_AppRoleMount(t *testing.T) {
+	var logins int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/nodes-approle/login":
+			logins++
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"auth": map[string]interface{}{"client_token": "login-token"},
+			})
+		case "/v1/sys/wrapping/lookup":
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"wrapping token is not valid or does not exist"}})
+		case "/v1/auth/token/lookup-self":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{"policies": []string{"default", "worker"}},
+			})
+		case "/v1/auth/token/create/test-cluster-worker":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"auth": map[string]interface{}{"client_token": "new-token"},
+			})
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	i := newLoginInstanceToken(t, server.URL)
+	i.SetInitRole("test-cluster-worker")
+	i.SetAuthMount("nodes-approle")
+	for path, credential := range map[string]string{
+		i.AppRoleRoleIDFilePath():   "my-role-id\n",
+		i.AppRoleSecretIDFilePath(): "my-secret-id\n",
+	} {
+		if err := ioutil.WriteFile(path, []byte(credential), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := i.TokenRenewRun(); err != nil {
+		t.Fatalf("error creating token: %v", err)
+	}
+	if exp, act := "new-token", i.Token(); exp != act {
+		t.Errorf("unexpected token exp=%s got=%s", exp, act)
+	}
+	if exp, act := 1, logins; exp != act {
+		t.Errorf("unexpected number of logins exp=%d got=%d", exp, act)
+	}
+}
+
+func TestAuthMethod_Kubernetes(t *testing.T) {
+	server, _ := fakeLoginVault(t, "/v1/auth/k8s-cluster/login", map[string]string{
+		"role": "vault-helper",
+		"jwt":  "my-jwt",
+	})
+	defer server.Close()
+
+	i := newLoginInstanceToken(t, server.URL)
+	i.SetAuthMethod(instanceToken.AuthMethodKubernetes)
+	i.SetAuthMount("k8s-cluster")
+	i.SetAuthJWTPath(filepath.Join(i.VaultConfigPath(), "jwt"))
+	if err := ioutil.WriteFile(i.AuthJWTPath(), []byte("my-jwt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := i.TokenRenewRun(); err == nil {
+		t.Error("expected error logging in without role")
+	}
+
+	i.SetAuthRole("vault-helper")
+	if err := i.TokenRenewRun(); err != nil {
+		t.Fatalf("error logging in: %v", err)
+	}
+	if exp, act := "login-token-1", i.Token(); exp != act {
+		t.Errorf("unexpected token exp=%s got=%s", exp, act)
+	}
+}
+
+func TestAuthMethod_Unknown(t *testing.T) {
+	i := instanceToken.New(nil, nil)
+	i.SetAuthMethod("ldap")
+
+	if _, err := i.Authenticator(); err == nil {
+		t.Error("expected error for unknown auth method")
+	}
+}
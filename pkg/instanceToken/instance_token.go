@@ -10,11 +10,27 @@ import (
 
 const FlagInitRole = "init-role"
 const FlagConfigPath = "config-path"
+const FlagNodeName = "node-name"
+const FlagNodeEntityAlias = "node-entity-alias"
 
 type InstanceToken struct {
 	token           string
 	initRole        string
 	vaultConfigPath string
+	nodeName        string
+	entityAlias     bool
+	renewFraction   float64
+
+	preserveInitToken bool
+	initTokenWrapped  bool
+	tokenStore        TokenStore
+
+	authMethod       string
+	authMount        string
+	authRole         string
+	authRoleIDPath   string
+	authSecretIDPath string
+	authJWTPath      string
 
 	Log         *logrus.Entry
 	vaultClient *vault.Client
@@ -44,19 +60,43 @@ func (i *InstanceToken) VaultConfigPath() (path string) {
 	return i.vaultConfigPath
 }
 
+// SetNodeName sets the name of the node, which new tokens carry as metadata
+func (i *InstanceToken) SetNodeName(nodeName string) {
+	i.nodeName = nodeName
+}
+
+func (i *InstanceToken) NodeName() (nodeName string) {
+	return i.nodeName
+}
+
+// SetEntityAlias sets whether new tokens are created with the node name as
+// entity alias, which restricted kubelet roles match certificates against
+func (i *InstanceToken) SetEntityAlias(entityAlias bool) {
+	i.entityAlias = entityAlias
+}
+
+func (i *InstanceToken) EntityAlias() (entityAlias bool) {
+	return i.entityAlias
+}
+
 func (i *InstanceToken) TokenFilePath() (path string) {
 	return filepath.Join(i.VaultConfigPath(), "token")
 }
 func (i *InstanceToken) InitTokenFilePath() (path string) {
 	return filepath.Join(i.VaultConfigPath(), "init-token")
 }
+func (i *InstanceToken) LockFilePath() (path string) {
+	return filepath.Join(i.VaultConfigPath(), ".lock")
+}
 
 func (i *InstanceToken) VaultClient() (vaultClient *vault.Client) {
 	return i.vaultClient
 }
 
 func New(vaultClient *vault.Client, logger *logrus.Entry) *InstanceToken {
-	i := &InstanceToken{}
+	i := &InstanceToken{
+		renewFraction: 0.5,
+	}
 
 	if vaultClient != nil {
 		i.vaultClient = vaultClient
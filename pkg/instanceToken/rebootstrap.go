@@ -0,0 +1,194 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package instanceToken
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+)
+
+const FlagPreserveInitToken = "preserve-init-token"
+
+// sources an init token is read from, in order of preference
+const (
+	SourceInitToken          = "init-token"
+	SourcePreservedInitToken = "preserved-init-token"
+	SourceAppRole            = "approle"
+)
+
+// ErrNoRebootstrapSource is returned if vault rejects the token and there is
+// no source to create a new token from
+var ErrNoRebootstrapSource = errors.New("no init token, preserved init token (--" + FlagPreserveInitToken + ") or AppRole credentials to create a new token from")
+
+func (i *InstanceToken) PreservedInitTokenFilePath() (path string) {
+	return filepath.Join(i.VaultConfigPath(), "init-token-preserved")
+}
+func (i *InstanceToken) AppRoleRoleIDFilePath() (path string) {
+	return filepath.Join(i.VaultConfigPath(), "approle-role-id")
+}
+func (i *InstanceToken) AppRoleSecretIDFilePath() (path string) {
+	return filepath.Join(i.VaultConfigPath(), "approle-secret-id")
+}
+
+// SetPreserveInitToken sets whether the init token is kept in a separate
+// file after bootstrapping, to re-bootstrap if the token gets revoked
+func (i *InstanceToken) SetPreserveInitToken(preserve bool) {
+	i.preserveInitToken = preserve
+}
+
+func (i *InstanceToken) PreserveInitToken() (preserve bool) {
+	return i.preserveInitToken
+}
+
+// readInitToken returns the init token from the first available source. An
+// empty token is returned if there is none.
+func (i *InstanceToken) readInitToken() (token, source string, err error) {
+	for _, s := range []struct {
+		source string
+		path   string
+	}{
+		{SourceInitToken, i.InitTokenFilePath()},
+		{SourcePreservedInitToken, i.PreservedInitTokenFilePath()},
+	} {
+		token, err := i.storeFor(s.path).Read(s.path)
+		if err != nil {
+			return "", "", fmt.Errorf("error reading token '%s': %v", s.path, err)
+		}
+		if token != "" {
+			i.Log.Debugf("init token found at '%s'", s.path)
+			return token, s.source, nil
+		}
+	}
+
+	token, err = i.appRoleLogin()
+	if err != nil {
+		return "", "", err
+	}
+	if token != "" {
+		return token, SourceAppRole, nil
+	}
+
+	return "", "", nil
+}
+
+// rebootstrapSourceExists returns whether there is an init token, preserved
+// init token or AppRole credentials, without using them
+func (i *InstanceToken) rebootstrapSourceExists() (bool, error) {
+	for _, path := range []string{i.InitTokenFilePath(), i.PreservedInitTokenFilePath()} {
+		token, err := i.storeFor(path).Read(path)
+		if err != nil {
+			return false, fmt.Errorf("error reading token '%s': %v", path, err)
+		}
+		if token != "" {
+			return true, nil
+		}
+	}
+
+	for _, path := range []string{i.AppRoleRoleIDFilePath(), i.AppRoleSecretIDFilePath()} {
+		exists, err := i.fileExists(path)
+		if err != nil || !exists {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (i *InstanceToken) tokenFromFileIfExists(path string) (string, error) {
+	exists, err := i.fileExists(path)
+	if err != nil {
+		return "", fmt.Errorf("error checking file exists: %v", err)
+	}
+	if !exists {
+		return "", nil
+	}
+
+	token, err := i.TokenFromFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading token from file: %v", err)
+	}
+
+	return token, nil
+}
+
+// appRoleLogin logs in with the AppRole credentials in the config directory,
+// if present. The AppRole token needs the same policies as the init token.
+func (i *InstanceToken) appRoleLogin() (string, error) {
+	roleID, err := i.tokenFromFileIfExists(i.AppRoleRoleIDFilePath())
+	if err != nil {
+		return "", err
+	}
+	secretID, err := i.tokenFromFileIfExists(i.AppRoleSecretIDFilePath())
+	if err != nil {
+		return "", err
+	}
+	if roleID == "" || secretID == "" {
+		return "", nil
+	}
+
+	i.Log.Infof("Logging in with AppRole credentials from '%s'", i.VaultConfigPath())
+	a := &appRoleAuthenticator{
+		mount:        i.appRoleMount(),
+		roleIDPath:   i.AppRoleRoleIDFilePath(),
+		secretIDPath: i.AppRoleSecretIDFilePath(),
+	}
+
+	return a.Login(i.vaultClient)
+}
+
+// appRoleMount returns the mount path of the AppRole backend to re-bootstrap
+// with, set by --auth-mount with the token and approle auth methods
+func (i *InstanceToken) appRoleMount() string {
+	switch i.AuthMethod() {
+	case AuthMethodToken, AuthMethodAppRole:
+		if i.authMount != "" {
+			return i.authMount
+		}
+	}
+
+	return AuthMethodAppRole
+}
+
+// renewOrRebootstrap renews the token. If vault rejects the token, as it has
+// been revoked or has expired, a new token is created once from the
+// re-bootstrap sources.
+func (i *InstanceToken) renewOrRebootstrap() error {
+	err := i.tokenRenew()
+	if err == nil || !isPermissionDenied(err) {
+		return err
+	}
+
+	return i.rebootstrapToken(err)
+}
+
+func (i *InstanceToken) rebootstrapToken(cause error) error {
+	audit := i.Log.WithFields(logrus.Fields{
+		"audit":       "token-rebootstrap",
+		"role":        i.InitRole(),
+		"config_path": i.VaultConfigPath(),
+	})
+	audit.Warnf("Token was rejected by vault, creating new token from re-bootstrap source: %v", cause)
+
+	// the init token has been wiped after bootstrapping, unless preserved
+	if i.AuthMethod() == AuthMethodToken {
+		exists, err := i.rebootstrapSourceExists()
+		if err != nil {
+			return err
+		}
+		if !exists {
+			audit.Errorf("Re-bootstrapping token failed: %v", ErrNoRebootstrapSource)
+			return ErrNoRebootstrapSource
+		}
+	}
+
+	if err := i.bootstrapToken(); err != nil {
+		audit.Errorf("Re-bootstrapping token failed: %v", err)
+		return fmt.Errorf("token was rejected by vault (%v) and re-bootstrapping failed: %v", cause, err)
+	}
+
+	audit.Warnf("Token re-bootstrapped, token written to file: %s", i.TokenFilePath())
+
+	return nil
+}
@@ -0,0 +1,60 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package instanceToken_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/jetstack/vault-helper/pkg/testing/vault_dev"
+)
+
+// Token revoked - re-bootstrap from the preserved init token, once
+func TestRenew_Token_Revoked_Rebootstrap(t *testing.T) {
+	initKubernetes(t, vaultDev)
+	defer vaultDev.Client().SetToken(vault_dev.RootTokenDev)
+
+	i := initInstanceToken(t, vaultDev)
+	i.SetPreserveInitToken(true)
+
+	if err := i.WriteTokenFile(i.InitTokenFilePath(), vault_dev.RootTokenDev); err != nil {
+		t.Fatalf("error setting token for test: %v", err)
+	}
+
+	if err := i.TokenRenewRun(); err != nil {
+		t.Fatalf("error creating token: %v", err)
+	}
+	tokenCheckFiles(t, i)
+
+	preserved, err := i.TokenFromFile(i.PreservedInitTokenFilePath())
+	if err != nil {
+		t.Fatalf("error reading preserved init token: %v", err)
+	}
+	if exp, act := vault_dev.RootTokenDev, preserved; exp != act {
+		t.Errorf("unexpected preserved init token exp=%s got=%s", exp, act)
+	}
+
+	revoked := i.Token()
+	if err := i.VaultClient().Auth().Token().RevokeSelf(""); err != nil {
+		t.Fatalf("error revoking token: %v", err)
+	}
+
+	if err := i.TokenRenewRun(); err != nil {
+		t.Fatalf("error re-bootstrapping revoked token: %v", err)
+	}
+	if i.Token() == revoked {
+		t.Error("expected a new token to be created")
+	}
+	tokenCheckFiles(t, i)
+
+	// without any re-bootstrap source the error is returned
+	if err := i.VaultClient().Auth().Token().RevokeSelf(""); err != nil {
+		t.Fatalf("error revoking token: %v", err)
+	}
+	if err := os.Remove(i.PreservedInitTokenFilePath()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := i.TokenRenewRun(); err == nil {
+		t.Error("expected error without re-bootstrap source")
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package instanceToken
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/coreos/go-systemd/daemon"
+)
+
+const FlagDaemon = "daemon"
+const FlagRenewFraction = "renew-fraction"
+
+// renewal is retried after this interval if the token could not be renewed
+// or looked up
+const daemonRetryInterval = time.Minute
+
+// tokens without a TTL are looked up again after this interval
+const daemonMaxInterval = time.Hour
+
+// TokenRenewDaemon ensures the token and keeps renewing it at a fraction of
+// its TTL until stop is closed. If vault rejects the token, a new token is
+// created from the re-bootstrap sources; the daemon fails if there is none.
+// systemd is notified once the first renewal succeeded. Watchdog keep-alives
+// are sent by the renewal loop if enabled for the unit, so a renewal that
+// hangs stops them and systemd restarts the daemon.
+func (i *InstanceToken) TokenRenewDaemon(stop <-chan struct{}) error {
+	if err := i.TokenRenewRun(); err != nil {
+		return err
+	}
+
+	if _, err := daemon.SdNotify(false, "READY=1"); err != nil {
+		i.Log.Warnf("failed to notify systemd: %v", err)
+	}
+
+	ping, stopWatchdog := i.watchdogTicker()
+	defer stopWatchdog()
+
+	timer := time.NewTimer(i.scheduleRenewal())
+	for {
+		select {
+		case <-stop:
+			timer.Stop()
+			daemon.SdNotify(false, "STOPPING=1")
+			return nil
+
+		case <-ping:
+			daemon.SdNotify(false, "WATCHDOG=1")
+
+		case <-timer.C:
+			err := i.lockedRenew()
+			if err == ErrNoRebootstrapSource {
+				return fmt.Errorf("token was rejected by vault: %v", err)
+			}
+			if err != nil {
+				i.Log.Error(err)
+			}
+
+			// the renewal returned, so the loop is alive
+			if ping != nil {
+				daemon.SdNotify(false, "WATCHDOG=1")
+			}
+			timer.Reset(i.scheduleRenewal())
+		}
+	}
+}
+
+// watchdogTicker returns a channel ticking at half the watchdog interval of
+// the unit, or nil if the watchdog is not enabled
+func (i *InstanceToken) watchdogTicker() (ping <-chan time.Time, stop func()) {
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		i.Log.Warnf("failed to get systemd watchdog interval: %v", err)
+	}
+	if interval <= 0 {
+		return nil, func() {}
+	}
+
+	ticker := time.NewTicker(interval / 2)
+	return ticker.C, ticker.Stop
+}
+
+// scheduleRenewal returns the time to wait until the next renewal, or the
+// retry interval if the token could not be looked up
+func (i *InstanceToken) scheduleRenewal() time.Duration {
+	wait, err := i.nextRenewal()
+	if err != nil {
+		i.Log.Errorf("error looking up token, retrying in %s: %v", daemonRetryInterval, err)
+		wait = daemonRetryInterval
+	}
+	i.Log.Infof("Next token renewal in %s", wait)
+
+	return wait
+}
+
+// nextRenewal returns the time to wait until the token should be renewed
+func (i *InstanceToken) nextRenewal() (time.Duration, error) {
+	s, err := i.TokenLookup()
+	if err != nil {
+		return 0, err
+	}
+
+	ttl, err := s.TokenTTL()
+	if err != nil {
+		return 0, fmt.Errorf("error getting token ttl: %v", err)
+	}
+
+	return i.RenewInterval(ttl), nil
+}
+
+// RenewInterval returns the time to wait before renewing a token with the
+// given TTL, at the renew fraction of the TTL minus up to 10% jitter
+func (i *InstanceToken) RenewInterval(ttl time.Duration) time.Duration {
+	if ttl <= 0 {
+		return daemonMaxInterval
+	}
+
+	wait := time.Duration(float64(ttl) * i.RenewFraction())
+	if jitter := int64(wait / 10); jitter > 0 {
+		wait -= time.Duration(rand.Int63n(jitter))
+	}
+
+	if wait < time.Second {
+		wait = time.Second
+	}
+
+	return wait
+}
+
+func (i *InstanceToken) lockedRenew() error {
+	unlock, err := i.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return i.renewOrRebootstrap()
+}
+
+// SetRenewFraction sets the fraction of the token's TTL after which the
+// daemon renews it
+func (i *InstanceToken) SetRenewFraction(fraction float64) {
+	i.renewFraction = fraction
+}
+
+func (i *InstanceToken) RenewFraction() (fraction float64) {
+	return i.renewFraction
+}
@@ -0,0 +1,195 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package instanceToken_test
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jetstack/vault-helper/pkg/file"
+	"github.com/jetstack/vault-helper/pkg/instanceToken"
+	"github.com/jetstack/vault-helper/pkg/testing/vault_dev"
+)
+
+// Renewal is scheduled at the renew fraction of the TTL, minus jitter
+func TestRenew_Daemon_Interval(t *testing.T) {
+	i := initInstanceToken(t, vaultDev)
+	i.SetRenewFraction(0.5)
+
+	for n := 0; n < 20; n++ {
+		wait := i.RenewInterval(time.Hour)
+		if wait > time.Minute*30 || wait < time.Minute*27 {
+			t.Errorf("unexpected renew interval for ttl 1h: %s", wait)
+		}
+	}
+
+	if wait := i.RenewInterval(0); wait <= 0 {
+		t.Errorf("unexpected renew interval for token without ttl: %s", wait)
+	}
+}
+
+// Daemon creates the token from the init token and exits once stopped
+func TestRenew_Daemon_Stop(t *testing.T) {
+	initKubernetes(t, vaultDev)
+	i := initInstanceToken(t, vaultDev)
+
+	if err := i.WriteTokenFile(i.InitTokenFilePath(), vault_dev.RootTokenDev); err != nil {
+		t.Fatalf("error setting token for test: %v", err)
+	}
+
+	stop := make(chan struct{})
+	errCh := make(chan error)
+	go func() {
+		errCh <- i.TokenRenewDaemon(stop)
+	}()
+
+	time.Sleep(time.Millisecond * 500)
+	close(stop)
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("unexpected error from renew daemon: %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("renew daemon did not stop")
+	}
+
+	tokenCheckFiles(t, i)
+}
+
+// Daemon fails if the token is rejected and the init token has been wiped
+func TestRenew_Daemon_NoRebootstrapSource(t *testing.T) {
+	server := fakeLookupVault(t, 7200)
+	defer server.Close()
+
+	i := newLoginInstanceToken(t, server.URL)
+	if err := i.WriteTokenFile(i.TokenFilePath(), "revoked-token"); err != nil {
+		t.Fatal(err)
+	}
+
+	errCh := make(chan error)
+	go func() {
+		errCh <- i.TokenRenewDaemon(make(chan struct{}))
+	}()
+
+	select {
+	case err := <-errCh:
+		if err != instanceToken.ErrNoRebootstrapSource {
+			t.Errorf("expected no re-bootstrap source error, got: %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("renew daemon did not fail")
+	}
+}
+
+// fakeRenewVault looks up and renews any token with the given TTL
+func fakeRenewVault(t *testing.T, ttl int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/token/lookup-self":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{"ttl": ttl, "renewable": true},
+			})
+		case "/v1/auth/token/renew-self":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"auth": map[string]interface{}{"client_token": "my-token", "lease_duration": ttl, "renewable": true},
+			})
+		default:
+			t.Errorf("unexpected request path: %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+// Watchdog keep-alives are sent by the renewal loop, so they stop while a
+// renewal hangs on the lock
+func TestRenew_Daemon_Watchdog(t *testing.T) {
+	server := fakeRenewVault(t, 2)
+	defer server.Close()
+
+	dir := tmpDir(t)
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for key, value := range map[string]string{"NOTIFY_SOCKET": socket, "WATCHDOG_USEC": "200000"} {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+
+	messages := make(chan string, 100)
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			messages <- string(buf[:n])
+		}
+	}()
+	// expect waits for the message, discarding any other
+	expect := func(exp string, timeout time.Duration) {
+		deadline := time.After(timeout)
+		for {
+			select {
+			case msg := <-messages:
+				if msg == exp {
+					return
+				}
+			case <-deadline:
+				t.Fatalf("expected %s to be sent to systemd", exp)
+			}
+		}
+	}
+
+	i := newLoginInstanceToken(t, server.URL)
+	i.SetRenewFraction(0.5)
+	if err := i.WriteTokenFile(i.TokenFilePath(), "my-token"); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	errCh := make(chan error)
+	go func() {
+		errCh <- i.TokenRenewDaemon(stop)
+	}()
+
+	expect("READY=1", time.Second*5)
+	expect("WATCHDOG=1", time.Second)
+
+	// the renewal due after a second hangs on the lock
+	unlock, err := file.Lock(i.LockFilePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 1500)
+	for len(messages) > 0 {
+		<-messages
+	}
+	time.Sleep(time.Millisecond * 500)
+	if n := len(messages); n > 0 {
+		t.Errorf("expected no watchdog keep-alives while renewal hangs, got %d messages", n)
+	}
+
+	unlock()
+	expect("WATCHDOG=1", time.Second)
+
+	close(stop)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("unexpected error from renew daemon: %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("renew daemon did not stop")
+	}
+}
@@ -6,10 +6,14 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 
 	vault "github.com/hashicorp/vault/api"
 	"github.com/sirupsen/logrus"
+
+	"github.com/jetstack/vault-helper/pkg/file"
+	"github.com/jetstack/vault-helper/pkg/vaultVersion"
 )
 
 func (i *InstanceToken) TokenFromFile(path string) (token string, err error) {
@@ -36,108 +40,73 @@ func (i *InstanceToken) fileExists(path string) (bool, error) {
 }
 
 func (i *InstanceToken) TokenRetrieve() (token string, err error) {
-	exists, err := i.fileExists(i.TokenFilePath())
+	token, err = i.TokenStore().Read(i.TokenFilePath())
 	if err != nil {
-		return "", fmt.Errorf("error checking file exists: %v", err)
-	}
-
-	if exists {
-		i.Log.Debugf("File exists: %s", i.TokenFilePath())
-		token, err := i.TokenFromFile(i.TokenFilePath())
-		if err != nil {
-			return "", err
-		}
-		return token, nil
+		return "", fmt.Errorf("error reading token '%s': %v", i.TokenFilePath(), err)
 	}
 
-	return "", nil
+	return token, nil
 }
 
+// WriteTokenFile atomically replaces the token in the token store
 func (i *InstanceToken) WriteTokenFile(filePath, token string) error {
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		if _, err := os.Create(filePath); err != nil {
-			return fmt.Errorf("failed to create token file: %v", err)
-		}
-	}
-
-	f, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY, 0600)
-	if err != nil {
-		return fmt.Errorf("failed to open file '%s': %v", filePath, err)
-	}
-
-	defer f.Close()
-
-	if _, err = f.WriteString(token); err != nil {
-		return fmt.Errorf("failed to write to file '%s': %v", filePath, err)
-	}
-
-	return nil
+	return i.storeFor(filePath).Write(filePath, token)
 }
 
+// WipeTokenFile atomically replaces the token in the token store with an
+// empty token
 func (i *InstanceToken) WipeTokenFile(filePath string) error {
-	if err := deleteFile(filePath); err != nil {
-		return fmt.Errorf("error deleting token file '%s' to be wiped: %v", filePath, err)
-	}
+	return i.storeFor(filePath).Wipe(filePath)
+}
 
-	if err := createFile(filePath); err != nil {
-		return fmt.Errorf("error creating token file '%s' that was wiped: %v", filePath, err)
+// lock takes the lock on the config directory, so that concurrent runs
+// don't race on the token files
+func (i *InstanceToken) lock() (unlock func(), err error) {
+	if i.VaultConfigPath() != "" {
+		if err := os.MkdirAll(i.VaultConfigPath(), 0750); err != nil {
+			return nil, fmt.Errorf("error creating config directory '%s': %v", i.VaultConfigPath(), err)
+		}
 	}
 
-	return nil
+	return file.Lock(i.LockFilePath())
 }
 
-func deleteFile(path string) error {
-	err := os.Remove(path)
+func (i *InstanceToken) initTokenNew() error {
+	initToken, source, err := i.readInitToken()
 	if err != nil {
 		return err
 	}
-	return nil
-}
-
-func createFile(path string) error {
-	// detect if file exists
-	var _, err = os.Stat(path)
-
-	// create file if not exists
-	if os.IsNotExist(err) {
-		file, err := os.Create(path)
-
-		if err != nil {
-			return err
+	if initToken == "" {
+		if _, ok := i.tokenStore.(*KeyringTokenStore); ok {
+			return fmt.Errorf("init token was not read from file '%s' exiting. Tokens in the keyring are gone after a reboot, AppRole credentials or a new init token are needed to create a token", i.InitTokenFilePath())
 		}
-		defer file.Close()
-	}
-
-	//Set permissions
-	if err := os.Chmod(path, os.FileMode(0600)); err != nil {
-		return fmt.Errorf("error changing permissons of file '%s' to 0600: %v", path, err)
+		return fmt.Errorf("init token was not read from file '%s' exiting", i.InitTokenFilePath())
 	}
 
-	return nil
-}
+	i.Log.Debugf("init token found '%s' from %s", initToken, source)
 
-func (i *InstanceToken) initTokenNew() error {
-	exists, err := i.fileExists(i.InitTokenFilePath())
-	if err != nil {
-		return fmt.Errorf("error checking file exists: %v", err)
-	}
-	if !exists {
-		return fmt.Errorf("no init token file: '%s' exiting.", i.InitTokenFilePath())
-	}
-	i.Log.Debugf("File exists: %s", i.InitTokenFilePath())
-	initToken, err := i.TokenFromFile(i.InitTokenFilePath())
+	initToken, wrapped, err := i.unwrapInitToken(initToken)
 	if err != nil {
-		return fmt.Errorf("error reading init token from file: %v", err)
+		return err
 	}
-	if initToken == "" {
-		return fmt.Errorf("init token was not read from file '%s' exiting", i.InitTokenFilePath())
+	if wrapped {
+		// the wrapping token is consumed, so keep the init token until a
+		// new token has been created
+		if err := i.WriteTokenFile(i.InitTokenFilePath(), initToken); err != nil {
+			return fmt.Errorf("failed to write unwrapped init token to file: %v", err)
+		}
 	}
 
-	i.Log.Debugf("init token found '%s' at '%s'", initToken, i.InitTokenFilePath())
 	i.vaultClient.SetToken(initToken)
 
 	policies, err := i.TokenPolicies()
 	if err != nil {
+		// the init token file of a wrapped init token holds either the
+		// wrapping token or the init token unwrapped by a previous run
+		if i.InitTokenWrapped() && !wrapped && source == SourceInitToken && isPermissionDenied(err) {
+			i.Log.Error(ErrWrappingTokenUsed)
+			return fmt.Errorf("failed to find init token policies: %v: %v", ErrWrappingTokenUsed, err)
+		}
 		return fmt.Errorf("failed to find init token policies: %v", err)
 	}
 
@@ -149,6 +118,12 @@ func (i *InstanceToken) initTokenNew() error {
 
 	i.Log.Infof("New token: %s", i.Token())
 
+	if i.PreserveInitToken() && source != SourceAppRole {
+		if err := i.WriteTokenFile(i.PreservedInitTokenFilePath(), initToken); err != nil {
+			return fmt.Errorf("failed to preserve init token: %v", err)
+		}
+	}
+
 	return nil
 }
 
@@ -186,20 +161,38 @@ func (i *InstanceToken) TokenPolicies() (policies []string, err error) {
 }
 
 func (i *InstanceToken) createToken(policies []string) (token string, err error) {
-	tCreateRequest := &vault.TokenCreateRequest{
-		DisplayName: i.InitRole(),
+	data := map[string]interface{}{
+		"display_name": i.InitRole(),
 	}
 
-	newToken, err := i.vaultClient.Auth().Token().CreateWithRole(tCreateRequest, i.InitRole())
+	if i.NodeName() != "" {
+		data["meta"] = map[string]string{
+			"node_name": i.NodeName(),
+		}
+
+		if i.EntityAlias() {
+			if err := vaultVersion.Require(i.vaultClient.Sys(), vaultVersion.MinEntityAliases, "--"+FlagNodeEntityAlias); err != nil {
+				return "", err
+			}
+			data["entity_alias"] = i.NodeName()
+		}
+	} else if i.EntityAlias() {
+		return "", errors.New("node name is required to create token with entity alias")
+	}
+
+	newToken, err := i.vaultClient.Logical().Write(filepath.Join("auth/token/create", i.InitRole()), data)
 	if err != nil {
 		return "", fmt.Errorf("failed to create init token: %v", err)
 	}
 
+	if newToken == nil || newToken.Auth == nil {
+		return "", errors.New("no token returned from token creation")
+	}
+
 	return newToken.Auth.ClientToken, nil
 }
 
 func (i *InstanceToken) TokenLookup() (secret *vault.Secret, err error) {
-
 	s, err := i.vaultClient.Auth().Token().LookupSelf()
 	if err != nil {
 		return nil, fmt.Errorf("error lookup self token: %v", err)
@@ -232,8 +225,7 @@ func (i *InstanceToken) tokenRenew() error {
 	i.Log.Debugf("Token renewable")
 
 	// Renew against vault
-	s, err = i.vaultClient.Auth().Token().RenewSelf(0)
-	if err != nil {
+	if _, err := i.vaultClient.Auth().Token().RenewSelf(0); err != nil {
 		return fmt.Errorf("error renewing token %s: %v", i.InitRole(), err)
 	}
 
@@ -252,37 +244,98 @@ func (i *InstanceToken) EnsureToken() (newCreated bool, err error) {
 		logrus.Debugf("Token to renew: %s", token)
 		i.SetToken(token)
 		i.vaultClient.SetToken(i.Token())
-		return false, nil
+		if i.AuthMethod() != AuthMethodToken {
+			return false, nil
+		}
+		return i.recoverInitToken()
 	}
 
 	//Token Doesn't exist
 	i.Log.Info("Token doesn't exist, generating new")
-	err = i.initTokenNew()
+	if err := i.bootstrapToken(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// recoverInitToken handles a previous run having died between writing the
+// token file and wiping the init token file. If the token is valid the init
+// token is wiped, otherwise a new token is created from the init token.
+func (i *InstanceToken) recoverInitToken() (newCreated bool, err error) {
+	exists, err := i.fileExists(i.InitTokenFilePath())
 	if err != nil {
-		return false, fmt.Errorf("failed to generate new token: %v", err)
+		return false, fmt.Errorf("error checking file exists: %v", err)
+	}
+	if !exists {
+		return false, nil
 	}
 
-	if err := i.WriteTokenFile(i.TokenFilePath(), i.Token()); err != nil {
-		return false, fmt.Errorf("failed to write token to file: %v", err)
+	initToken, err := i.TokenFromFile(i.InitTokenFilePath())
+	if err != nil {
+		return false, fmt.Errorf("error reading init token from file: %v", err)
 	}
+	if initToken == "" {
+		return false, nil
+	}
+
+	if _, err := i.TokenLookup(); err != nil {
+		i.Log.Warnf("Token and init token found, token is not valid: %v", err)
+		if err := i.bootstrapToken(); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	i.Log.Warnf("Token and init token found, token is valid. Wiping init token file: %s", i.InitTokenFilePath())
 	if err := i.WipeTokenFile(i.InitTokenFilePath()); err != nil {
 		return false, fmt.Errorf("failed to wipe token from file: %v", err)
 	}
 
+	return false, nil
+}
+
+// bootstrapToken creates a new token using the init token, replacing the
+// token file and wiping the init token file. With any other auth method the
+// new token is the login token.
+func (i *InstanceToken) bootstrapToken() error {
+	if i.AuthMethod() != AuthMethodToken {
+		if err := i.loginNew(); err != nil {
+			return fmt.Errorf("failed to generate new token: %v", err)
+		}
+	} else if err := i.initTokenNew(); err != nil {
+		return fmt.Errorf("failed to generate new token: %v", err)
+	}
+
+	if err := i.WriteTokenFile(i.TokenFilePath(), i.Token()); err != nil {
+		return fmt.Errorf("failed to write token to file: %v", err)
+	}
+	if i.AuthMethod() == AuthMethodToken {
+		if err := i.WipeTokenFile(i.InitTokenFilePath()); err != nil {
+			return fmt.Errorf("failed to wipe token from file: %v", err)
+		}
+	}
+
 	i.Log.Infof("Token written to file: %s", i.TokenFilePath())
 	i.vaultClient.SetToken(i.Token())
 
-	return true, nil
+	return nil
 }
 
 func (i *InstanceToken) TokenRenewRun() error {
+	unlock, err := i.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	newCreated, err := i.EnsureToken()
 	if err != nil {
 		return err
 	}
 
 	if !newCreated {
-		if err := i.tokenRenew(); err != nil {
+		if err := i.renewOrRebootstrap(); err != nil {
 			return err
 		}
 	}
@@ -2,11 +2,15 @@
 package instanceToken_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 
 	vault "github.com/hashicorp/vault/api"
@@ -280,3 +284,117 @@ func initVaultDev() (*vault_dev.VaultDev, error) {
 
 	return vaultDev, nil
 }
+
+// Token and init token exist after a crash, token valid - wipe init token
+func TestRenew_Token_Recover_Valid(t *testing.T) {
+	initKubernetes(t, vaultDev)
+	i := initInstanceToken(t, vaultDev)
+
+	if err := i.WriteTokenFile(i.TokenFilePath(), vault_dev.RootTokenDev); err != nil {
+		t.Fatalf("error setting token for test: %v", err)
+	}
+	if err := i.WriteTokenFile(i.InitTokenFilePath(), vault_dev.RootTokenDev); err != nil {
+		t.Fatalf("error setting init token for test: %v", err)
+	}
+
+	if err := i.TokenRenewRun(); err != nil {
+		t.Fatalf("error renewing token: %v", err)
+	}
+
+	if exp, act := vault_dev.RootTokenDev, i.Token(); exp != act {
+		t.Errorf("unexpected token exp=%s got=%s", exp, act)
+	}
+
+	tokenCheckFiles(t, i)
+}
+
+// Token and init token exist after a crash, token invalid - generate new token
+func TestRenew_Token_Recover_Invalid(t *testing.T) {
+	initKubernetes(t, vaultDev)
+	i := initInstanceToken(t, vaultDev)
+
+	if err := i.WriteTokenFile(i.TokenFilePath(), "invalid-token"); err != nil {
+		t.Fatalf("error setting token for test: %v", err)
+	}
+	if err := i.WriteTokenFile(i.InitTokenFilePath(), vault_dev.RootTokenDev); err != nil {
+		t.Fatalf("error setting init token for test: %v", err)
+	}
+
+	if err := i.TokenRenewRun(); err != nil {
+		t.Fatalf("error renewing token: %v", err)
+	}
+
+	if i.Token() == "invalid-token" {
+		t.Error("expected new token to be generated")
+	}
+
+	tokenCheckFiles(t, i)
+}
+
+// fakeCreateVault reports the given version and creates tokens from any init
+// token, recording the requests of token creation
+func fakeCreateVault(t *testing.T, version string, creates *[]map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/sys/health":
+			json.NewEncoder(w).Encode(map[string]interface{}{"initialized": true, "version": version})
+		case "/v1/sys/wrapping/lookup":
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"wrapping token is not valid or does not exist"}})
+		case "/v1/auth/token/lookup-self":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{"policies": []string{"default", "worker"}},
+			})
+		case "/v1/auth/token/create/test-cluster-worker-node1":
+			data := make(map[string]interface{})
+			json.NewDecoder(r.Body).Decode(&data)
+			*creates = append(*creates, data)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"auth": map[string]interface{}{"client_token": "node1-token"},
+			})
+		default:
+			t.Errorf("unexpected request path %s", r.URL.Path)
+		}
+	}))
+}
+
+// Vault ignores the entity alias of tokens before 1.6.0
+func TestRenew_Token_EntityAliasVersion(t *testing.T) {
+	for _, c := range []struct {
+		version string
+		creates int
+	}{
+		{"0.9.6", 0},
+		{"1.6.0", 1},
+	} {
+		t.Run(c.version, func(t *testing.T) {
+			var creates []map[string]interface{}
+			server := fakeCreateVault(t, c.version, &creates)
+			defer server.Close()
+
+			i := newLoginInstanceToken(t, server.URL)
+			i.SetInitRole("test-cluster-worker-node1")
+			i.SetNodeName("node1")
+			i.SetEntityAlias(true)
+			if err := i.WriteTokenFile(i.InitTokenFilePath(), "node1-init-token"); err != nil {
+				t.Fatalf("error setting token for test: %v", err)
+			}
+
+			err := i.TokenRenewRun()
+			if c.creates == 0 {
+				if err == nil || !strings.Contains(err.Error(), "requires vault 1.6.0 or later") {
+					t.Errorf("expected vault version error, got: %v", err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if exp, act := c.creates, len(creates); exp != act {
+				t.Fatalf("unexpected number of created tokens exp=%d got=%d", exp, act)
+			}
+			if c.creates > 0 && creates[0]["entity_alias"] != "node1" {
+				t.Errorf("expected token to be created with entity alias node1, got: %v", creates[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package instanceToken
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+const (
+	FlagStatusOutput    = "output"
+	FlagStatusThreshold = "expiring-threshold"
+	FlagStatusTimeout   = "timeout"
+)
+
+// health of a token, in order of the exit codes of token status
+const (
+	StatusHealthy     = "healthy"
+	StatusExpiring    = "expiring"
+	StatusInvalid     = "invalid"
+	StatusUnreachable = "unreachable"
+)
+
+var statusExitCodes = map[string]int{
+	StatusHealthy:     0,
+	StatusExpiring:    1,
+	StatusInvalid:     2,
+	StatusUnreachable: 3,
+}
+
+type TokenStatus struct {
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	TTL        int64      `json:"ttl"`
+	ExpireTime *time.Time `json:"expire_time,omitempty"`
+	Renewable  bool       `json:"renewable"`
+	Policies   []string   `json:"policies,omitempty"`
+	Accessor   string     `json:"accessor,omitempty"`
+	InitRole   string     `json:"init_role"`
+}
+
+// ExitCode returns the exit code of token status for the status
+func (s *TokenStatus) ExitCode() int {
+	code, ok := statusExitCodes[s.Status]
+	if !ok {
+		return statusExitCodes[StatusUnreachable]
+	}
+	return code
+}
+
+func (s *TokenStatus) WriteText(w io.Writer) error {
+	lines := []string{
+		fmt.Sprintf("status:      %s", s.Status),
+	}
+	if s.Error != "" {
+		lines = append(lines, fmt.Sprintf("error:       %s", s.Error))
+	}
+	if s.Status == StatusHealthy || s.Status == StatusExpiring {
+		expireTime := "never"
+		if s.ExpireTime != nil {
+			expireTime = s.ExpireTime.Format(time.RFC3339)
+		}
+		lines = append(lines,
+			fmt.Sprintf("ttl:         %s", time.Duration(s.TTL)*time.Second),
+			fmt.Sprintf("expire_time: %s", expireTime),
+			fmt.Sprintf("renewable:   %t", s.Renewable),
+			fmt.Sprintf("policies:    %s", strings.Join(s.Policies, ", ")),
+			fmt.Sprintf("accessor:    %s", s.Accessor),
+		)
+	}
+	lines = append(lines, fmt.Sprintf("init_role:   %s", s.InitRole))
+
+	_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return err
+}
+
+func (s *TokenStatus) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// TokenStatus looks up the stored token. The token is expiring if its TTL is
+// below threshold, invalid if there is none or vault rejects it and
+// unreachable on any other error.
+func (i *InstanceToken) TokenStatus(threshold time.Duration) *TokenStatus {
+	status := &TokenStatus{
+		InitRole: i.InitRole(),
+	}
+	fail := func(s string, err error) *TokenStatus {
+		status.Status = s
+		status.Error = err.Error()
+		return status
+	}
+
+	token, err := i.TokenRetrieve()
+	if err != nil {
+		return fail(StatusInvalid, err)
+	}
+	if token == "" {
+		return fail(StatusInvalid, fmt.Errorf("no token stored at '%s'", i.TokenFilePath()))
+	}
+	i.SetToken(token)
+	i.vaultClient.SetToken(token)
+
+	s, err := i.TokenLookup()
+	if err != nil {
+		if isPermissionDenied(err) {
+			return fail(StatusInvalid, err)
+		}
+		return fail(StatusUnreachable, err)
+	}
+
+	if err := status.fromSecret(s); err != nil {
+		return fail(StatusUnreachable, err)
+	}
+
+	status.Status = StatusHealthy
+	if status.TTL > 0 && time.Duration(status.TTL)*time.Second < threshold {
+		status.Status = StatusExpiring
+	}
+
+	return status
+}
+
+func (s *TokenStatus) fromSecret(secret *vault.Secret) error {
+	ttl, err := secret.TokenTTL()
+	if err != nil {
+		return fmt.Errorf("error reading token TTL: %v", err)
+	}
+	s.TTL = int64(ttl / time.Second)
+
+	if s.Renewable, err = secret.TokenIsRenewable(); err != nil {
+		return fmt.Errorf("error reading token renewable: %v", err)
+	}
+	if s.Policies, err = secret.TokenPolicies(); err != nil {
+		return fmt.Errorf("error reading token policies: %v", err)
+	}
+	if s.Accessor, err = secret.TokenAccessor(); err != nil {
+		return fmt.Errorf("error reading token accessor: %v", err)
+	}
+
+	if dat, ok := secret.Data["expire_time"].(string); ok && dat != "" {
+		expireTime, err := time.Parse(time.RFC3339Nano, dat)
+		if err != nil {
+			return fmt.Errorf("error parsing token expire time '%s': %v", dat, err)
+		}
+		s.ExpireTime = &expireTime
+	} else if ttl > 0 {
+		expireTime := time.Now().Add(ttl).UTC()
+		s.ExpireTime = &expireTime
+	}
+
+	return nil
+}
+
+// Write outputs the status in the given format, text or json
+func (s *TokenStatus) Write(w io.Writer, format string) error {
+	switch format {
+	case "text":
+		return s.WriteText(w)
+	case "json":
+		return s.WriteJSON(w)
+	}
+
+	return fmt.Errorf("unknown output format '%s', valid formats are: text, json", format)
+}
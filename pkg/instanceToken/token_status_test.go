@@ -0,0 +1,143 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package instanceToken_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jetstack/vault-helper/pkg/instanceToken"
+)
+
+func fakeLookupVault(t *testing.T, ttl int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if exp, act := "/v1/auth/token/lookup-self", r.URL.Path; exp != act {
+			t.Errorf("unexpected request path exp=%s got=%s", exp, act)
+		}
+
+		if r.Header.Get("X-Vault-Token") != "my-token" {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"permission denied"}})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"accessor":    "my-accessor",
+				"ttl":         ttl,
+				"expire_time": "2030-01-02T03:04:05.123456789Z",
+				"renewable":   true,
+				"policies":    []string{"default", "worker"},
+			},
+		})
+	}))
+}
+
+func TestTokenStatus(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		token    string
+		ttl      int
+		status   string
+		exitCode int
+	}{
+		{"healthy", "my-token", 7200, instanceToken.StatusHealthy, 0},
+		{"expiring", "my-token", 60, instanceToken.StatusExpiring, 1},
+		{"rejected", "revoked-token", 7200, instanceToken.StatusInvalid, 2},
+		{"missing", "", 7200, instanceToken.StatusInvalid, 2},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			server := fakeLookupVault(t, c.ttl)
+			defer server.Close()
+
+			i := newLoginInstanceToken(t, server.URL)
+			i.SetInitRole("cluster-worker")
+			if c.token != "" {
+				if err := i.WriteTokenFile(i.TokenFilePath(), c.token); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			status := i.TokenStatus(time.Hour)
+			if exp, act := c.status, status.Status; exp != act {
+				t.Errorf("unexpected status exp=%s got=%s (%s)", exp, act, status.Error)
+			}
+			if exp, act := c.exitCode, status.ExitCode(); exp != act {
+				t.Errorf("unexpected exit code exp=%d got=%d", exp, act)
+			}
+			if exp, act := "cluster-worker", status.InitRole; exp != act {
+				t.Errorf("unexpected init role exp=%s got=%s", exp, act)
+			}
+		})
+	}
+}
+
+func TestTokenStatus_Output(t *testing.T) {
+	server := fakeLookupVault(t, 7200)
+	defer server.Close()
+
+	i := newLoginInstanceToken(t, server.URL)
+	if err := i.WriteTokenFile(i.TokenFilePath(), "my-token"); err != nil {
+		t.Fatal(err)
+	}
+
+	status := i.TokenStatus(time.Hour)
+	if exp, act := int64(7200), status.TTL; exp != act {
+		t.Errorf("unexpected ttl exp=%d got=%d", exp, act)
+	}
+	if exp, act := "my-accessor", status.Accessor; exp != act {
+		t.Errorf("unexpected accessor exp=%s got=%s", exp, act)
+	}
+	if !status.Renewable {
+		t.Error("expected token to be renewable")
+	}
+	if exp, act := 2, len(status.Policies); exp != act {
+		t.Errorf("unexpected number of policies exp=%d got=%d", exp, act)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := status.Write(buf, "json"); err != nil {
+		t.Fatalf("error writing json: %v", err)
+	}
+	out := make(map[string]interface{})
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("error decoding json output: %v", err)
+	}
+	if exp, act := "2030-01-02T03:04:05.123456789Z", out["expire_time"]; exp != act {
+		t.Errorf("unexpected expire time exp=%s got=%v", exp, act)
+	}
+
+	buf.Reset()
+	if err := status.Write(buf, "text"); err != nil {
+		t.Fatalf("error writing text: %v", err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("ttl:         2h0m0s")) {
+		t.Errorf("unexpected text output: %s", buf.String())
+	}
+
+	if err := status.Write(buf, "yaml"); err == nil {
+		t.Error("expected error for unknown output format")
+	}
+}
+
+// Vault is down - unreachable
+func TestTokenStatus_Unreachable(t *testing.T) {
+	server := fakeLookupVault(t, 7200)
+	server.Close()
+
+	i := newLoginInstanceToken(t, server.URL)
+	if err := i.WriteTokenFile(i.TokenFilePath(), "my-token"); err != nil {
+		t.Fatal(err)
+	}
+
+	status := i.TokenStatus(time.Hour)
+	if exp, act := instanceToken.StatusUnreachable, status.Status; exp != act {
+		t.Errorf("unexpected status exp=%s got=%s", exp, act)
+	}
+	if exp, act := 3, status.ExitCode(); exp != act {
+		t.Errorf("unexpected exit code exp=%d got=%d", exp, act)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package instanceToken
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/jetstack/vault-helper/pkg/file"
+)
+
+const FlagTokenStore = "token-store"
+
+const (
+	TokenStoreFile    = "file"
+	TokenStoreKeyring = "keyring"
+	TokenStoreTmpfs   = "tmpfs"
+)
+
+// TokenStore stores tokens, identified by the path of their token file
+type TokenStore interface {
+	// Read returns the stored token, or an empty string if there is none
+	Read(path string) (token string, err error)
+	Write(path, token string) error
+	Wipe(path string) error
+}
+
+var _ TokenStore = &FileTokenStore{}
+var _ TokenStore = &KeyringTokenStore{}
+var _ TokenStore = &TmpfsTokenStore{}
+
+// NewTokenStore returns the token store of the given type
+func NewTokenStore(storeType string) (TokenStore, error) {
+	switch storeType {
+	case TokenStoreFile:
+		return &FileTokenStore{}, nil
+	case TokenStoreKeyring:
+		return &KeyringTokenStore{}, nil
+	case TokenStoreTmpfs:
+		return NewTmpfsTokenStore(), nil
+	}
+
+	return nil, fmt.Errorf("unknown token store '%s', valid stores are: %s", storeType, strings.Join([]string{TokenStoreFile, TokenStoreKeyring, TokenStoreTmpfs}, ", "))
+}
+
+// FileTokenStore stores tokens in plain files with permissions 0600
+type FileTokenStore struct{}
+
+func (f *FileTokenStore) Read(path string) (string, error) {
+	dat, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(dat)), nil
+}
+
+func (f *FileTokenStore) Write(path, token string) error {
+	if err := file.WriteAtomic(path, []byte(token), 0600); err != nil {
+		return fmt.Errorf("failed to write token file: %v", err)
+	}
+
+	return nil
+}
+
+func (f *FileTokenStore) Wipe(path string) error {
+	if err := file.WriteAtomic(path, []byte{}, 0600); err != nil {
+		return fmt.Errorf("error wiping token file '%s': %v", path, err)
+	}
+
+	return nil
+}
+
+// storeFor returns the store of the token at path. Init tokens are delivered
+// to nodes as files, so they are always read from and wiped in files.
+func (i *InstanceToken) storeFor(path string) TokenStore {
+	if path == i.InitTokenFilePath() || i.tokenStore == nil {
+		return &FileTokenStore{}
+	}
+
+	return i.tokenStore
+}
+
+func (i *InstanceToken) SetTokenStore(store TokenStore) {
+	i.tokenStore = store
+}
+
+func (i *InstanceToken) TokenStore() (store TokenStore) {
+	return i.storeFor(i.TokenFilePath())
+}
@@ -0,0 +1,68 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package instanceToken
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// KeyringTokenStore stores tokens in the user keyring of the Linux kernel, so
+// they are never written to disk and are gone after a reboot. The keyring is
+// shared by all processes of the user. As the init token file is wiped after
+// bootstrapping, a node can only get a new token after a reboot from AppRole
+// credentials or a new init token.
+type KeyringTokenStore struct{}
+
+const keyringKeyType = "user"
+
+func keyringDescription(path string) string {
+	return "vault-helper:" + path
+}
+
+func (k *KeyringTokenStore) Read(path string) (string, error) {
+	id, err := unix.KeyctlSearch(unix.KEY_SPEC_USER_KEYRING, keyringKeyType, keyringDescription(path), 0)
+	if err == unix.ENOKEY {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error searching keyring for '%s': %v", keyringDescription(path), err)
+	}
+
+	var buf []byte
+	for {
+		size, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, buf, 0)
+		if err != nil {
+			return "", fmt.Errorf("error reading key '%s' from keyring: %v", keyringDescription(path), err)
+		}
+		if size <= len(buf) {
+			return string(buf[:size]), nil
+		}
+		buf = make([]byte, size)
+	}
+}
+
+func (k *KeyringTokenStore) Write(path, token string) error {
+	// adding a key with an existing description updates it
+	if _, err := unix.AddKey(keyringKeyType, keyringDescription(path), []byte(token), unix.KEY_SPEC_USER_KEYRING); err != nil {
+		return fmt.Errorf("error adding key '%s' to keyring: %v", keyringDescription(path), err)
+	}
+
+	return nil
+}
+
+func (k *KeyringTokenStore) Wipe(path string) error {
+	id, err := unix.KeyctlSearch(unix.KEY_SPEC_USER_KEYRING, keyringKeyType, keyringDescription(path), 0)
+	if err == unix.ENOKEY {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error searching keyring for '%s': %v", keyringDescription(path), err)
+	}
+
+	if _, err := unix.KeyctlInt(unix.KEYCTL_UNLINK, id, unix.KEY_SPEC_USER_KEYRING, 0, 0); err != nil {
+		return fmt.Errorf("error removing key '%s' from keyring: %v", keyringDescription(path), err)
+	}
+
+	return nil
+}
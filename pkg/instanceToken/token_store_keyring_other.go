@@ -0,0 +1,27 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+
+//go:build !linux
+// +build !linux
+
+package instanceToken
+
+import (
+	"errors"
+)
+
+// KeyringTokenStore is only supported on Linux
+type KeyringTokenStore struct{}
+
+var errKeyringUnsupported = errors.New("the keyring token store is only supported on linux")
+
+func (k *KeyringTokenStore) Read(path string) (string, error) {
+	return "", errKeyringUnsupported
+}
+
+func (k *KeyringTokenStore) Write(path, token string) error {
+	return errKeyringUnsupported
+}
+
+func (k *KeyringTokenStore) Wipe(path string) error {
+	return errKeyringUnsupported
+}
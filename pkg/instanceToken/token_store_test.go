@@ -0,0 +1,184 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package instanceToken_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jetstack/vault-helper/pkg/instanceToken"
+)
+
+func tmpDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "vault-helper-token-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tempDirs = append(tempDirs, dir)
+
+	return dir
+}
+
+func testTokenStore(t *testing.T, store instanceToken.TokenStore, path string) {
+	if token, err := store.Read(path); err != nil || token != "" {
+		t.Fatalf("expected no token before write, got token='%s' err=%v", token, err)
+	}
+
+	if err := store.Write(path, "my-token"); err != nil {
+		t.Fatalf("error writing token: %v", err)
+	}
+	token, err := store.Read(path)
+	if err != nil {
+		t.Fatalf("error reading token: %v", err)
+	}
+	if exp, act := "my-token", token; exp != act {
+		t.Errorf("unexpected token exp=%s got=%s", exp, act)
+	}
+
+	if err := store.Wipe(path); err != nil {
+		t.Fatalf("error wiping token: %v", err)
+	}
+	if token, err := store.Read(path); err != nil || token != "" {
+		t.Errorf("expected no token after wipe, got token='%s' err=%v", token, err)
+	}
+}
+
+func TestTokenStore_File(t *testing.T) {
+	dir := tmpDir(t)
+	testTokenStore(t, &instanceToken.FileTokenStore{}, filepath.Join(dir, "token"))
+}
+
+func newTestTmpfsTokenStore(t *testing.T) (store *instanceToken.TmpfsTokenStore, dir string) {
+	dir = tmpDir(t)
+
+	store = instanceToken.NewTmpfsTokenStore()
+	store.SetDir(filepath.Join(dir, "tmpfs"))
+	store.SetSealKeyPath(filepath.Join(dir, "seal", "seal-key"))
+
+	return store, dir
+}
+
+func TestTokenStore_Tmpfs(t *testing.T) {
+	store, dir := newTestTmpfsTokenStore(t)
+	path := filepath.Join(dir, "token")
+
+	testTokenStore(t, store, path)
+
+	if err := store.Write(path, "my-token"); err != nil {
+		t.Fatalf("error writing token: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected no plain token file at '%s'", path)
+	}
+	sealed, err := ioutil.ReadFile(store.SealedPath(path))
+	if err != nil {
+		t.Fatalf("error reading sealed token: %v", err)
+	}
+	if strings.Contains(string(sealed), "my-token") {
+		t.Error("sealed token contains the token in the clear")
+	}
+
+	info, err := os.Stat(store.SealKeyPath())
+	if err != nil {
+		t.Fatalf("expected seal key to be created: %v", err)
+	}
+	if exp, act := os.FileMode(0600), info.Mode().Perm(); exp != act {
+		t.Errorf("unexpected seal key mode exp=%#o got=%#o", exp, act)
+	}
+
+	// there is no default seal key next to the sealed copies
+	store.SetSealKeyPath("")
+	if err := store.Write(path, "my-token"); err == nil {
+		t.Error("expected error sealing token without seal key")
+	}
+}
+
+// The tmpfs was emptied on reboot - restore the token from the sealed copy
+func TestTokenStore_Tmpfs_Unseal(t *testing.T) {
+	store, dir := newTestTmpfsTokenStore(t)
+	path := filepath.Join(dir, "token")
+
+	if err := store.Write(path, "my-token"); err != nil {
+		t.Fatalf("error writing token: %v", err)
+	}
+	if err := os.RemoveAll(store.Dir()); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := store.Read(path)
+	if err != nil {
+		t.Fatalf("error reading token: %v", err)
+	}
+	if exp, act := "my-token", token; exp != act {
+		t.Errorf("unexpected token exp=%s got=%s", exp, act)
+	}
+	if _, err := os.Stat(store.TmpfsPath(path)); err != nil {
+		t.Errorf("expected token to be restored to tmpfs: %v", err)
+	}
+
+	// sealed tokens can't be read with a key readable by others
+	if err := os.RemoveAll(store.Dir()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(store.SealKeyPath(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Read(path); err == nil {
+		t.Error("expected error unsealing token with a seal key readable by others")
+	}
+
+	// sealed tokens can't be read with another key
+	if err := ioutil.WriteFile(store.SealKeyPath(), []byte("0123456789abcdef0123456789abcdef"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(store.SealKeyPath(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Read(path); err == nil {
+		t.Error("expected error unsealing token with a different key")
+	}
+
+	// the key isn't created to unseal tokens
+	if err := os.Remove(store.SealKeyPath()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Read(path); err == nil {
+		t.Error("expected error unsealing token without seal key")
+	}
+}
+
+// Token in the store, init token always in a file
+func TestTokenStore_InstanceToken(t *testing.T) {
+	store, dir := newTestTmpfsTokenStore(t)
+
+	i := instanceToken.New(nil, nil)
+	i.SetVaultConfigPath(dir)
+	i.SetTokenStore(store)
+
+	if err := i.WriteTokenFile(i.TokenFilePath(), "my-token"); err != nil {
+		t.Fatalf("error writing token: %v", err)
+	}
+	if _, err := os.Stat(i.TokenFilePath()); !os.IsNotExist(err) {
+		t.Errorf("expected token not to be written to '%s'", i.TokenFilePath())
+	}
+	token, err := i.TokenRetrieve()
+	if err != nil {
+		t.Fatalf("error retrieving token: %v", err)
+	}
+	if exp, act := "my-token", token; exp != act {
+		t.Errorf("unexpected token exp=%s got=%s", exp, act)
+	}
+
+	if err := i.WriteTokenFile(i.InitTokenFilePath(), "my-init-token"); err != nil {
+		t.Fatalf("error writing init token: %v", err)
+	}
+	initToken, err := i.TokenFromFile(i.InitTokenFilePath())
+	if err != nil {
+		t.Fatalf("error reading init token file: %v", err)
+	}
+	if exp, act := "my-init-token", initToken; exp != act {
+		t.Errorf("unexpected init token exp=%s got=%s", exp, act)
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package instanceToken
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jetstack/vault-helper/pkg/file"
+)
+
+const (
+	FlagTokenStoreTmpfsDir = "token-store-tmpfs-dir"
+	FlagTokenStoreSealKey  = "token-store-seal-key"
+)
+
+const DefaultTmpfsDir = "/run/vault-helper"
+
+const sealKeySize = 32
+
+// TmpfsTokenStore stores tokens on a tmpfs, so they are never written to
+// disk in the clear. As the tmpfs is emptied on reboot, a copy of each token
+// is sealed and written next to the token file, from which the token is
+// restored after a reboot. Tokens are sealed with a random key, created on
+// first use in a file only readable by its owner. There is no default key
+// file: a key on the same disk as the sealed copies doesn't protect them, so
+// it has to be kept on separate or protected storage.
+type TmpfsTokenStore struct {
+	dir         string
+	sealKeyPath string
+}
+
+func NewTmpfsTokenStore() *TmpfsTokenStore {
+	return &TmpfsTokenStore{
+		dir: DefaultTmpfsDir,
+	}
+}
+
+func (t *TmpfsTokenStore) SetDir(dir string) {
+	t.dir = dir
+}
+
+func (t *TmpfsTokenStore) Dir() (dir string) {
+	return t.dir
+}
+
+// SetSealKeyPath sets the file of the key sealing tokens
+func (t *TmpfsTokenStore) SetSealKeyPath(path string) {
+	t.sealKeyPath = path
+}
+
+func (t *TmpfsTokenStore) SealKeyPath() (path string) {
+	return t.sealKeyPath
+}
+
+// TmpfsPath returns the path on the tmpfs of the token at path
+func (t *TmpfsTokenStore) TmpfsPath(path string) string {
+	name := strings.Replace(strings.TrimPrefix(filepath.Clean(path), "/"), "/", "_", -1)
+	return filepath.Join(t.dir, name)
+}
+
+// SealedPath returns the path of the sealed copy of the token at path
+func (t *TmpfsTokenStore) SealedPath(path string) string {
+	return path + ".sealed"
+}
+
+func (t *TmpfsTokenStore) Read(path string) (string, error) {
+	token, err := (&FileTokenStore{}).Read(t.TmpfsPath(path))
+	if err != nil {
+		return "", err
+	}
+	if token != "" {
+		return token, nil
+	}
+
+	sealed, err := ioutil.ReadFile(t.SealedPath(path))
+	if os.IsNotExist(err) || (err == nil && len(sealed) == 0) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	token, err = t.unseal(path, sealed)
+	if err != nil {
+		return "", fmt.Errorf("error unsealing token '%s': %v", t.SealedPath(path), err)
+	}
+
+	if err := t.writeTmpfs(path, token); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (t *TmpfsTokenStore) Write(path, token string) error {
+	if err := t.writeTmpfs(path, token); err != nil {
+		return err
+	}
+
+	sealed, err := t.seal(path, token)
+	if err != nil {
+		return fmt.Errorf("error sealing token: %v", err)
+	}
+	if err := file.WriteAtomic(t.SealedPath(path), sealed, 0600); err != nil {
+		return fmt.Errorf("failed to write sealed token file: %v", err)
+	}
+
+	return nil
+}
+
+func (t *TmpfsTokenStore) Wipe(path string) error {
+	if err := t.writeTmpfs(path, ""); err != nil {
+		return err
+	}
+
+	if err := os.Remove(t.SealedPath(path)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing sealed token file '%s': %v", t.SealedPath(path), err)
+	}
+
+	return nil
+}
+
+func (t *TmpfsTokenStore) writeTmpfs(path, token string) error {
+	if err := os.MkdirAll(t.dir, 0700); err != nil {
+		return fmt.Errorf("error creating tmpfs directory '%s': %v", t.dir, err)
+	}
+
+	if err := file.WriteAtomic(t.TmpfsPath(path), []byte(token), 0600); err != nil {
+		return fmt.Errorf("failed to write token to tmpfs: %v", err)
+	}
+
+	return nil
+}
+
+// aead returns the cipher for sealing the token at path. The path is part of
+// the key so that sealed tokens can't be swapped between files.
+func (t *TmpfsTokenStore) aead(path string, create bool) (cipher.AEAD, error) {
+	sealKey, err := t.sealKey(create)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, sealKey)
+	mac.Write([]byte(filepath.Clean(path)))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// sealKey reads the seal key, which must only be accessible by its owner. A
+// new key is created if there is none and create is set.
+func (t *TmpfsTokenStore) sealKey(create bool) ([]byte, error) {
+	if t.sealKeyPath == "" {
+		return nil, fmt.Errorf("no seal key file set, set %s to a file on separate or protected storage", FlagTokenStoreSealKey)
+	}
+
+	if create {
+		if err := t.createSealKey(); err != nil {
+			return nil, err
+		}
+	}
+
+	info, err := os.Stat(t.sealKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading seal key: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return nil, fmt.Errorf("seal key file '%s' must only be accessible by its owner, has mode %#o", t.sealKeyPath, perm)
+	}
+
+	key, err := ioutil.ReadFile(t.sealKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading seal key: %v", err)
+	}
+	if len(key) != sealKeySize {
+		return nil, fmt.Errorf("seal key file '%s' must contain %d bytes, has %d", t.sealKeyPath, sealKeySize, len(key))
+	}
+
+	return key, nil
+}
+
+// createSealKey writes a random seal key, unless the key file exists
+func (t *TmpfsTokenStore) createSealKey() error {
+	if err := os.MkdirAll(filepath.Dir(t.sealKeyPath), 0700); err != nil {
+		return fmt.Errorf("error creating seal key directory: %v", err)
+	}
+
+	f, err := os.OpenFile(t.sealKeyPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error creating seal key: %v", err)
+	}
+	defer f.Close()
+
+	key := make([]byte, sealKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return err
+	}
+	if _, err := f.Write(key); err != nil {
+		os.Remove(t.sealKeyPath)
+		return fmt.Errorf("error writing seal key '%s': %v", t.sealKeyPath, err)
+	}
+
+	return f.Sync()
+}
+
+func (t *TmpfsTokenStore) seal(path, token string) ([]byte, error) {
+	aead, err := t.aead(path, true)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, []byte(token), nil), nil
+}
+
+func (t *TmpfsTokenStore) unseal(path string, sealed []byte) (string, error) {
+	aead, err := t.aead(path, false)
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("sealed token is too short")
+	}
+
+	token, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(token), nil
+}
@@ -0,0 +1,81 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package instanceToken
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const FlagInitTokenWrapped = "init-token-wrapped"
+
+// vault's error looking up a token which isn't a valid wrapping token
+const errInvalidWrappingToken = "wrapping token is not valid or does not exist"
+
+// ErrWrappingTokenUsed is returned if the init token was expected to be
+// response-wrapped, but is neither a valid wrapping token nor the init token
+// unwrapped by a previous run. A wrapping token can only be unwrapped once, so
+// this can mean that someone else has unwrapped the init token.
+var ErrWrappingTokenUsed = errors.New("init token is response-wrapped (--" + FlagInitTokenWrapped + "), but it is neither a valid wrapping token nor a valid token. It has expired or already been unwrapped, which may indicate tampering")
+
+// SetInitTokenWrapped sets whether the init token is delivered
+// response-wrapped, so that an already unwrapped init token is reported
+func (i *InstanceToken) SetInitTokenWrapped(wrapped bool) {
+	i.initTokenWrapped = wrapped
+}
+
+func (i *InstanceToken) InitTokenWrapped() (wrapped bool) {
+	return i.initTokenWrapped
+}
+
+// unwrapInitToken returns the init token wrapped by the given token. Plain
+// init tokens are returned as they are.
+func (i *InstanceToken) unwrapInitToken(token string) (initToken string, wrapped bool, err error) {
+	wrapped, err = i.isWrappingToken(token)
+	if err != nil {
+		return "", false, err
+	}
+	if !wrapped {
+		return token, false, nil
+	}
+
+	i.Log.Info("Init token is response-wrapped, unwrapping")
+	s, err := i.vaultClient.Logical().Unwrap(token)
+	if err != nil {
+		i.Log.Errorf("Failed to unwrap init token, it may have been unwrapped by someone else: %v", err)
+		return "", true, fmt.Errorf("failed to unwrap init token: %v", err)
+	}
+	if s == nil {
+		return "", true, errors.New("no data returned unwrapping init token")
+	}
+
+	initToken, ok := s.Data["init_token"].(string)
+	if !ok || initToken == "" {
+		return "", true, errors.New("unwrapped secret doesn't contain an init token")
+	}
+
+	return initToken, true, nil
+}
+
+// isWrappingToken looks up the token as wrapping token. The lookup doesn't
+// consume the wrapping token.
+func (i *InstanceToken) isWrappingToken(token string) (bool, error) {
+	_, err := i.vaultClient.Logical().Write("sys/wrapping/lookup", map[string]interface{}{
+		"token": token,
+	})
+	if err == nil {
+		return true, nil
+	}
+
+	// not a wrapping token, or one that has been unwrapped already
+	if strings.Contains(err.Error(), "Code: 400.") && strings.Contains(err.Error(), errInvalidWrappingToken) {
+		return false, nil
+	}
+
+	return false, fmt.Errorf("error looking up wrapping token: %v", err)
+}
+
+// isPermissionDenied returns true if vault rejected the token
+func isPermissionDenied(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "Code: 403.")
+}
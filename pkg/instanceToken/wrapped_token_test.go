@@ -0,0 +1,109 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package instanceToken_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jetstack/vault-helper/pkg/instanceToken"
+	"github.com/jetstack/vault-helper/pkg/testing/vault_dev"
+)
+
+// Wrapped init token is unwrapped once, a second unwrap is detected
+func TestRenew_Token_Wrapped(t *testing.T) {
+	k := initKubernetes(t, vaultDev)
+	defer vaultDev.Client().SetToken(vault_dev.RootTokenDev)
+
+	wrapped, err := k.WrapInitToken(vaultDev.Client(), "worker", time.Minute)
+	if err != nil {
+		t.Fatalf("error wrapping init token: %v", err)
+	}
+
+	i := initInstanceToken(t, vaultDev)
+	i.SetInitRole("test-cluster-worker")
+	if err := i.WriteTokenFile(i.InitTokenFilePath(), wrapped); err != nil {
+		t.Fatalf("error setting token for test: %v", err)
+	}
+
+	if err := i.TokenRenewRun(); err != nil {
+		t.Fatalf("error creating token from wrapped init token: %v", err)
+	}
+	tokenCheckFiles(t, i)
+
+	vaultDev.Client().SetToken(vault_dev.RootTokenDev)
+
+	i = initInstanceToken(t, vaultDev)
+	i.SetInitRole("test-cluster-worker")
+	i.SetInitTokenWrapped(true)
+	if err := i.WriteTokenFile(i.InitTokenFilePath(), wrapped); err != nil {
+		t.Fatalf("error setting token for test: %v", err)
+	}
+
+	err = i.TokenRenewRun()
+	if err == nil {
+		t.Fatal("expected error using wrapped init token twice")
+	}
+	if !strings.Contains(err.Error(), instanceToken.ErrWrappingTokenUsed.Error()) {
+		t.Errorf("expected tamper error, got: %v", err)
+	}
+}
+
+// fakeWrappingVault fails the wrapping lookup with the given message and
+// rejects every token
+func fakeWrappingVault(t *testing.T, lookupError string, lookups *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/sys/wrapping/lookup":
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{lookupError}})
+		case "/v1/auth/token/lookup-self":
+			*lookups++
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"permission denied"}})
+		default:
+			t.Errorf("unexpected request path %s", r.URL.Path)
+		}
+	}))
+}
+
+func TestRenew_Token_WrappedLookup(t *testing.T) {
+	for _, c := range []struct {
+		name        string
+		wrapped     bool
+		lookupError string
+		lookups     int
+		tamper      bool
+	}{
+		{"used wrapping token", true, "wrapping token is not valid or does not exist", 1, true},
+		{"revoked init token", false, "wrapping token is not valid or does not exist", 1, false},
+		{"lookup failure", true, "missing token", 0, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var lookups int
+			server := fakeWrappingVault(t, c.lookupError, &lookups)
+			defer server.Close()
+
+			i := newLoginInstanceToken(t, server.URL)
+			i.SetInitRole("test-cluster-worker")
+			i.SetInitTokenWrapped(c.wrapped)
+			if err := i.WriteTokenFile(i.InitTokenFilePath(), "my-init-token"); err != nil {
+				t.Fatalf("error setting token for test: %v", err)
+			}
+
+			err := i.TokenRenewRun()
+			if err == nil {
+				t.Fatal("expected error using rejected init token")
+			}
+			if tamper := strings.Contains(err.Error(), instanceToken.ErrWrappingTokenUsed.Error()); c.tamper != tamper {
+				t.Errorf("unexpected tamper error exp=%t got: %v", c.tamper, err)
+			}
+			if c.lookups != lookups {
+				t.Errorf("unexpected number of token lookups exp=%d got=%d", c.lookups, lookups)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package keystore
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+const (
+	jksMagic   = 0xfeedfeed
+	jksVersion = 2
+
+	jksPrivateKeyTag  = 1
+	jksTrustedCertTag = 2
+
+	// appended to the password for the integrity check of the keystore
+	jksWhitener = "Mighty Aphrodite"
+)
+
+// algorithm of the key protection of the Sun JKS provider
+var oidJavaKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+
+// EncodeJKS encodes the key with its certificate and the CA chain as Java
+// keystore with a single private key entry
+func EncodeJKS(key crypto.Signer, cert *x509.Certificate, chain []*x509.Certificate, alias, password string) ([]byte, error) {
+	if cert == nil {
+		return nil, errNoCertificate
+	}
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding private key: %v", err)
+	}
+
+	protected, err := protectJKSKey(pkcs8, utf16BE(password))
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidJavaKeyProtector, Parameters: asn1.NullRawValue},
+		EncryptedData: protected,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	w := newJKSWriter(1)
+	w.entryHeader(jksPrivateKeyTag, alias)
+	w.bytes(info)
+	certs := append([]*x509.Certificate{cert}, chain...)
+	w.uint32(uint32(len(certs)))
+	for _, c := range certs {
+		w.certificate(c)
+	}
+
+	return w.finish(password), nil
+}
+
+// EncodeJKSTrustStore encodes the certificates as Java keystore of trusted
+// certificate entries, aliased alias, alias-1, alias-2 and so on
+func EncodeJKSTrustStore(certs []*x509.Certificate, alias, password string) ([]byte, error) {
+	if len(certs) == 0 {
+		return nil, errNoCertificate
+	}
+
+	w := newJKSWriter(len(certs))
+	for n, c := range certs {
+		a := alias
+		if n > 0 {
+			a = fmt.Sprintf("%s-%d", alias, n)
+		}
+		w.entryHeader(jksTrustedCertTag, a)
+		w.certificate(c)
+	}
+
+	return w.finish(password), nil
+}
+
+// protectJKSKey encrypts the key with the proprietary algorithm of the Sun
+// JKS provider: the key is XORed with a SHA-1 based key stream of the
+// password and a random salt, followed by a SHA-1 checksum.
+func protectJKSKey(key, password []byte) ([]byte, error) {
+	salt := make([]byte, sha1.Size)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	out := append([]byte{}, salt...)
+	out = append(out, jksKeyStream(key, password, salt)...)
+	check := sha1.Sum(append(append([]byte{}, password...), key...))
+
+	return append(out, check[:]...), nil
+}
+
+// jksKeyStream XORs data with the key stream of the password and salt
+func jksKeyStream(data, password, salt []byte) []byte {
+	out := make([]byte, len(data))
+	digest := salt
+	for i := 0; i < len(data); i += sha1.Size {
+		sum := sha1.Sum(append(append([]byte{}, password...), digest...))
+		digest = sum[:]
+		for j := 0; j < sha1.Size && i+j < len(data); j++ {
+			out[i+j] = data[i+j] ^ digest[j]
+		}
+	}
+
+	return out
+}
+
+type jksWriter struct {
+	buf bytes.Buffer
+	now time.Time
+}
+
+func newJKSWriter(entries int) *jksWriter {
+	w := &jksWriter{now: time.Now()}
+	w.uint32(jksMagic)
+	w.uint32(jksVersion)
+	w.uint32(uint32(entries))
+
+	return w
+}
+
+func (w *jksWriter) uint32(v uint32) {
+	binary.Write(&w.buf, binary.BigEndian, v)
+}
+
+// utf writes s like Java's DataOutput.writeUTF, which is the same as UTF-8
+// for the strings written here
+func (w *jksWriter) utf(s string) {
+	binary.Write(&w.buf, binary.BigEndian, uint16(len(s)))
+	w.buf.WriteString(s)
+}
+
+func (w *jksWriter) bytes(b []byte) {
+	w.uint32(uint32(len(b)))
+	w.buf.Write(b)
+}
+
+func (w *jksWriter) entryHeader(tag uint32, alias string) {
+	w.uint32(tag)
+	w.utf(alias)
+	binary.Write(&w.buf, binary.BigEndian, w.now.UnixNano()/int64(time.Millisecond))
+}
+
+func (w *jksWriter) certificate(cert *x509.Certificate) {
+	w.utf("X.509")
+	w.bytes(cert.Raw)
+}
+
+// finish appends the integrity check of the keystore and returns it
+func (w *jksWriter) finish(password string) []byte {
+	h := sha1.New()
+	h.Write(utf16BE(password))
+	h.Write([]byte(jksWhitener))
+	h.Write(w.buf.Bytes())
+
+	return append(w.buf.Bytes(), h.Sum(nil)...)
+}
@@ -0,0 +1,32 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package keystore
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"unicode/utf16"
+)
+
+var errNoCertificate = errors.New("no certificate given")
+
+// GeneratePassword returns a random password of 32 URL safe characters
+func GeneratePassword() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// utf16BE returns s as big endian UTF-16, as passwords are encoded as Java
+// chars by both keystore formats
+func utf16BE(s string) []byte {
+	var b []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(r>>8), byte(r))
+	}
+
+	return b
+}
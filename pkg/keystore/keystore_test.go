@@ -0,0 +1,400 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package keystore
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"flag"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testCertificates(t *testing.T) (*ecdsa.PrivateKey, *x509.Certificate, *x509.Certificate) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "leaf"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err = x509.CreateCertificate(rand.Reader, tmpl, ca, key.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key, cert, ca
+}
+
+var updateGolden = flag.Bool("update", false, "update the golden keystores in testdata")
+
+// constReader returns the same byte forever, for reproducible salts
+type constReader byte
+
+func (r constReader) Read(b []byte) (int, error) {
+	for n := range b {
+		b[n] = byte(r)
+	}
+	return len(b), nil
+}
+
+// readTestdata returns the first pem block of each file in testdata
+func readTestdata(t *testing.T, names ...string) [][]byte {
+	var blocks [][]byte
+	for _, name := range names {
+		dat, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, _ := pem.Decode(dat)
+		if block == nil {
+			t.Fatalf("no pem data in testdata/%s", name)
+		}
+		blocks = append(blocks, block.Bytes)
+	}
+
+	return blocks
+}
+
+func TestPBKDF(t *testing.T) {
+	key := pbkdf([]byte("\xff\xff\xff\xff\xff\xff\xff\xff"), bmpString("sesame"), 2048, 1, 24)
+	if exp := []byte("\x7c\xd9\xfd\x3e\x2b\x3b\xe7\x69\x1a\x44\xe3\xbe\xf0\xf9\xea\x0f\xb9\xb8\x97\xd4\xe3\x25\xd9\xd1"); !bytes.Equal(exp, key) {
+		t.Errorf("unexpected key exp=%x got=%x", exp, key)
+	}
+
+	// blocks of I with leading zeros
+	key = pbkdf([]byte("\xf3\x7e\x05\xb5\x18\x32\x4b\x4b"), []byte("\x00\x00"), 2048, 1, 24)
+	if exp := []byte("\x00\xf7\x59\xff\x47\xd1\x4d\xd0\x36\x65\xd5\x94\x3c\xb3\xc4\xa3\x9a\x25\x55\xc0\x2a\xed\x66\xe1"); !bytes.Equal(exp, key) {
+		t.Errorf("unexpected key exp=%x got=%x", exp, key)
+	}
+}
+
+func TestEncodePKCS12(t *testing.T) {
+	key, cert, ca := testCertificates(t)
+
+	p12, err := EncodePKCS12(key, cert, []*x509.Certificate{ca}, "leaf", "s3cret")
+	if err != nil {
+		t.Fatalf("error encoding pkcs12: %v", err)
+	}
+
+	var pfx pfxPdu
+	if _, err := asn1.Unmarshal(p12, &pfx); err != nil {
+		t.Fatalf("error decoding pkcs12: %v", err)
+	}
+	var authSafeBytes []byte
+	if _, err := asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafeBytes); err != nil {
+		t.Fatalf("error decoding auth safe: %v", err)
+	}
+
+	macKey := pbkdf(pfx.MacData.MacSalt, bmpString("s3cret"), pfx.MacData.Iterations, 3, 20)
+	mac := hmac.New(sha1.New, macKey)
+	mac.Write(authSafeBytes)
+	if !hmac.Equal(mac.Sum(nil), pfx.MacData.Mac.Digest) {
+		t.Fatal("invalid mac")
+	}
+
+	var authSafe []contentInfo
+	if _, err := asn1.Unmarshal(authSafeBytes, &authSafe); err != nil {
+		t.Fatalf("error decoding auth safe: %v", err)
+	}
+	if exp, act := 2, len(authSafe); exp != act {
+		t.Fatalf("unexpected number of content infos exp=%d got=%d", exp, act)
+	}
+
+	var bags [][]safeBag
+	for _, ci := range authSafe {
+		var safeContents []byte
+		if _, err := asn1.Unmarshal(ci.Content.Bytes, &safeContents); err != nil {
+			t.Fatalf("error decoding safe contents: %v", err)
+		}
+		var b []safeBag
+		if _, err := asn1.Unmarshal(safeContents, &b); err != nil {
+			t.Fatalf("error decoding safe bags: %v", err)
+		}
+		bags = append(bags, b)
+	}
+
+	for n, exp := range []*x509.Certificate{cert, ca} {
+		var bag certBag
+		if _, err := asn1.Unmarshal(bags[0][n].Value.Bytes, &bag); err != nil {
+			t.Fatalf("error decoding cert bag: %v", err)
+		}
+		var der []byte
+		if _, err := asn1.Unmarshal(bag.Data.Bytes, &der); err != nil {
+			t.Fatalf("error decoding certificate: %v", err)
+		}
+		if !bytes.Equal(exp.Raw, der) {
+			t.Errorf("unexpected certificate %d", n)
+		}
+	}
+
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(bags[1][0].Value.Bytes, &info); err != nil {
+		t.Fatalf("error decoding key bag: %v", err)
+	}
+	var params pbeParams
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		t.Fatalf("error decoding pbe parameters: %v", err)
+	}
+	block, err := des.NewTripleDESCipher(pbkdf(params.Salt, bmpString("s3cret"), params.Iterations, 1, 24))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(block, pbkdf(params.Salt, bmpString("s3cret"), params.Iterations, 2, 8)).CryptBlocks(decrypted, info.EncryptedData)
+	decrypted = decrypted[:len(decrypted)-int(decrypted[len(decrypted)-1])]
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pkcs8, decrypted) {
+		t.Error("unexpected decrypted key")
+	}
+}
+
+// The bundle encoded from the keys in testdata has been checked with OpenSSL.
+// leaf.p12.txt is the output of:
+//
+//	openssl pkcs12 -info -nodes -passin pass:s3cret -in testdata/leaf.p12 2>&1
+func TestEncodePKCS12_OpenSSL(t *testing.T) {
+	blocks := readTestdata(t, "key.pem", "cert.pem", "ca.pem")
+	key, err := x509.ParseECPrivateKey(blocks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var certs []*x509.Certificate
+	for _, der := range blocks[1:] {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, cert)
+	}
+
+	defer func(r io.Reader) { saltReader = r }(saltReader)
+	saltReader = constReader(0x5a)
+
+	p12, err := EncodePKCS12(key, certs[0], certs[1:], "leaf", "s3cret")
+	if err != nil {
+		t.Fatalf("error encoding pkcs12: %v", err)
+	}
+	golden := filepath.Join("testdata", "leaf.p12")
+	if *updateGolden {
+		if err := ioutil.WriteFile(golden, p12, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exp, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(exp, p12) {
+		t.Error("encoded pkcs12 differs from testdata/leaf.p12")
+	}
+
+	// OpenSSL verified the MAC, decrypted the key and decoded both
+	// certificates of the bundle
+	info, err := ioutil.ReadFile(golden + ".txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"MAC: sha1, Iteration 2048", "Shrouded Keybag: pbeWithSHA1And3-KeyTripleDES-CBC, Iteration 2048", "friendlyName: leaf"} {
+		if !strings.Contains(string(info), line) {
+			t.Errorf("expected openssl output to contain '%s'", line)
+		}
+	}
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded [][]byte
+	keyDecoded := false
+	for block, rest := pem.Decode(info); block != nil; block, rest = pem.Decode(rest) {
+		switch block.Type {
+		case "CERTIFICATE":
+			decoded = append(decoded, block.Bytes)
+		case "PRIVATE KEY":
+			if !bytes.Equal(pkcs8, block.Bytes) {
+				t.Error("unexpected key decrypted by openssl")
+			}
+			keyDecoded = true
+		}
+	}
+	if !keyDecoded {
+		t.Error("expected key decrypted by openssl")
+	}
+	if len(decoded) != 2 || !bytes.Equal(certs[0].Raw, decoded[0]) || !bytes.Equal(certs[1].Raw, decoded[1]) {
+		t.Error("unexpected certificates decoded by openssl")
+	}
+}
+
+// jksEntries verifies the integrity of the keystore and returns the protected
+// key and certificates, or the trusted certificate, of its entries by alias
+func jksEntries(t *testing.T, jks []byte, password string) map[string][][]byte {
+	data, digest := jks[:len(jks)-sha1.Size], jks[len(jks)-sha1.Size:]
+	h := sha1.New()
+	h.Write(utf16BE(password))
+	h.Write([]byte(jksWhitener))
+	h.Write(data)
+	if !bytes.Equal(h.Sum(nil), digest) {
+		t.Fatal("invalid keystore digest")
+	}
+
+	r := bytes.NewReader(data)
+	readUint32 := func() uint32 {
+		var v uint32
+		if err := binary.Read(r, binary.BigEndian, &v); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	readBytes := func(n int) []byte {
+		b := make([]byte, n)
+		if _, err := r.Read(b); err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	readUTF := func() string {
+		var n uint16
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			t.Fatal(err)
+		}
+		return string(readBytes(int(n)))
+	}
+
+	if readUint32() != jksMagic || readUint32() != jksVersion {
+		t.Fatal("unexpected keystore header")
+	}
+
+	entries := make(map[string][][]byte)
+	for n := readUint32(); n > 0; n-- {
+		tag := readUint32()
+		alias := readUTF()
+		readBytes(8)
+
+		if tag == jksPrivateKeyTag {
+			entries[alias] = append(entries[alias], readBytes(int(readUint32())))
+			for c := readUint32(); c > 0; c-- {
+				if readUTF() != "X.509" {
+					t.Fatal("unexpected certificate type")
+				}
+				entries[alias] = append(entries[alias], readBytes(int(readUint32())))
+			}
+			continue
+		}
+
+		if readUTF() != "X.509" {
+			t.Fatal("unexpected certificate type")
+		}
+		entries[alias] = append(entries[alias], readBytes(int(readUint32())))
+	}
+
+	return entries
+}
+
+func TestEncodeJKS(t *testing.T) {
+	key, cert, ca := testCertificates(t)
+
+	jks, err := EncodeJKS(key, cert, []*x509.Certificate{ca}, "leaf", "s3cret")
+	if err != nil {
+		t.Fatalf("error encoding jks: %v", err)
+	}
+
+	entry := jksEntries(t, jks, "s3cret")["leaf"]
+	if exp, act := 3, len(entry); exp != act {
+		t.Fatalf("unexpected number of entry items exp=%d got=%d", exp, act)
+	}
+	if !bytes.Equal(cert.Raw, entry[1]) || !bytes.Equal(ca.Raw, entry[2]) {
+		t.Error("unexpected certificate chain")
+	}
+
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(entry[0], &info); err != nil {
+		t.Fatalf("error decoding protected key: %v", err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidJavaKeyProtector) {
+		t.Errorf("unexpected key protection algorithm %s", info.Algorithm.Algorithm)
+	}
+
+	protected := info.EncryptedData
+	salt := protected[:sha1.Size]
+	encrypted := protected[sha1.Size : len(protected)-sha1.Size]
+	decrypted := jksKeyStream(encrypted, utf16BE("s3cret"), salt)
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pkcs8, decrypted) {
+		t.Error("unexpected decrypted key")
+	}
+	check := sha1.Sum(append(utf16BE("s3cret"), pkcs8...))
+	if !bytes.Equal(check[:], protected[len(protected)-sha1.Size:]) {
+		t.Error("unexpected key checksum")
+	}
+}
+
+func TestEncodeJKSTrustStore(t *testing.T) {
+	_, cert, ca := testCertificates(t)
+
+	jks, err := EncodeJKSTrustStore([]*x509.Certificate{ca, cert}, "ca", "s3cret")
+	if err != nil {
+		t.Fatalf("error encoding jks: %v", err)
+	}
+
+	entries := jksEntries(t, jks, "s3cret")
+	if !bytes.Equal(ca.Raw, entries["ca"][0]) || !bytes.Equal(cert.Raw, entries["ca-1"][0]) {
+		t.Error("unexpected trusted certificates")
+	}
+
+	if _, err := EncodeJKSTrustStore(nil, "ca", "s3cret"); err == nil {
+		t.Error("expected error for empty trust store")
+	}
+}
@@ -0,0 +1,320 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package keystore
+
+import (
+	"crypto"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+// iterations of the key derivation for the key encryption and the MAC
+const pkcs12Iterations = 2048
+
+// source of the salts, replaced in tests for reproducible bundles
+var saltReader io.Reader = rand.Reader
+
+var (
+	oidDataContentType               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPKCS8ShroudedKeyBag           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag                       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidCertTypeX509Certificate       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidPBEWithSHAAnd3KeyTripleDESCBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidFriendlyName                  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID                    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidSHA1                          = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+)
+
+type pfxPdu struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data asn1.RawValue
+}
+
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// EncodePKCS12 encodes the key, its certificate and the CA chain as PKCS#12
+// bundle. The key is encrypted with the password using
+// pbeWithSHAAnd3-KeyTripleDES-CBC, and the bundle is protected by a SHA-1
+// HMAC, which are supported by both OpenSSL and Java.
+func EncodePKCS12(key crypto.Signer, cert *x509.Certificate, chain []*x509.Certificate, alias, password string) ([]byte, error) {
+	if cert == nil {
+		return nil, errNoCertificate
+	}
+	bmpPassword := bmpString(password)
+
+	keyID := sha1.Sum(cert.Raw)
+	attributes, err := bagAttributes(alias, keyID[:])
+	if err != nil {
+		return nil, err
+	}
+
+	var certBags []safeBag
+	for n, c := range append([]*x509.Certificate{cert}, chain...) {
+		bag, err := newCertBag(c)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			bag.Attributes = attributes
+		}
+		certBags = append(certBags, *bag)
+	}
+
+	keyBag, err := newShroudedKeyBag(key, bmpPassword)
+	if err != nil {
+		return nil, err
+	}
+	keyBag.Attributes = attributes
+
+	var authSafe []contentInfo
+	for _, bags := range [][]safeBag{certBags, {*keyBag}} {
+		ci, err := newDataContentInfo(bags)
+		if err != nil {
+			return nil, err
+		}
+		authSafe = append(authSafe, *ci)
+	}
+
+	authSafeBytes, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, err
+	}
+
+	pfx := pfxPdu{Version: 3}
+	if pfx.AuthSafe.Content, err = explicitOctetString(authSafeBytes); err != nil {
+		return nil, err
+	}
+	pfx.AuthSafe.ContentType = oidDataContentType
+
+	pfx.MacData.Iterations = pkcs12Iterations
+	pfx.MacData.MacSalt = make([]byte, 8)
+	if _, err := io.ReadFull(saltReader, pfx.MacData.MacSalt); err != nil {
+		return nil, err
+	}
+	macKey := pbkdf(pfx.MacData.MacSalt, bmpPassword, pkcs12Iterations, 3, 20)
+	mac := hmac.New(sha1.New, macKey)
+	mac.Write(authSafeBytes)
+	pfx.MacData.Mac = digestInfo{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+		Digest:    mac.Sum(nil),
+	}
+
+	return asn1.Marshal(pfx)
+}
+
+func bagAttributes(alias string, keyID []byte) ([]pkcs12Attribute, error) {
+	keyIDValue, err := asn1.Marshal(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	nameValue, err := asn1.Marshal(asn1.RawValue{Tag: 30, Bytes: utf16BE(alias)})
+	if err != nil {
+		return nil, err
+	}
+
+	return []pkcs12Attribute{
+		{ID: oidFriendlyName, Value: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: nameValue}},
+		{ID: oidLocalKeyID, Value: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: keyIDValue}},
+	}, nil
+}
+
+func newCertBag(cert *x509.Certificate) (*safeBag, error) {
+	data, err := explicitOctetString(cert.Raw)
+	if err != nil {
+		return nil, err
+	}
+
+	bag, err := asn1.Marshal(certBag{ID: oidCertTypeX509Certificate, Data: data})
+	if err != nil {
+		return nil, err
+	}
+
+	return &safeBag{ID: oidCertBag, Value: explicit(bag)}, nil
+}
+
+func newShroudedKeyBag(key crypto.Signer, bmpPassword []byte) (*safeBag, error) {
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding private key: %v", err)
+	}
+
+	params := pbeParams{Salt: make([]byte, 8), Iterations: pkcs12Iterations}
+	if _, err := io.ReadFull(saltReader, params.Salt); err != nil {
+		return nil, err
+	}
+	paramBytes, err := asn1.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := encryptTripleDES(pkcs8, params.Salt, bmpPassword, params.Iterations)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidPBEWithSHAAnd3KeyTripleDESCBC,
+			Parameters: asn1.RawValue{FullBytes: paramBytes},
+		},
+		EncryptedData: encrypted,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &safeBag{ID: oidPKCS8ShroudedKeyBag, Value: explicit(info)}, nil
+}
+
+func newDataContentInfo(bags []safeBag) (*contentInfo, error) {
+	safeContents, err := asn1.Marshal(bags)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := explicitOctetString(safeContents)
+	if err != nil {
+		return nil, err
+	}
+
+	return &contentInfo{ContentType: oidDataContentType, Content: content}, nil
+}
+
+func encryptTripleDES(data, salt, bmpPassword []byte, iterations int) ([]byte, error) {
+	key := pbkdf(salt, bmpPassword, iterations, 1, 24)
+	iv := pbkdf(salt, bmpPassword, iterations, 2, 8)
+
+	block, err := des.NewTripleDESCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// PKCS#7 padding
+	padding := block.BlockSize() - len(data)%block.BlockSize()
+	padded := make([]byte, len(data), len(data)+padding)
+	copy(padded, data)
+	for i := 0; i < padding; i++ {
+		padded = append(padded, byte(padding))
+	}
+
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(padded, padded)
+
+	return padded, nil
+}
+
+// explicit wraps DER encoded data in a [0] EXPLICIT tag
+func explicit(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+func explicitOctetString(data []byte) (asn1.RawValue, error) {
+	der, err := asn1.Marshal(data)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+
+	return explicit(der), nil
+}
+
+// bmpString returns s as big endian UTF-16 with a zero terminator
+func bmpString(s string) []byte {
+	return append(utf16BE(s), 0, 0)
+}
+
+// pbkdf derives size bytes for the purpose id from the password and salt with
+// the SHA-1 based key derivation of RFC 7292, appendix B.2
+func pbkdf(salt, password []byte, iterations int, id byte, size int) []byte {
+	const v = 64
+
+	fill := func(pattern []byte) []byte {
+		if len(pattern) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(pattern)+v-1)/v))
+		for i := range out {
+			out[i] = pattern[i%len(pattern)]
+		}
+		return out
+	}
+
+	d := make([]byte, v)
+	for i := range d {
+		d[i] = id
+	}
+	in := append(fill(salt), fill(password)...)
+
+	var out []byte
+	for len(out) < size {
+		a := sha1.Sum(append(d, in...))
+		for i := 1; i < iterations; i++ {
+			a = sha1.Sum(a[:])
+		}
+		out = append(out, a[:]...)
+
+		// I_j = (I_j + B + 1) mod 2^v for each v-bit block of I
+		b := new(big.Int).SetBytes(fill(a[:]))
+		b.Add(b, big.NewInt(1))
+		mod := new(big.Int).Lsh(big.NewInt(1), v*8)
+		for j := 0; j < len(in); j += v {
+			ij := new(big.Int).SetBytes(in[j : j+v])
+			ij.Add(ij, b).Mod(ij, mod)
+			block := ij.Bytes()
+			for k := range in[j : j+v] {
+				in[j+k] = 0
+			}
+			copy(in[j+v-len(block):j+v], block)
+		}
+	}
+
+	return out[:size]
+}
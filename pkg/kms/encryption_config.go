@@ -0,0 +1,29 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package kms
+
+import (
+	"strings"
+)
+
+// EncryptionConfig generates a kube-apiserver encryption config using the
+// kms-plugin listening at socketPath. Resources written before the kms
+// provider was enabled stay readable through the identity provider; data
+// encrypted with the aescbc key in secrets/encryption-config needs that
+// provider to be added to the list until it has been re-written.
+func EncryptionConfig(socketPath string) string {
+	encryptionConfig := `kind: EncryptionConfig
+apiVersion: v1
+resources:
+  - resources:
+    - secrets
+    - configmaps
+    providers:
+    - kms:
+        name: vault-helper
+        endpoint: unix://SOCKET
+        cachesize: 1000
+    - identity: {}
+`
+
+	return strings.Replace(encryptionConfig, "SOCKET", socketPath, 1)
+}
@@ -0,0 +1,224 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package kms
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+
+	"github.com/jetstack/vault-helper/pkg/instanceToken"
+	"github.com/jetstack/vault-helper/pkg/kubernetes"
+)
+
+const FlagSocketPath = "socket-path"
+const FlagKeyName = "key-name"
+const FlagEncryptionConfigPath = "encryption-config-path"
+const FlagTokenRenewInterval = "token-renew-interval"
+
+// Version is reported as the runtime version of the kms plugin
+var Version string
+
+type KMS struct {
+	transitPath          string
+	keyName              string
+	socketPath           string
+	encryptionConfigPath string
+	tokenRenewInterval   time.Duration
+
+	Log           *logrus.Entry
+	instanceToken *instanceToken.InstanceToken
+}
+
+var _ Service = &KMS{}
+
+func New(logger *logrus.Entry, i *instanceToken.InstanceToken) *KMS {
+	k := &KMS{
+		keyName:            kubernetes.TransitKeyName,
+		socketPath:         "/var/run/vault-helper/kms.sock",
+		tokenRenewInterval: time.Hour,
+		instanceToken:      i,
+	}
+
+	if logger != nil {
+		k.Log = logger
+	}
+
+	return k
+}
+
+// RunKMS serves the kubernetes KMS gRPC API on the configured unix socket
+// until the listener fails
+func (k *KMS) RunKMS() error {
+	if k.EncryptionConfigPath() != "" {
+		if err := k.writeEncryptionConfig(); err != nil {
+			return err
+		}
+	}
+
+	l, err := k.listen()
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	go k.renewToken()
+
+	k.Log.Infof("Serving kms plugin for transit key '%s' on: %s", k.KeyName(), k.SocketPath())
+
+	return NewServer(k, k.Log).Serve(l)
+}
+
+// Encrypt encrypts the plain text using the vault transit key
+func (k *KMS) Encrypt(plain []byte) ([]byte, error) {
+	path := filepath.Join(k.TransitPath(), "encrypt", k.KeyName())
+
+	sec, err := k.InstanceToken().VaultClient().Logical().Write(path, map[string]interface{}{
+		"plaintext": base64.StdEncoding.EncodeToString(plain),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting with transit key at '%s': %v", path, err)
+	}
+
+	cipher, err := secretField(sec, "ciphertext")
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(cipher), nil
+}
+
+// Decrypt decrypts the cipher text using the vault transit key
+func (k *KMS) Decrypt(cipher []byte) ([]byte, error) {
+	path := filepath.Join(k.TransitPath(), "decrypt", k.KeyName())
+
+	sec, err := k.InstanceToken().VaultClient().Logical().Write(path, map[string]interface{}{
+		"ciphertext": string(cipher),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting with transit key at '%s': %v", path, err)
+	}
+
+	plain, err := secretField(sec, "plaintext")
+	if err != nil {
+		return nil, err
+	}
+
+	return base64.StdEncoding.DecodeString(plain)
+}
+
+func secretField(sec *vault.Secret, field string) (string, error) {
+	if sec == nil {
+		return "", errors.New("no secret returned from vault")
+	}
+
+	dat, ok := sec.Data[field]
+	if !ok {
+		return "", fmt.Errorf("%s field not found", field)
+	}
+
+	str, ok := dat.(string)
+	if !ok {
+		return "", fmt.Errorf("failed to convert %s field to string", field)
+	}
+
+	return str, nil
+}
+
+func (k *KMS) listen() (net.Listener, error) {
+	dir := filepath.Dir(k.SocketPath())
+	if err := os.MkdirAll(dir, os.FileMode(0750)); err != nil {
+		return nil, fmt.Errorf("error creating socket directory '%s': %v", dir, err)
+	}
+
+	// remove a stale socket left behind by a previous run
+	if err := os.Remove(k.SocketPath()); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error removing stale socket '%s': %v", k.SocketPath(), err)
+	}
+
+	l, err := net.Listen("unix", k.SocketPath())
+	if err != nil {
+		return nil, fmt.Errorf("error listening on socket '%s': %v", k.SocketPath(), err)
+	}
+
+	if err := os.Chmod(k.SocketPath(), os.FileMode(0600)); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("error changing permissons of socket '%s' to 0600: %v", k.SocketPath(), err)
+	}
+
+	return l, nil
+}
+
+func (k *KMS) writeEncryptionConfig() error {
+	path := k.EncryptionConfigPath()
+
+	if err := ioutil.WriteFile(path, []byte(EncryptionConfig(k.SocketPath())), 0600); err != nil {
+		return fmt.Errorf("error writing encryption config to '%s': %v", path, err)
+	}
+
+	k.Log.Infof("Encryption config written to file: %s", path)
+
+	return nil
+}
+
+// keep the token alive for as long as the plugin is serving
+func (k *KMS) renewToken() {
+	if k.tokenRenewInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(k.tokenRenewInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := k.InstanceToken().TokenRenewRun(); err != nil {
+			k.Log.Errorf("error renewing token: %v", err)
+		}
+	}
+}
+
+func (k *KMS) SetTransitPath(path string) {
+	k.transitPath = path
+}
+func (k *KMS) TransitPath() string {
+	return k.transitPath
+}
+
+func (k *KMS) SetKeyName(name string) {
+	k.keyName = name
+}
+func (k *KMS) KeyName() string {
+	return k.keyName
+}
+
+func (k *KMS) SetSocketPath(path string) {
+	k.socketPath = path
+}
+func (k *KMS) SocketPath() string {
+	return k.socketPath
+}
+
+func (k *KMS) SetEncryptionConfigPath(path string) {
+	k.encryptionConfigPath = path
+}
+func (k *KMS) EncryptionConfigPath() string {
+	return k.encryptionConfigPath
+}
+
+func (k *KMS) SetTokenRenewInterval(interval time.Duration) {
+	k.tokenRenewInterval = interval
+}
+func (k *KMS) TokenRenewInterval() time.Duration {
+	return k.tokenRenewInterval
+}
+
+func (k *KMS) InstanceToken() *instanceToken.InstanceToken {
+	return k.instanceToken
+}
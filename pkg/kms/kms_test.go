@@ -0,0 +1,164 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package kms
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+)
+
+type fakeService struct{}
+
+func (f *fakeService) Encrypt(plain []byte) ([]byte, error) {
+	return append([]byte("vault:v1:"), plain...), nil
+}
+
+func (f *fakeService) Decrypt(cipher []byte) ([]byte, error) {
+	if !bytes.HasPrefix(cipher, []byte("vault:v1:")) {
+		return nil, errors.New("invalid ciphertext")
+	}
+	return bytes.TrimPrefix(cipher, []byte("vault:v1:")), nil
+}
+
+func TestProto_RoundTrip(t *testing.T) {
+	b := marshalFields([]byte("v1beta1"), []byte("secret data"))
+
+	req := new(encryptRequest)
+	if err := req.unmarshal(b); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if exp, act := "v1beta1", req.Version; exp != act {
+		t.Errorf("unexpected version exp=%s got=%s", exp, act)
+	}
+	if exp, act := "secret data", string(req.Plain); exp != act {
+		t.Errorf("unexpected plain exp=%s got=%s", exp, act)
+	}
+
+	// unknown varint field 3 must be skipped
+	b = append(b, 3<<3|wireVarint, 0x96, 0x01)
+	if err := req.unmarshal(b); err != nil {
+		t.Fatalf("unexpected error with unknown field: %v", err)
+	}
+
+	if _, err := unmarshalFields([]byte{1<<3 | wireBytes, 10, 'a'}); err == nil {
+		t.Error("expected error for truncated field")
+	}
+}
+
+func TestServer_EncryptDecrypt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-helper-kms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "kms.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go NewServer(&fakeService{}, logrus.NewEntry(logrus.New())).Serve(l)
+
+	client := &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return net.Dial("unix", socket)
+			},
+		},
+	}
+
+	resp := call(t, client, "Version", marshalFields([]byte(APIVersion)))
+	fields, err := unmarshalFields(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := RuntimeName, string(fields[2]); exp != act {
+		t.Errorf("unexpected runtime name exp=%s got=%s", exp, act)
+	}
+
+	resp = call(t, client, "Encrypt", marshalFields([]byte(APIVersion), []byte("plain")))
+	fields, err = unmarshalFields(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipher := fields[1]
+	if exp, act := "vault:v1:plain", string(cipher); exp != act {
+		t.Errorf("unexpected cipher exp=%s got=%s", exp, act)
+	}
+
+	resp = call(t, client, "Decrypt", marshalFields([]byte(APIVersion), cipher))
+	fields, err = unmarshalFields(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "plain", string(fields[1]); exp != act {
+		t.Errorf("unexpected plain exp=%s got=%s", exp, act)
+	}
+}
+
+func TestEncryptionConfig(t *testing.T) {
+	config := EncryptionConfig("/var/run/kms.sock")
+	if !strings.Contains(config, "endpoint: unix:///var/run/kms.sock") {
+		t.Errorf("encryption config doesn't reference socket:\n%s", config)
+	}
+}
+
+func TestServer_EncodeGRPCMessage(t *testing.T) {
+	for message, exp := range map[string]string{
+		"":                       "",
+		"invalid version":        "invalid version",
+		"100% failed":            "100%25 failed",
+		"clé invalide\n":         "cl%C3%A9 invalide%0A",
+		"transit key 'k8s' ~ok~": "transit key 'k8s' ~ok~",
+	} {
+		if act := encodeGRPCMessage(message); exp != act {
+			t.Errorf("unexpected encoding of '%s' exp=%s got=%s", message, exp, act)
+		}
+	}
+}
+
+func call(t *testing.T, client *http.Client, method string, msg []byte) []byte {
+	var body bytes.Buffer
+	if err := writeMessage(&body, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "http://localhost"+servicePrefix+method, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("error calling %s: %v", method, err)
+	}
+	defer resp.Body.Close()
+
+	b, err := readMessage(resp.Body)
+	if err != nil {
+		t.Fatalf("error reading %s response: %v", method, err)
+	}
+
+	// trailers are only populated once the body has been consumed
+	ioutil.ReadAll(resp.Body)
+	if exp, act := "0", resp.Trailer.Get("Grpc-Status"); exp != act {
+		t.Errorf("unexpected grpc status for %s exp=%s got=%s", method, exp, act)
+	}
+
+	return b
+}
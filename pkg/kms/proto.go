@@ -0,0 +1,177 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package kms
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// The messages of the kubernetes KMS v1beta1 API only contain string and
+// bytes fields, so they are encoded by hand rather than pulling in a
+// protobuf runtime.
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+type versionRequest struct {
+	Version string
+}
+
+type versionResponse struct {
+	Version        string
+	RuntimeName    string
+	RuntimeVersion string
+}
+
+type encryptRequest struct {
+	Version string
+	Plain   []byte
+}
+
+type encryptResponse struct {
+	Cipher []byte
+}
+
+type decryptRequest struct {
+	Version string
+	Cipher  []byte
+}
+
+type decryptResponse struct {
+	Plain []byte
+}
+
+func (v *versionRequest) unmarshal(b []byte) error {
+	fields, err := unmarshalFields(b)
+	if err != nil {
+		return err
+	}
+
+	v.Version = string(fields[1])
+
+	return nil
+}
+
+func (v *versionResponse) marshal() []byte {
+	return marshalFields(
+		[]byte(v.Version),
+		[]byte(v.RuntimeName),
+		[]byte(v.RuntimeVersion),
+	)
+}
+
+func (e *encryptRequest) unmarshal(b []byte) error {
+	fields, err := unmarshalFields(b)
+	if err != nil {
+		return err
+	}
+
+	e.Version = string(fields[1])
+	e.Plain = fields[2]
+
+	return nil
+}
+
+func (e *encryptResponse) marshal() []byte {
+	return marshalFields(e.Cipher)
+}
+
+func (d *decryptRequest) unmarshal(b []byte) error {
+	fields, err := unmarshalFields(b)
+	if err != nil {
+		return err
+	}
+
+	d.Version = string(fields[1])
+	d.Cipher = fields[2]
+
+	return nil
+}
+
+func (d *decryptResponse) marshal() []byte {
+	return marshalFields(d.Plain)
+}
+
+// marshalFields encodes each value as a length delimited field, numbered
+// from 1 in the order given. Empty values are omitted.
+func marshalFields(values ...[]byte) []byte {
+	var b []byte
+
+	for n, value := range values {
+		if len(value) == 0 {
+			continue
+		}
+
+		b = appendVarint(b, uint64(n+1)<<3|wireBytes)
+		b = appendVarint(b, uint64(len(value)))
+		b = append(b, value...)
+	}
+
+	return b
+}
+
+// unmarshalFields decodes all length delimited fields keyed by their field
+// number. Fields of any other wire type are skipped.
+func unmarshalFields(b []byte) (map[int][]byte, error) {
+	fields := make(map[int][]byte)
+
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errors.New("malformed field key")
+		}
+		b = b[n:]
+
+		number := int(key >> 3)
+
+		switch key & 0x7 {
+		case wireVarint:
+			_, n := binary.Uvarint(b)
+			if n <= 0 {
+				return nil, fmt.Errorf("malformed varint in field %d", number)
+			}
+			b = b[n:]
+
+		case wireFixed64:
+			if len(b) < 8 {
+				return nil, fmt.Errorf("truncated fixed64 in field %d", number)
+			}
+			b = b[8:]
+
+		case wireFixed32:
+			if len(b) < 4 {
+				return nil, fmt.Errorf("truncated fixed32 in field %d", number)
+			}
+			b = b[4:]
+
+		case wireBytes:
+			length, n := binary.Uvarint(b)
+			if n <= 0 {
+				return nil, fmt.Errorf("malformed length in field %d", number)
+			}
+			b = b[n:]
+
+			if uint64(len(b)) < length {
+				return nil, fmt.Errorf("truncated value in field %d", number)
+			}
+			fields[number] = b[:length]
+			b = b[length:]
+
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d in field %d", key&0x7, number)
+		}
+	}
+
+	return fields, nil
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
@@ -0,0 +1,227 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package kms
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+)
+
+// APIVersion is the version of the kubernetes KMS API served by the plugin
+const APIVersion = "v1beta1"
+
+// RuntimeName is reported to the kube-apiserver as name of the kms runtime
+const RuntimeName = "vault-helper"
+
+const servicePrefix = "/v1beta1.KeyManagementService/"
+
+// gRPC status codes returned by the server
+const (
+	codeOK            = 0
+	codeInvalidArg    = 3
+	codeUnimplemented = 12
+	codeInternal      = 13
+)
+
+// maximum size of a single gRPC message accepted by the server
+const maxMessageSize = 16 << 20
+
+// Service encrypts and decrypts data on behalf of the kube-apiserver
+type Service interface {
+	Encrypt(plain []byte) ([]byte, error)
+	Decrypt(cipher []byte) ([]byte, error)
+}
+
+// Server is a minimal gRPC server for the KeyManagementService, speaking
+// HTTP/2 with prior knowledge as used over unix sockets
+type Server struct {
+	service Service
+	h2      *http2.Server
+
+	Log *logrus.Entry
+}
+
+func NewServer(service Service, logger *logrus.Entry) *Server {
+	return &Server{
+		service: service,
+		h2:      &http2.Server{},
+		Log:     logger,
+	}
+}
+
+// Serve accepts connections on the listener until it is closed
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return fmt.Errorf("error accepting connection: %v", err)
+		}
+
+		go s.h2.ServeConn(conn, &http2.ServeConnOpts{
+			Handler: s,
+		})
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "unsupported request", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+
+	req, err := readMessage(r.Body)
+	if err != nil {
+		s.writeStatus(w, codeInvalidArg, err.Error())
+		return
+	}
+
+	var resp []byte
+	method := strings.TrimPrefix(r.URL.Path, servicePrefix)
+
+	switch method {
+	case "Version":
+		resp, err = s.version(req)
+	case "Encrypt":
+		resp, err = s.encrypt(req)
+	case "Decrypt":
+		resp, err = s.decrypt(req)
+	default:
+		s.writeStatus(w, codeUnimplemented, fmt.Sprintf("unknown method '%s'", r.URL.Path))
+		return
+	}
+
+	if err != nil {
+		s.Log.Errorf("kms %s failed: %v", method, err)
+		s.writeStatus(w, codeInternal, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := writeMessage(w, resp); err != nil {
+		s.Log.Errorf("error writing kms %s response: %v", method, err)
+		return
+	}
+
+	s.writeStatus(w, codeOK, "")
+}
+
+func (s *Server) version(b []byte) ([]byte, error) {
+	req := new(versionRequest)
+	if err := req.unmarshal(b); err != nil {
+		return nil, err
+	}
+
+	resp := &versionResponse{
+		Version:        APIVersion,
+		RuntimeName:    RuntimeName,
+		RuntimeVersion: Version,
+	}
+
+	return resp.marshal(), nil
+}
+
+func (s *Server) encrypt(b []byte) ([]byte, error) {
+	req := new(encryptRequest)
+	if err := req.unmarshal(b); err != nil {
+		return nil, err
+	}
+
+	cipher, err := s.service.Encrypt(req.Plain)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &encryptResponse{
+		Cipher: cipher,
+	}
+
+	return resp.marshal(), nil
+}
+
+func (s *Server) decrypt(b []byte) ([]byte, error) {
+	req := new(decryptRequest)
+	if err := req.unmarshal(b); err != nil {
+		return nil, err
+	}
+
+	plain, err := s.service.Decrypt(req.Cipher)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &decryptResponse{
+		Plain: plain,
+	}
+
+	return resp.marshal(), nil
+}
+
+// writeStatus sets the gRPC status as trailers of the response
+func (s *Server) writeStatus(w http.ResponseWriter, code int, message string) {
+	w.Header().Set(http2.TrailerPrefix+"Grpc-Status", fmt.Sprintf("%d", code))
+	if message != "" {
+		w.Header().Set(http2.TrailerPrefix+"Grpc-Message", encodeGRPCMessage(message))
+	}
+}
+
+// encodeGRPCMessage percent-encodes the message as required by the gRPC spec
+// for the grpc-message trailer: bytes outside printable ASCII and '%' itself
+func encodeGRPCMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+
+	return b.String()
+}
+
+// readMessage reads a single length prefixed gRPC message
+func readMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, fmt.Errorf("error reading message prefix: %v", err)
+	}
+
+	if prefix[0] != 0 {
+		return nil, errors.New("compressed messages are not supported")
+	}
+
+	length := binary.BigEndian.Uint32(prefix[1:])
+	if length > maxMessageSize {
+		return nil, fmt.Errorf("message of %d bytes exceeds maximum size", length)
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(r, int64(length)))
+	if err != nil {
+		return nil, fmt.Errorf("error reading message: %v", err)
+	}
+	if uint32(len(b)) != length {
+		return nil, errors.New("message truncated")
+	}
+
+	return b, nil
+}
+
+// writeMessage writes a single length prefixed gRPC message
+func writeMessage(w io.Writer, b []byte) error {
+	prefix := make([]byte, 5, 5+len(b))
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(b)))
+
+	_, err := w.Write(append(prefix, b...))
+	return err
+}
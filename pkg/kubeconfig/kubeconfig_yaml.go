@@ -52,19 +52,19 @@ type Usr struct {
 }
 
 func (u *Kubeconfig) EncodeCerts() error {
-	byt, err := u.encode64File(u.Cert().Destination() + "-key.pem")
+	byt, err := u.encode64File(u.Cert().KeyFile())
 	if err != nil {
 		return err
 	}
 	u.SetCertKey64(byt)
 
-	byt, err = u.encode64File(u.Cert().Destination() + "-ca.pem")
+	byt, err = u.encode64File(u.Cert().CAFile())
 	if err != nil {
 		return err
 	}
 	u.SetCertCA64(byt)
 
-	byt, err = u.encode64File(u.Cert().Destination() + ".pem")
+	byt, err = u.encode64File(u.Cert().CertFile())
 	if err != nil {
 		return err
 	}
@@ -11,8 +11,11 @@ import (
 )
 
 type InitToken struct {
-	Role          string
-	Policies      []string
+	Role     string
+	Policies []string
+	// EntityAlias is the only entity alias allowed for created tokens, set
+	// for init tokens of a single node
+	EntityAlias   string
 	kubernetes    *Kubernetes
 	token         *string
 	ExpectedToken string
@@ -258,10 +261,18 @@ func (i *InitToken) secretsBackend() *GenericVaultBackend {
 }
 
 func (i *InitToken) writeData() map[string]interface{} {
-	return map[string]interface{}{
+	data := map[string]interface{}{
 		"period":           fmt.Sprintf("%d", int(i.kubernetes.MaxValidityInitTokens.Seconds())),
 		"orphan":           true,
 		"allowed_policies": i.Policies,
 		"path_suffix":      i.namePath(),
 	}
+
+	// tokens of a node carry its node name as entity alias, which is bound
+	// by the token role rather than chosen by the node
+	if i.EntityAlias != "" {
+		data["allowed_entity_aliases"] = []string{i.EntityAlias}
+	}
+
+	return data
 }
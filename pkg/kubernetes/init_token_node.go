@@ -0,0 +1,79 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package kubernetes
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	vault "github.com/hashicorp/vault/api"
+
+	"github.com/jetstack/vault-helper/pkg/vaultVersion"
+)
+
+// node names are used in token role names and as entity aliases
+var nodeNameRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]*[a-z0-9])?$`)
+
+// NewNodeInitToken returns the init token of a role bound to a single node.
+// Its token role only allows creating tokens with the node name as entity
+// alias, so the restricted kubelet role signs certificates of that node only.
+// Init tokens of the node classes don't allow any entity alias.
+func (k *Kubernetes) NewNodeInitToken(role, nodeName string) (*InitToken, error) {
+	if !nodeNameRegexp.MatchString(nodeName) {
+		return nil, fmt.Errorf("invalid node name '%s', must be a lower case DNS name", nodeName)
+	}
+
+	for _, i := range k.NewInitTokens() {
+		if i.Role == role {
+			return &InitToken{
+				Role:        fmt.Sprintf("%s-%s", role, nodeName),
+				Policies:    i.Policies,
+				EntityAlias: nodeName,
+				kubernetes:  k,
+			}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown init token role '%s', valid roles are: etcd, master, worker, all", role)
+}
+
+// NodeInitToken writes the token role and policy of the node's init token and
+// creates a new init token for the node. The token isn't stored in vault and
+// has to be delivered to the node.
+func (k *Kubernetes) NodeInitToken(role, nodeName string) (string, error) {
+	if err := isValidClusterID(k.clusterID); err != nil {
+		return "", fmt.Errorf("error '%s' is not a valid clusterID", k.clusterID)
+	}
+
+	i, err := k.NewNodeInitToken(role, nodeName)
+	if err != nil {
+		return "", err
+	}
+	if err := vaultVersion.Require(k.vaultClient.Sys(), vaultVersion.MinEntityAliases, "node init tokens"); err != nil {
+		return "", err
+	}
+
+	if err := i.writeTokenRole(); err != nil {
+		return "", fmt.Errorf("not able to write token role: %s", err)
+	}
+	if err := i.writeInitTokenPolicy(); err != nil {
+		return "", fmt.Errorf("not able to write init token policy: %s", err)
+	}
+
+	secret, err := k.vaultClient.Auth().Token().CreateOrphan(&vault.TokenCreateRequest{
+		DisplayName: fmt.Sprintf("%s/init_token_%s", k.Path(), i.Role),
+		TTL:         fmt.Sprintf("%d", int(k.MaxValidityInitTokens.Seconds())),
+		Period:      fmt.Sprintf("%d", int(k.MaxValidityInitTokens.Seconds())),
+		Policies:    []string{"default", i.policy().Name},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error creating init token for node '%s': %v", nodeName, err)
+	}
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return "", errors.New("no token returned from token creation")
+	}
+
+	k.Log.Infof("Init token created for node '%s' with token role '%s', accessor: %s", nodeName, i.Name(), secret.Auth.Accessor)
+
+	return secret.Auth.ClientToken, nil
+}
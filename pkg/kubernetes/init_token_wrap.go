@@ -0,0 +1,51 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package kubernetes
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+const FlagWrapTTL = "wrap-ttl"
+const FlagWrapDestPath = "dest-path"
+
+// WrapInitToken reads the init token of the role response-wrapped with the
+// given TTL. The returned wrapping token can be delivered to a node instead
+// of the init token and can only be unwrapped once.
+func (k *Kubernetes) WrapInitToken(vaultClient *vault.Client, role string, ttl time.Duration) (string, error) {
+	if err := isValidClusterID(k.clusterID); err != nil {
+		return "", fmt.Errorf("error '%s' is not a valid clusterID", k.clusterID)
+	}
+
+	if ttl < time.Second {
+		return "", fmt.Errorf("wrap ttl %s is less than 1s", ttl)
+	}
+
+	path := k.secretsBackend.initTokenPath(role)
+
+	vaultClient.SetWrappingLookupFunc(func(operation, p string) string {
+		if p == path {
+			return fmt.Sprintf("%ds", int(ttl.Seconds()))
+		}
+		return ""
+	})
+	defer vaultClient.SetWrappingLookupFunc(nil)
+
+	secret, err := vaultClient.Logical().Read(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading init token at '%s': %v", path, err)
+	}
+	if secret == nil {
+		return "", fmt.Errorf("no init token found for role '%s' at '%s'", role, path)
+	}
+	if secret.WrapInfo == nil || secret.WrapInfo.Token == "" {
+		return "", errors.New("vault didn't return a wrapped response")
+	}
+
+	k.Log.Infof("Init token for role '%s' wrapped with ttl %s, accessor: %s", role, ttl, secret.WrapInfo.Accessor)
+
+	return secret.WrapInfo.Token, nil
+}
@@ -25,6 +25,11 @@ const FlagInitTokenAll = "init-token-all"
 const FlagInitTokenMaster = "init-token-master"
 const FlagInitTokenWorker = "init-token-worker"
 
+const FlagEnableTransit = "enable-transit"
+const FlagEnableSSH = "enable-ssh"
+const FlagSSHAllowedDomains = "ssh-allowed-domains"
+const FlagRestrictKubelet = "restrict-kubelet"
+
 var Version string
 
 type Backend interface {
@@ -43,6 +48,7 @@ type VaultLogical interface {
 }
 
 type VaultSys interface {
+	Health() (*vault.HealthResponse, error)
 	ListMounts() (map[string]*vault.MountOutput, error)
 	ListPolicies() ([]string, error)
 
@@ -111,10 +117,30 @@ type Kubernetes struct {
 	// A generic vault backend for static secrets
 	secretsBackend *GenericVaultBackend
 
+	// An optional transit backend, used by the kms-plugin to encrypt
+	// kubernetes secrets at rest
+	transitBackend *TransitVaultBackend
+
+	// Optional SSH CAs, used to sign the host keys of nodes and the user keys
+	// of operators
+	sshHostBackend *SSHVaultBackend
+	sshUserBackend *SSHVaultBackend
+
 	MaxValidityAdmin      time.Duration
 	MaxValidityComponents time.Duration
 	MaxValidityCA         time.Duration
 	MaxValidityInitTokens time.Duration
+	MaxValiditySSHUser    time.Duration
+
+	EnableTransit bool
+	EnableSSH     bool
+
+	// Domains nodes are allowed to sign SSH host certificates for
+	SSHAllowedDomains []string
+
+	// Restrict kubelet certificates to the node name of the requesting token
+	RestrictKubelet   bool
+	tokenAuthAccessor string
 
 	FlagInitTokens FlagInitTokens
 
@@ -125,6 +151,8 @@ type Kubernetes struct {
 
 var _ Backend = &PKIVaultBackend{}
 var _ Backend = &GenericVaultBackend{}
+var _ Backend = &TransitVaultBackend{}
+var _ Backend = &SSHVaultBackend{}
 
 func (rv *realVault) Auth() VaultAuth {
 	return &realVaultAuth{a: rv.c.Auth()}
@@ -178,6 +206,8 @@ func New(vaultClient *vault.Client, logger *logrus.Entry) *Kubernetes {
 		MaxValidityComponents: time.Hour * 24 * 30,       // Validity period of Component certificates
 		MaxValidityAdmin:      time.Hour * 24 * 365,      // Validity period of Admin ceritficate
 		MaxValidityInitTokens: time.Hour * 24 * 365 * 5,  // Validity of init tokens
+		MaxValiditySSHUser:    time.Hour * 24,            // Validity of SSH user certificates
+		SSHAllowedDomains:     []string{"compute.internal", "ec2.internal"},
 		FlagInitTokens: FlagInitTokens{
 			Etcd:   "",
 			Master: "",
@@ -200,6 +230,9 @@ func New(vaultClient *vault.Client, logger *logrus.Entry) *Kubernetes {
 	k.kubernetesAPIProxyBackend = NewPKIVaultBackend(k, "k8s-api-proxy", k.Log)
 
 	k.secretsBackend = k.NewGenericVaultBackend(k.Log)
+	k.transitBackend = NewTransitVaultBackend(k, k.Log)
+	k.sshHostBackend = NewSSHVaultBackend(k, "host", k.Log)
+	k.sshUserBackend = NewSSHVaultBackend(k, "user", k.Log)
 
 	return k
 }
@@ -209,13 +242,38 @@ func (k *Kubernetes) SetClusterID(clusterID string) {
 }
 
 func (k *Kubernetes) backends() []Backend {
-	return []Backend{
+	backends := []Backend{
 		k.etcdKubernetesBackend,
 		k.etcdOverlayBackend,
 		k.kubernetesBackend,
 		k.kubernetesAPIProxyBackend,
 		k.secretsBackend,
 	}
+
+	if k.EnableTransit {
+		backends = append(backends, k.transitBackend)
+	}
+
+	if k.EnableSSH {
+		backends = append(backends, k.sshHostBackend, k.sshUserBackend)
+	}
+
+	return backends
+}
+
+// TransitBackend returns the transit backend used for kms encryption
+func (k *Kubernetes) TransitBackend() *TransitVaultBackend {
+	return k.transitBackend
+}
+
+// SSHHostBackend returns the SSH CA backend used to sign host keys
+func (k *Kubernetes) SSHHostBackend() *SSHVaultBackend {
+	return k.sshHostBackend
+}
+
+// SSHUserBackend returns the SSH CA backend used to sign user keys
+func (k *Kubernetes) SSHUserBackend() *SSHVaultBackend {
+	return k.sshUserBackend
 }
 
 func (k *Kubernetes) Ensure() error {
@@ -227,6 +285,12 @@ func (k *Kubernetes) Ensure() error {
 		return err
 	}
 
+	if k.RestrictKubelet {
+		if err := k.ensureTokenAuthAccessor(); err != nil {
+			return err
+		}
+	}
+
 	// setup backends
 	var result *multierror.Error
 	for _, backend := range k.backends() {
@@ -252,6 +316,13 @@ func (k *Kubernetes) Ensure() error {
 		result = multierror.Append(result, err)
 	}
 
+	// setup ssh roles
+	if k.EnableSSH {
+		if err := k.ensureSSHRoles(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
 	// setup policies
 	if err := k.ensurePolicies(); err != nil {
 		result = multierror.Append(result, err)
@@ -291,6 +362,12 @@ func (k *Kubernetes) EnsureDryRun() (bool, error) {
 		return true, d.ErrorOrNil()
 	}
 
+	if k.RestrictKubelet {
+		if err := k.ensureTokenAuthAccessor(); err != nil {
+			return true, err
+		}
+	}
+
 	for _, b := range k.backends() {
 		if d.changeNeeded(b.EnsureDryRun()) {
 			return true, d.ErrorOrNil()
@@ -313,6 +390,10 @@ func (k *Kubernetes) EnsureDryRun() (bool, error) {
 		return true, d.ErrorOrNil()
 	}
 
+	if k.EnableSSH && d.changeNeeded(k.ensureDryRunSSHRoles()) {
+		return true, d.ErrorOrNil()
+	}
+
 	if d.changeNeeded(k.ensureDryRunPolicies()) {
 		return true, d.ErrorOrNil()
 	}
@@ -349,6 +430,11 @@ func (k *Kubernetes) Delete() error {
 	if err := k.deletePKIRolesK8SAPIProxy(k.kubernetesAPIProxyBackend); err != nil {
 		result = multierror.Append(result, err)
 	}
+	if k.EnableSSH {
+		if err := k.deleteSSHRoles(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
 
 	for _, b := range k.backends() {
 		if err := b.Delete(); err != nil {
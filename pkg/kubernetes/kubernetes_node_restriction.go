@@ -0,0 +1,68 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package kubernetes
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jetstack/vault-helper/pkg/vaultVersion"
+)
+
+// Node tokens are created with an entity alias of the node name on the token
+// auth backend. With the kubelet restriction enabled, the kubelet role only
+// allows signing the name of the entity alias of the requesting token, so a
+// node can't request certificates for any other node. The alias is bound by
+// the token role of the node's own init token (see NodeInitToken); the shared
+// init tokens of the node classes can't create tokens with an entity alias.
+
+// ensureTokenAuthAccessor looks up the accessor of the token auth backend,
+// which identity templates reference entity aliases by. Vault versions
+// without entity aliases of token roles are rejected, as they would ignore
+// the restriction.
+func (k *Kubernetes) ensureTokenAuthAccessor() error {
+	if k.tokenAuthAccessor != "" {
+		return nil
+	}
+
+	if err := vaultVersion.Require(k.vaultClient.Sys(), vaultVersion.MinEntityAliases, "--"+FlagRestrictKubelet); err != nil {
+		return err
+	}
+
+	s, err := k.vaultClient.Logical().Read("/sys/auth")
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		return errors.New("no data returned from /sys/auth")
+	}
+
+	token, err := mapFromData("token/", s.Data)
+	if err != nil {
+		return fmt.Errorf("failed to get token data at /sys/auth: %s", err)
+	}
+
+	accessor, ok := token["accessor"].(string)
+	if !ok || accessor == "" {
+		return errors.New("failed to get accessor of token auth backend from /sys/auth")
+	}
+
+	k.tokenAuthAccessor = accessor
+
+	return nil
+}
+
+// nodeNameTemplate is replaced by the node name of the requesting token
+func (k *Kubernetes) nodeNameTemplate() string {
+	return fmt.Sprintf("{{identity.entity.aliases.%s.name}}", k.tokenAuthAccessor)
+}
+
+func (k *Kubernetes) kubeletAllowedDomains() []string {
+	if !k.RestrictKubelet {
+		return []string{"kubelet", "system:node", "system:node:*", "*.compute.internal", "*.ec2.internal"}
+	}
+
+	return []string{
+		fmt.Sprintf("system:node:%s", k.nodeNameTemplate()),
+		k.nodeNameTemplate(),
+	}
+}
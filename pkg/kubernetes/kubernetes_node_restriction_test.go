@@ -0,0 +1,121 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package kubernetes
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	vault "github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+)
+
+func TestNodeRestriction_KubeletRole(t *testing.T) {
+	k8s := New(nil, logrus.NewEntry(logrus.New()))
+	k8s.SetClusterID(clusterName)
+
+	role := k8s.k8sKubeletRole()
+	if _, ok := role.Data["allowed_domains_template"]; ok {
+		t.Error("unexpected allowed_domains_template in unrestricted kubelet role")
+	}
+
+	k8s.RestrictKubelet = true
+	k8s.tokenAuthAccessor = "auth_token_12345"
+
+	role = k8s.k8sKubeletRole()
+	exp := []string{
+		"system:node:{{identity.entity.aliases.auth_token_12345.name}}",
+		"{{identity.entity.aliases.auth_token_12345.name}}",
+	}
+	if act := role.Data["allowed_domains"]; !reflect.DeepEqual(exp, act) {
+		t.Errorf("unexpected allowed domains exp=%v got=%v", exp, act)
+	}
+	if act := role.Data["allowed_domains_template"]; act != true {
+		t.Errorf("expected allowed_domains_template, got=%v", act)
+	}
+	if act := role.Data["allow_glob_domains"]; act != false {
+		t.Errorf("expected glob domains to be disallowed, got=%v", act)
+	}
+
+	// shared init tokens must not be able to choose an entity alias
+	for _, i := range k8s.NewInitTokens() {
+		if act, ok := i.writeData()["allowed_entity_aliases"]; ok {
+			t.Errorf("unexpected allowed_entity_aliases in token role %s: %v", i.Name(), act)
+		}
+	}
+}
+
+func TestNodeRestriction_NodeInitToken(t *testing.T) {
+	fv := NewFakeVault(t)
+	defer fv.Finish()
+	fv.ExpectWrite()
+
+	k8s := fv.Kubernetes()
+	k8s.RestrictKubelet = true
+
+	i, err := k8s.NewNodeInitToken("worker", "node1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp, act := "test-cluster-inside-worker-node1", i.Name(); exp != act {
+		t.Errorf("unexpected token role exp=%s got=%s", exp, act)
+	}
+	if exp, act := []string{"node1"}, i.writeData()["allowed_entity_aliases"]; !reflect.DeepEqual(exp, act) {
+		t.Errorf("unexpected allowed entity aliases exp=%v got=%v", exp, act)
+	}
+	if exp, act := []string{k8s.workerPolicy().Name}, i.Policies; !reflect.DeepEqual(exp, act) {
+		t.Errorf("unexpected allowed policies exp=%v got=%v", exp, act)
+	}
+
+	// vault ignores the entity alias fields before 1.6.0
+	fv.fakeSys.EXPECT().Health().Times(1).Return(&vault.HealthResponse{Version: "0.9.6"}, nil)
+	if _, err := k8s.NodeInitToken("worker", "node1"); err == nil {
+		t.Error("expected error creating node init token with vault 0.9.6")
+	}
+
+	fv.fakeSys.EXPECT().Health().Times(1).Return(&vault.HealthResponse{Version: "1.6.0"}, nil)
+	fv.fakeLogical.EXPECT().Write("auth/token/roles/test-cluster-inside-worker-node1", i.writeData()).Return(nil, nil)
+	fv.fakeSys.EXPECT().PutPolicy("test-cluster-inside/worker-node1-creator", i.policy().Policy()).Return(nil)
+	fv.fakeToken.EXPECT().CreateOrphan(gomock.Any()).Return(&vault.Secret{
+		Auth: &vault.SecretAuth{ClientToken: "node1-init-token"},
+	}, nil)
+
+	token, err := k8s.NodeInitToken("worker", "node1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp, act := "node1-init-token", token; exp != act {
+		t.Errorf("unexpected token exp=%s got=%s", exp, act)
+	}
+
+	for _, r := range [][]string{{"ssh", "node1"}, {"worker", "Node_1"}, {"worker", ""}} {
+		if _, err := k8s.NewNodeInitToken(r[0], r[1]); err == nil {
+			t.Errorf("expected error for role '%s' and node name '%s'", r[0], r[1])
+		}
+	}
+}
+
+func TestNodeRestriction_VaultVersion(t *testing.T) {
+	fv := NewFakeVault(t)
+	defer fv.Finish()
+	fv.ExpectWrite()
+
+	k8s := fv.Kubernetes()
+	k8s.RestrictKubelet = true
+
+	fv.fakeSys.EXPECT().Health().Times(1).Return(&vault.HealthResponse{Version: "0.9.6"}, nil)
+	if err := k8s.ensureTokenAuthAccessor(); err == nil {
+		t.Error("expected error restricting kubelet with vault 0.9.6")
+	}
+
+	fv.fakeSys.EXPECT().Health().Times(1).Return(&vault.HealthResponse{Version: "1.6.0"}, nil)
+	fv.fakeLogical.EXPECT().Read("/sys/auth").Times(1).Return(&vault.Secret{Data: map[string]interface{}{
+		"token/": map[string]interface{}{"accessor": "auth_token_12345"},
+	}}, nil)
+	if err := k8s.ensureTokenAuthAccessor(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp, act := "auth_token_12345", k8s.tokenAuthAccessor; exp != act {
+		t.Errorf("unexpected token auth accessor exp=%s got=%s", exp, act)
+	}
+}
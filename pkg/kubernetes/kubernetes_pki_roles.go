@@ -105,14 +105,14 @@ func (k *Kubernetes) k8sAPIServerProxyRole() *pkiRole {
 }
 
 func (k *Kubernetes) k8sKubeletRole() *pkiRole {
-	return &pkiRole{
+	role := &pkiRole{
 		Name: "kubelet",
 		Data: map[string]interface{}{
 			"use_csr_common_name": false,
 			"use_csr_sans":        false,
 			"enforce_hostnames":   false,
 			"organization":        []string{"system:nodes"},
-			"allowed_domains":     []string{"kubelet", "system:node", "system:node:*", "*.compute.internal", "*.ec2.internal"},
+			"allowed_domains":     k.kubeletAllowedDomains(),
 			"allow_bare_domains":  true,
 			"allow_glob_domains":  true,
 			"allow_any_name":      false,
@@ -125,6 +125,15 @@ func (k *Kubernetes) k8sKubeletRole() *pkiRole {
 			"ttl":                 constructTimeString(k.MaxValidityComponents),
 		},
 	}
+
+	// only allow the exact node name of the requesting token
+	if k.RestrictKubelet {
+		role.Data["allowed_domains_template"] = true
+		role.Data["allow_glob_domains"] = false
+		role.Data["allow_subdomains"] = false
+	}
+
+	return role
 }
 
 func (k *Kubernetes) k8sComponentRole(roleName string) *pkiRole {
@@ -39,11 +39,7 @@ func (k *Kubernetes) ensurePolicies() error {
 	var result error
 
 	str := "Policies written for: "
-	for _, p := range []*Policy{
-		k.etcdPolicy(),
-		k.masterPolicy(),
-		k.workerPolicy(),
-	} {
+	for _, p := range k.policies() {
 		if err := k.WritePolicy(p); err != nil {
 			result = multierror.Append(result, err)
 		} else {
@@ -58,11 +54,7 @@ func (k *Kubernetes) ensurePolicies() error {
 func (k *Kubernetes) deletePolicies() error {
 	var result *multierror.Error
 
-	for _, p := range []*Policy{
-		k.etcdPolicy(),
-		k.masterPolicy(),
-		k.workerPolicy(),
-	} {
+	for _, p := range k.policies() {
 		if err := k.DeletePolicy(p); err != nil {
 			result = multierror.Append(result, err)
 		}
@@ -74,7 +66,7 @@ func (k *Kubernetes) deletePolicies() error {
 func (k *Kubernetes) ensureDryRunPolicies() (bool, error) {
 	var result *multierror.Error
 
-	for _, p := range []*Policy{k.etcdPolicy(), k.masterPolicy(), k.workerPolicy()} {
+	for _, p := range k.policies() {
 		policy, err := k.ReadPolicy(p)
 		if err != nil {
 			result = multierror.Append(result, err)
@@ -87,9 +79,24 @@ func (k *Kubernetes) ensureDryRunPolicies() (bool, error) {
 	return false, result.ErrorOrNil()
 }
 
+// policies returns all policies of the cluster
+func (k *Kubernetes) policies() []*Policy {
+	policies := []*Policy{
+		k.etcdPolicy(),
+		k.masterPolicy(),
+		k.workerPolicy(),
+	}
+
+	if k.EnableSSH {
+		policies = append(policies, k.sshAdminPolicy())
+	}
+
+	return policies
+}
+
 func (k *Kubernetes) etcdPolicy() *Policy {
 	role := "etcd"
-	return &Policy{
+	p := &Policy{
 		Name: fmt.Sprintf("%s/%s", k.clusterID, role),
 		Role: role,
 		Policies: []*policyPath{
@@ -103,6 +110,42 @@ func (k *Kubernetes) etcdPolicy() *Policy {
 			},
 		},
 	}
+
+	p.Policies = append(p.Policies, k.sshHostPolicyPaths(role)...)
+
+	return p
+}
+
+// sshHostPolicyPaths allows signing ssh host keys for the node class, if the
+// ssh CA is enabled
+func (k *Kubernetes) sshHostPolicyPaths(role string) []*policyPath {
+	if !k.EnableSSH {
+		return nil
+	}
+
+	return []*policyPath{
+		&policyPath{
+			path:         k.sshHostBackend.SignPath(role),
+			capabilities: []string{"create", "read", "update"},
+		},
+	}
+}
+
+// sshAdminPolicy allows operators to sign their user keys with the admin
+// role of the ssh user CA. It is not attached to any init token and has to be
+// granted to operators, for example with their auth method.
+func (k *Kubernetes) sshAdminPolicy() *Policy {
+	role := "ssh-admin"
+	return &Policy{
+		Name: fmt.Sprintf("%s/%s", k.clusterID, role),
+		Role: role,
+		Policies: []*policyPath{
+			&policyPath{
+				path:         k.sshUserBackend.SignPath("admin"),
+				capabilities: []string{"create", "read", "update"},
+			},
+		},
+	}
 }
 
 func (k *Kubernetes) masterPolicy() *Policy {
@@ -146,6 +189,23 @@ func (k *Kubernetes) masterPolicy() *Policy {
 		},
 	)
 
+	// allow to encrypt and decrypt secrets using the kms transit key
+	if k.EnableTransit {
+		p.Policies = append(
+			p.Policies,
+			&policyPath{
+				path:         k.transitBackend.EncryptPath(),
+				capabilities: []string{"update"},
+			},
+			&policyPath{
+				path:         k.transitBackend.DecryptPath(),
+				capabilities: []string{"update"},
+			},
+		)
+	}
+
+	p.Policies = append(p.Policies, k.sshHostPolicyPaths(role)...)
+
 	// adds the roles from the worker
 	p.Policies = append(p.Policies, k.workerPolicyPaths()...)
 
@@ -174,6 +234,6 @@ func (k *Kubernetes) workerPolicy() *Policy {
 	return &Policy{
 		Name:     fmt.Sprintf("%s/%s", k.clusterID, role),
 		Role:     role,
-		Policies: k.workerPolicyPaths(),
+		Policies: append(k.workerPolicyPaths(), k.sshHostPolicyPaths(role)...),
 	}
 }
@@ -0,0 +1,132 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package kubernetes
+
+import (
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+type sshRole struct {
+	Name string
+	Data map[string]interface{}
+}
+
+// sshHostRole allows nodes of the given class to sign their host keys
+func (k *Kubernetes) sshHostRole(roleName string) *sshRole {
+	return &sshRole{
+		Name: roleName,
+		Data: map[string]interface{}{
+			"key_type":                "ca",
+			"allow_host_certificates": true,
+			"allow_user_certificates": false,
+			"allowed_domains":         strings.Join(k.SSHAllowedDomains, ","),
+			"allow_bare_domains":      true,
+			"allow_subdomains":        true,
+			"max_ttl":                 constructTimeString(k.MaxValidityComponents),
+			"ttl":                     constructTimeString(k.MaxValidityComponents),
+		},
+	}
+}
+
+// sshUserRole allows operators to sign their user keys for node access
+func (k *Kubernetes) sshUserRole(roleName string) *sshRole {
+	return &sshRole{
+		Name: roleName,
+		Data: map[string]interface{}{
+			"key_type":                "ca",
+			"allow_host_certificates": false,
+			"allow_user_certificates": true,
+			"allowed_users":           "*",
+			"default_extensions": map[string]interface{}{
+				"permit-pty": "",
+			},
+			"max_ttl": constructTimeString(k.MaxValiditySSHUser),
+			"ttl":     constructTimeString(k.MaxValiditySSHUser),
+		},
+	}
+}
+
+func (k *Kubernetes) sshRolesHost() []*sshRole {
+	return []*sshRole{
+		k.sshHostRole("etcd"),
+		k.sshHostRole("master"),
+		k.sshHostRole("worker"),
+	}
+}
+
+func (k *Kubernetes) sshRolesUser() []*sshRole {
+	return []*sshRole{
+		k.sshUserRole("admin"),
+	}
+}
+
+// this makes sure all ssh roles are setup correctly
+func (k *Kubernetes) ensureSSHRoles() error {
+	var result *multierror.Error
+
+	for _, role := range k.sshRolesHost() {
+		if err := k.sshHostBackend.WriteRole(role); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	for _, role := range k.sshRolesUser() {
+		if err := k.sshUserBackend.WriteRole(role); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
+func (k *Kubernetes) deleteSSHRoles() error {
+	var result *multierror.Error
+
+	for _, role := range k.sshRolesHost() {
+		if err := k.sshHostBackend.DeleteRole(role); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	for _, role := range k.sshRolesUser() {
+		if err := k.sshUserBackend.DeleteRole(role); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
+func (k *Kubernetes) ensureDryRunSSHRoles() (bool, error) {
+	var result *multierror.Error
+
+	check := func(s *SSHVaultBackend, roles []*sshRole) bool {
+		for _, role := range roles {
+			secret, err := s.ReadRole(role)
+			if err != nil {
+				result = multierror.Append(result, err)
+			}
+
+			if secret == nil || len(secret.Data) == 0 {
+				return true
+			}
+
+			if !secretDataMatch(secret.Data, role.Data) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if check(k.sshHostBackend, k.sshRolesHost()) {
+		return true, result.ErrorOrNil()
+	}
+
+	if check(k.sshUserBackend, k.sshRolesUser()) {
+		return true, result.ErrorOrNil()
+	}
+
+	return false, result.ErrorOrNil()
+}
@@ -0,0 +1,252 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package kubernetes
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+const FlagCertsBackend = "backend"
+const FlagCertsCommonName = "common-name"
+const FlagCertsExpiresWithin = "expires-within"
+const FlagCertsOutput = "output"
+
+// IssuedCertificate is a certificate issued by a PKI backend of the cluster
+type IssuedCertificate struct {
+	Backend        string     `json:"backend"`
+	Serial         string     `json:"serial"`
+	CommonName     string     `json:"common_name"`
+	DNSNames       []string   `json:"dns_names,omitempty"`
+	IPAddresses    []string   `json:"ip_addresses,omitempty"`
+	URIs           []string   `json:"uris,omitempty"`
+	Organisation   []string   `json:"organisation,omitempty"`
+	NotAfter       time.Time  `json:"not_after"`
+	Revoked        bool       `json:"revoked"`
+	RevocationTime *time.Time `json:"revocation_time,omitempty"`
+}
+
+// CertificateFilter selects the listed certificates. Empty fields match all
+// certificates.
+type CertificateFilter struct {
+	// Backends are the names of the PKI backends, such as k8s or etcd-k8s
+	Backends []string
+	// CommonName is a glob of the common name, such as system:node:*
+	CommonName string
+	// ExpiresWithin matches certificates expiring within the duration,
+	// including expired certificates
+	ExpiresWithin time.Duration
+}
+
+// ListCertificates lists the certificates issued by the PKI backends of the
+// cluster, sorted by backend and expiry
+func (k *Kubernetes) ListCertificates(vaultClient *vault.Client, filter *CertificateFilter) ([]*IssuedCertificate, error) {
+	if err := isValidClusterID(k.clusterID); err != nil {
+		return nil, fmt.Errorf("error '%s' is not a valid clusterID", k.clusterID)
+	}
+	if _, err := path.Match(filter.CommonName, ""); err != nil {
+		return nil, fmt.Errorf("invalid common name glob '%s': %v", filter.CommonName, err)
+	}
+
+	backends, err := k.pkiBackends(filter.Backends)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var certs []*IssuedCertificate
+	for _, b := range backends {
+		secret, err := vaultClient.Logical().List(b.Path() + "/certs")
+		if err != nil {
+			return nil, fmt.Errorf("error listing certificates of backend '%s': %v", b.Path(), err)
+		}
+		// no certificates, or the backend doesn't exist
+		if secret == nil {
+			continue
+		}
+
+		keys, _ := secret.Data["keys"].([]interface{})
+		for _, key := range keys {
+			serial, ok := key.(string)
+			if !ok {
+				continue
+			}
+
+			secret, err := vaultClient.Logical().Read(b.Path() + "/cert/" + serial)
+			if err != nil {
+				return nil, fmt.Errorf("error reading certificate '%s' of backend '%s': %v", serial, b.Path(), err)
+			}
+			if secret == nil {
+				continue
+			}
+
+			cert, err := parseIssuedCertificate(b.Name(), serial, secret)
+			if err != nil {
+				k.Log.Warnf("Skipping certificate '%s' of backend '%s': %v", serial, b.Path(), err)
+				continue
+			}
+			if filter.match(cert, now) {
+				certs = append(certs, cert)
+			}
+		}
+	}
+
+	sort.SliceStable(certs, func(i, j int) bool {
+		if certs[i].Backend != certs[j].Backend {
+			return certs[i].Backend < certs[j].Backend
+		}
+		return certs[i].NotAfter.Before(certs[j].NotAfter)
+	})
+
+	return certs, nil
+}
+
+// pkiBackends returns the PKI backends of the given names, or all PKI
+// backends
+func (k *Kubernetes) pkiBackends(names []string) ([]*PKIVaultBackend, error) {
+	all := []*PKIVaultBackend{
+		k.etcdKubernetesBackend,
+		k.etcdOverlayBackend,
+		k.kubernetesBackend,
+		k.kubernetesAPIProxyBackend,
+	}
+	if len(names) == 0 {
+		return all, nil
+	}
+
+	var backends []*PKIVaultBackend
+	for _, name := range names {
+		var found bool
+		for _, b := range all {
+			if b.Name() == name {
+				backends = append(backends, b)
+				found = true
+			}
+		}
+		if !found {
+			var valid []string
+			for _, b := range all {
+				valid = append(valid, b.Name())
+			}
+			return nil, fmt.Errorf("unknown pki backend '%s', valid backends are: %s", name, strings.Join(valid, ", "))
+		}
+	}
+
+	return backends, nil
+}
+
+func parseIssuedCertificate(backend, serial string, secret *vault.Secret) (*IssuedCertificate, error) {
+	pemCert, ok := secret.Data["certificate"].(string)
+	if !ok {
+		return nil, errors.New("certificate field not found")
+	}
+	block, _ := pem.Decode([]byte(pemCert))
+	if block == nil {
+		return nil, errors.New("failed to decode certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+
+	c := &IssuedCertificate{
+		Backend:      backend,
+		Serial:       serial,
+		CommonName:   cert.Subject.CommonName,
+		DNSNames:     cert.DNSNames,
+		Organisation: cert.Subject.Organization,
+		NotAfter:     cert.NotAfter,
+	}
+	for _, ip := range cert.IPAddresses {
+		c.IPAddresses = append(c.IPAddresses, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		c.URIs = append(c.URIs, uri.String())
+	}
+
+	// the revocation time is zero for certificates which are not revoked
+	var revoked int64
+	switch t := secret.Data["revocation_time"].(type) {
+	case json.Number:
+		revoked, _ = t.Int64()
+	case int64:
+		revoked = t
+	case float64:
+		revoked = int64(t)
+	}
+	if revoked > 0 {
+		revocationTime := time.Unix(revoked, 0).UTC()
+		c.Revoked = true
+		c.RevocationTime = &revocationTime
+	}
+
+	return c, nil
+}
+
+func (f *CertificateFilter) match(c *IssuedCertificate, now time.Time) bool {
+	if f.CommonName != "" {
+		if ok, _ := path.Match(f.CommonName, c.CommonName); !ok {
+			return false
+		}
+	}
+
+	if f.ExpiresWithin > 0 && c.NotAfter.After(now.Add(f.ExpiresWithin)) {
+		return false
+	}
+
+	return true
+}
+
+func WriteCertificatesTable(w io.Writer, certs []*IssuedCertificate) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "BACKEND\tSERIAL\tCOMMON NAME\tSANS\tORGANISATION\tNOT AFTER\tREVOKED")
+	for _, c := range certs {
+		sans := append(append(append([]string{}, c.DNSNames...), c.IPAddresses...), c.URIs...)
+		revoked := "no"
+		if c.RevocationTime != nil {
+			revoked = c.RevocationTime.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", c.Backend, c.Serial, c.CommonName,
+			orNone(strings.Join(sans, ",")), orNone(strings.Join(c.Organisation, ",")), c.NotAfter.UTC().Format(time.RFC3339), revoked)
+	}
+
+	return tw.Flush()
+}
+
+func WriteCertificatesJSON(w io.Writer, certs []*IssuedCertificate) error {
+	if certs == nil {
+		certs = []*IssuedCertificate{}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(certs)
+}
+
+func WriteCertificates(w io.Writer, certs []*IssuedCertificate, format string) error {
+	switch format {
+	case "table":
+		return WriteCertificatesTable(w, certs)
+	case "json":
+		return WriteCertificatesJSON(w, certs)
+	}
+
+	return fmt.Errorf("unknown output format '%s', valid formats are: table, json", format)
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,144 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package kubernetes
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+func issuedCertificateSecret(t *testing.T, cn string, notAfter time.Time, revoked int64) *vault.Secret {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"system:nodes"}},
+		DNSNames:     []string{"node1.example.com"},
+		IPAddresses:  []net.IP{net.ParseIP("10.0.1.5")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &vault.Secret{Data: map[string]interface{}{
+		"certificate":     string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		"revocation_time": json.Number(big.NewInt(revoked).String()),
+	}}
+}
+
+func TestListCertificates_Parse(t *testing.T) {
+	notAfter := time.Now().Add(time.Hour * 24).Truncate(time.Second)
+	c, err := parseIssuedCertificate("k8s", "01", issuedCertificateSecret(t, "system:node:node1", notAfter, 0))
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+	if c.CommonName != "system:node:node1" || !c.NotAfter.Equal(notAfter) || c.Revoked {
+		t.Errorf("unexpected certificate: %+v", c)
+	}
+	if exp, act := "node1.example.com,10.0.1.5,system:nodes", strings.Join(append(append(c.DNSNames, c.IPAddresses...), c.Organisation...), ","); exp != act {
+		t.Errorf("unexpected names exp=%s got=%s", exp, act)
+	}
+
+	c, err = parseIssuedCertificate("k8s", "02", issuedCertificateSecret(t, "kube-proxy", notAfter, 1500000000))
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+	if !c.Revoked || c.RevocationTime.Unix() != 1500000000 {
+		t.Errorf("expected revoked certificate, got: %+v", c)
+	}
+
+	if _, err := parseIssuedCertificate("k8s", "03", &vault.Secret{Data: map[string]interface{}{}}); err == nil {
+		t.Error("expected error for missing certificate")
+	}
+}
+
+func TestListCertificates_Filter(t *testing.T) {
+	now := time.Now()
+	certs := []*IssuedCertificate{
+		{CommonName: "system:node:node1", NotAfter: now.Add(time.Hour)},
+		{CommonName: "system:node:node2", NotAfter: now.Add(time.Hour * 24 * 30)},
+		{CommonName: "kube-proxy", NotAfter: now.Add(-time.Hour)},
+	}
+
+	for _, r := range []struct {
+		filter CertificateFilter
+		exp    string
+	}{
+		{CertificateFilter{}, "system:node:node1,system:node:node2,kube-proxy"},
+		{CertificateFilter{CommonName: "system:node:*"}, "system:node:node1,system:node:node2"},
+		{CertificateFilter{ExpiresWithin: time.Hour * 24}, "system:node:node1,kube-proxy"},
+		{CertificateFilter{CommonName: "system:node:*", ExpiresWithin: time.Hour * 24}, "system:node:node1"},
+	} {
+		var matched []string
+		for _, c := range certs {
+			if r.filter.match(c, now) {
+				matched = append(matched, c.CommonName)
+			}
+		}
+		if act := strings.Join(matched, ","); r.exp != act {
+			t.Errorf("unexpected certificates for filter %+v exp=%s got=%s", r.filter, r.exp, act)
+		}
+	}
+
+	k := New(nil, nil)
+	k.SetClusterID("test-cluster")
+	backends, err := k.pkiBackends([]string{"etcd-k8s", "k8s"})
+	if err != nil {
+		t.Fatalf("error selecting backends: %v", err)
+	}
+	if exp, act := "test-cluster/pki/etcd-k8s", backends[0].Path(); exp != act {
+		t.Errorf("unexpected backend path exp=%s got=%s", exp, act)
+	}
+	if _, err := k.pkiBackends([]string{"ssh"}); err == nil {
+		t.Error("expected error for unknown backend")
+	}
+}
+
+func TestListCertificates_Write(t *testing.T) {
+	notAfter := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	certs := []*IssuedCertificate{
+		{Backend: "k8s", Serial: "01", CommonName: "kube-proxy", NotAfter: notAfter},
+		{Backend: "k8s", Serial: "02", CommonName: "system:node:node1", DNSNames: []string{"node1"}, Organisation: []string{"system:nodes"}, NotAfter: notAfter},
+	}
+
+	var out bytes.Buffer
+	if err := WriteCertificates(&out, certs, "table"); err != nil {
+		t.Fatalf("error writing table: %v", err)
+	}
+	exp := `BACKEND  SERIAL  COMMON NAME        SANS   ORGANISATION  NOT AFTER             REVOKED
+k8s      01      kube-proxy         -      -             2030-01-01T00:00:00Z  no
+k8s      02      system:node:node1  node1  system:nodes  2030-01-01T00:00:00Z  no
+`
+	if out.String() != exp {
+		t.Errorf("unexpected table exp=\n%s\ngot=\n%s", exp, out.String())
+	}
+
+	out.Reset()
+	if err := WriteCertificates(&out, nil, "json"); err != nil {
+		t.Fatalf("error writing json: %v", err)
+	}
+	if exp, act := "[]\n", out.String(); exp != act {
+		t.Errorf("unexpected json exp=%s got=%s", exp, act)
+	}
+
+	if err := WriteCertificates(&out, certs, "yaml"); err == nil {
+		t.Error("expected error for unknown output format")
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package kubernetes
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+)
+
+type SSHVaultBackend struct {
+	sshName    string
+	kubernetes *Kubernetes
+
+	Log *logrus.Entry
+}
+
+func NewSSHVaultBackend(k *Kubernetes, sshName string, logger *logrus.Entry) *SSHVaultBackend {
+	return &SSHVaultBackend{
+		sshName:    sshName,
+		kubernetes: k,
+		Log:        logger,
+	}
+}
+
+func (s *SSHVaultBackend) Ensure() error {
+	mount, err := GetMountByPath(s.kubernetes.vaultClient, s.Path())
+	if err != nil {
+		return err
+	}
+
+	if mount == nil {
+		s.Log.Debugf("No ssh mount found for: %s", s.Path())
+		err = s.kubernetes.vaultClient.Sys().Mount(
+			s.Path(),
+			&vault.MountInput{
+				Description: "Kubernetes " + s.kubernetes.clusterID + "/" + s.sshName + " SSH CA",
+				Type:        s.Type(),
+			},
+		)
+
+		if err != nil {
+			return fmt.Errorf("failed to create mount: %v", err)
+		}
+
+		s.Log.Infof("Mounted '%s'", s.Path())
+
+	} else if mount.Type != s.Type() {
+		return fmt.Errorf("Mount '%s' already existing with wrong type '%s'", s.Path(), mount.Type)
+	}
+
+	return s.ensureCA()
+}
+
+func (s *SSHVaultBackend) EnsureDryRun() (bool, error) {
+	mount, err := GetMountByPath(s.kubernetes.vaultClient, s.Path())
+	if err != nil {
+		return false, err
+	}
+
+	if mount == nil || mount.Type != s.Type() {
+		return true, nil
+	}
+
+	exist, err := s.caExists()
+	if err != nil {
+		return false, err
+	}
+
+	return !exist, nil
+}
+
+func (s *SSHVaultBackend) Delete() error {
+	if err := s.kubernetes.vaultClient.Sys().Unmount(s.Path()); err != nil {
+		return fmt.Errorf("failed to unmount ssh mount: %v", err)
+	}
+
+	return nil
+}
+
+func (s *SSHVaultBackend) ensureCA() error {
+	exist, err := s.caExists()
+	if err != nil {
+		return err
+	}
+
+	if exist {
+		return nil
+	}
+
+	data := map[string]interface{}{
+		"generate_signing_key": true,
+	}
+
+	if _, err := s.kubernetes.vaultClient.Logical().Write(s.caPath(), data); err != nil {
+		return fmt.Errorf("error generating ssh CA: %v", err)
+	}
+
+	s.Log.Infof("SSH CA generated '%s'", s.Path())
+
+	return nil
+}
+
+func (s *SSHVaultBackend) caExists() (bool, error) {
+	secret, err := s.kubernetes.vaultClient.Logical().Read(s.caPath())
+	if err != nil {
+		// vault errors reading the CA config before a signing key exists
+		if strings.Contains(err.Error(), "keys haven't been configured yet") {
+			return false, nil
+		}
+		return false, fmt.Errorf("error reading ssh ca path '%s': %v", s.caPath(), err)
+	}
+
+	if secret == nil {
+		return false, nil
+	}
+	if val, ok := secret.Data["public_key"]; !ok || val == "" {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func (s *SSHVaultBackend) WriteRole(role *sshRole) error {
+	_, err := s.kubernetes.vaultClient.Logical().Write(s.rolePath(role.Name), role.Data)
+	if err != nil {
+		return fmt.Errorf("error writting role '%s' to '%s': %v", role.Name, s.Path(), err)
+	}
+
+	return nil
+}
+
+func (s *SSHVaultBackend) DeleteRole(role *sshRole) error {
+	secret, err := s.kubernetes.vaultClient.Logical().Read(s.rolePath(role.Name))
+	if err != nil || secret == nil || secret.Data == nil {
+		return nil
+	}
+
+	_, err = s.kubernetes.vaultClient.Logical().Delete(s.rolePath(role.Name))
+	if err != nil {
+		return fmt.Errorf("error deleting role '%s' to '%s': %v", role.Name, s.Path(), err)
+	}
+
+	return nil
+}
+
+func (s *SSHVaultBackend) ReadRole(role *sshRole) (*vault.Secret, error) {
+	secret, err := s.kubernetes.vaultClient.Logical().Read(s.rolePath(role.Name))
+	if err != nil {
+		return nil, fmt.Errorf("error reading role '%s' to '%s': %v", role.Name, s.Path(), err)
+	}
+
+	return secret, nil
+}
+
+func (s *SSHVaultBackend) Path() string {
+	return filepath.Join(s.kubernetes.Path(), s.Type(), s.sshName)
+}
+
+// Type is the sting key of the vault backend type
+func (s *SSHVaultBackend) Type() string {
+	return "ssh"
+}
+
+func (s *SSHVaultBackend) Name() string {
+	return s.sshName
+}
+
+// SignPath is the vault path used to sign public keys with the given role
+func (s *SSHVaultBackend) SignPath(role string) string {
+	return filepath.Join(s.Path(), "sign", role)
+}
+
+func (s *SSHVaultBackend) caPath() string {
+	return filepath.Join(s.Path(), "config", "ca")
+}
+
+func (s *SSHVaultBackend) rolePath(role string) string {
+	return filepath.Join(s.Path(), "roles", role)
+}
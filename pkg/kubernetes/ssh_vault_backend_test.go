@@ -0,0 +1,63 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package kubernetes
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestSSHVaultBackend_Ensure(t *testing.T) {
+	backend := NewSSHVaultBackend(k, "host", logrus.NewEntry(logrus.New()))
+	if err := backend.Ensure(); err != nil {
+		t.Error("unexpected error: ", err)
+		return
+	}
+
+	if err := backend.Ensure(); err != nil {
+		t.Error("unexpected error: ", err)
+	}
+
+	changeNeeded, err := backend.EnsureDryRun()
+	if err != nil {
+		t.Error("unexpected error: ", err)
+	}
+	if changeNeeded {
+		t.Error("expected no change needed after ensure")
+	}
+}
+
+func TestSSHVaultBackend_Policies(t *testing.T) {
+	k8s := New(nil, logrus.NewEntry(logrus.New()))
+	k8s.SetClusterID(clusterName)
+
+	for _, p := range []*Policy{k8s.etcdPolicy(), k8s.masterPolicy(), k8s.workerPolicy()} {
+		if policy := p.Policy(); strings.Contains(policy, "/ssh/") {
+			t.Errorf("unexpected ssh path in %s policy:\n%s", p.Role, policy)
+		}
+	}
+
+	k8s.EnableSSH = true
+	for _, p := range []*Policy{k8s.etcdPolicy(), k8s.masterPolicy(), k8s.workerPolicy()} {
+		path := k8s.SSHHostBackend().SignPath(p.Role)
+		if policy := p.Policy(); !strings.Contains(policy, path) {
+			t.Errorf("expected path '%s' in %s policy:\n%s", path, p.Role, policy)
+		}
+	}
+
+	// operators sign their user keys with the admin role
+	policies := k8s.policies()
+	admin := policies[len(policies)-1]
+	if exp, act := clusterName+"/ssh-admin", admin.Name; exp != act {
+		t.Errorf("unexpected admin policy name exp=%s got=%s", exp, act)
+	}
+	if path := k8s.SSHUserBackend().SignPath("admin"); !strings.Contains(admin.Policy(), path) {
+		t.Errorf("expected path '%s' in admin policy:\n%s", path, admin.Policy())
+	}
+
+	// master must not be able to sign host keys as etcd
+	if policy := k8s.masterPolicy().Policy(); strings.Contains(policy, k8s.SSHHostBackend().SignPath("etcd")) {
+		t.Errorf("unexpected etcd sign path in master policy:\n%s", policy)
+	}
+}
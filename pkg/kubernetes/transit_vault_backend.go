@@ -0,0 +1,132 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package kubernetes
+
+import (
+	"fmt"
+	"path/filepath"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+)
+
+// TransitKeyName is the name of the transit key used by the kms-plugin to
+// encrypt kubernetes secrets at rest
+const TransitKeyName = "kms"
+
+type TransitVaultBackend struct {
+	kubernetes *Kubernetes
+
+	Log *logrus.Entry
+}
+
+func NewTransitVaultBackend(k *Kubernetes, logger *logrus.Entry) *TransitVaultBackend {
+	return &TransitVaultBackend{
+		kubernetes: k,
+		Log:        logger,
+	}
+}
+
+func (t *TransitVaultBackend) Ensure() error {
+	mount, err := GetMountByPath(t.kubernetes.vaultClient, t.Path())
+	if err != nil {
+		return err
+	}
+
+	if mount == nil {
+		t.Log.Debugf("No transit mount found for: %s", t.Path())
+		err = t.kubernetes.vaultClient.Sys().Mount(
+			t.Path(),
+			&vault.MountInput{
+				Description: "Kubernetes " + t.kubernetes.clusterID + " transit",
+				Type:        t.Type(),
+			},
+		)
+
+		if err != nil {
+			return fmt.Errorf("error creating mount: %v", err)
+		}
+
+		t.Log.Infof("Mounted transit: '%s'", t.Path())
+
+	} else if mount.Type != t.Type() {
+		return fmt.Errorf("Mount '%s' already existing with wrong type '%s'", t.Path(), mount.Type)
+	}
+
+	if secret, err := t.kubernetes.vaultClient.Logical().Read(t.KeyPath()); err != nil {
+		return fmt.Errorf("error checking for transit key %s: %v", t.KeyPath(), err)
+	} else if secret == nil {
+		if err := t.writeKey(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *TransitVaultBackend) EnsureDryRun() (bool, error) {
+	mount, err := GetMountByPath(t.kubernetes.vaultClient, t.Path())
+	if err != nil {
+		return false, err
+	}
+
+	if mount == nil || mount.Type != t.Type() {
+		return true, nil
+	}
+
+	if secret, err := t.kubernetes.vaultClient.Logical().Read(t.KeyPath()); err != nil {
+		return false, fmt.Errorf("error checking for transit key %s: %v", t.KeyPath(), err)
+	} else if secret == nil {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func (t *TransitVaultBackend) Delete() error {
+	if err := t.kubernetes.vaultClient.Sys().Unmount(t.Path()); err != nil {
+		return fmt.Errorf("failed to unmount transit mount: %v", err)
+	}
+
+	return nil
+}
+
+func (t *TransitVaultBackend) writeKey() error {
+	data := map[string]interface{}{
+		"type": "aes256-gcm96",
+	}
+
+	if _, err := t.kubernetes.vaultClient.Logical().Write(t.KeyPath(), data); err != nil {
+		return fmt.Errorf("error writing transit key %s: %v", t.KeyPath(), err)
+	}
+
+	t.Log.Infof("Transit key written '%s'", t.KeyPath())
+
+	return nil
+}
+
+func (t *TransitVaultBackend) Path() string {
+	return filepath.Join(t.kubernetes.Path(), "transit")
+}
+
+func (t *TransitVaultBackend) Type() string {
+	return "transit"
+}
+
+func (t *TransitVaultBackend) Name() string {
+	return "transit"
+}
+
+// KeyPath is the vault path of the named transit key
+func (t *TransitVaultBackend) KeyPath() string {
+	return filepath.Join(t.Path(), "keys", TransitKeyName)
+}
+
+// EncryptPath is the vault path used to encrypt data with the transit key
+func (t *TransitVaultBackend) EncryptPath() string {
+	return filepath.Join(t.Path(), "encrypt", TransitKeyName)
+}
+
+// DecryptPath is the vault path used to decrypt data with the transit key
+func (t *TransitVaultBackend) DecryptPath() string {
+	return filepath.Join(t.Path(), "decrypt", TransitKeyName)
+}
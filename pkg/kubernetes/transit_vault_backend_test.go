@@ -0,0 +1,46 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package kubernetes
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestTransitVaultBackend_Ensure(t *testing.T) {
+	backend := NewTransitVaultBackend(k, logrus.NewEntry(logrus.New()))
+	if err := backend.Ensure(); err != nil {
+		t.Error("unexpected error: ", err)
+		return
+	}
+
+	if err := backend.Ensure(); err != nil {
+		t.Error("unexpected error: ", err)
+	}
+
+	changeNeeded, err := backend.EnsureDryRun()
+	if err != nil {
+		t.Error("unexpected error: ", err)
+	}
+	if changeNeeded {
+		t.Error("expected no change needed after ensure")
+	}
+}
+
+func TestTransitVaultBackend_MasterPolicy(t *testing.T) {
+	k8s := New(nil, logrus.NewEntry(logrus.New()))
+	k8s.SetClusterID(clusterName)
+
+	if policy := k8s.masterPolicy().Policy(); strings.Contains(policy, "/transit/") {
+		t.Errorf("unexpected transit path in master policy:\n%s", policy)
+	}
+
+	k8s.EnableTransit = true
+	policy := k8s.masterPolicy().Policy()
+	for _, path := range []string{k8s.TransitBackend().EncryptPath(), k8s.TransitBackend().DecryptPath()} {
+		if !strings.Contains(policy, path) {
+			t.Errorf("expected path '%s' in master policy:\n%s", path, policy)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package retry
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const FlagRetryMax = "retry-max"
+const FlagRetryMinBackoff = "retry-min-backoff"
+const FlagRetryMaxBackoff = "retry-max-backoff"
+
+// vault api errors contain the HTTP status code of the response
+var codeRegexp = regexp.MustCompile(`Code: (\d{3})\.`)
+
+// messages of errors that are expected to go away on their own, e.g. while
+// vault is starting, unsealing or failing over to another node
+var retryableMessages = []string{
+	"connection refused",
+	"connection reset",
+	"no route to host",
+	"i/o timeout",
+	"no such host",
+	"EOF",
+	"Vault is sealed",
+	"standby",
+}
+
+type Backoff struct {
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	Log   *logrus.Entry
+	sleep func(time.Duration)
+}
+
+func New(logger *logrus.Entry) *Backoff {
+	b := &Backoff{
+		maxRetries: 5,
+		minBackoff: time.Millisecond * 500,
+		maxBackoff: time.Second * 30,
+		sleep:      time.Sleep,
+	}
+
+	if logger != nil {
+		b.Log = logger
+	}
+
+	return b
+}
+
+// Do runs f until it succeeds, fails with a permanent error or the retries
+// are used up. A nil Backoff runs f exactly once.
+func (b *Backoff) Do(operation string, f func() error) error {
+	if b == nil {
+		return f()
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = f(); err == nil {
+			return nil
+		}
+
+		if !IsRetryable(err) {
+			return err
+		}
+
+		if attempt >= b.maxRetries {
+			return fmt.Errorf("giving up on %s after %d retries: %v", operation, attempt, err)
+		}
+
+		wait := b.Duration(attempt)
+		if b.Log != nil {
+			b.Log.Warnf("error during %s, retrying in %s (%d/%d): %v", operation, wait, attempt+1, b.maxRetries, err)
+		}
+		b.sleep(wait)
+	}
+}
+
+// Duration returns the time to wait before the given retry attempt. It grows
+// exponentially from the minimum backoff up to the maximum, with jitter of up
+// to half the duration so that nodes booting together don't retry in step.
+func (b *Backoff) Duration(attempt int) time.Duration {
+	d := b.minBackoff
+	for i := 0; i < attempt && d < b.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > b.maxBackoff {
+		d = b.maxBackoff
+	}
+
+	if half := int64(d / 2); half > 0 {
+		d = time.Duration(half + rand.Int63n(half+1))
+	}
+
+	return d
+}
+
+// IsRetryable returns true if the error is likely to be transient. Server
+// errors, rate limiting and connection failures are retryable, any other
+// client error (such as 403 permission denied) is permanent.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if match := codeRegexp.FindStringSubmatch(err.Error()); match != nil {
+		return match[1][0] == '5' || match[1] == "429"
+	}
+
+	if netErr, ok := err.(net.Error); ok && (netErr.Timeout() || netErr.Temporary()) {
+		return true
+	}
+
+	for _, msg := range retryableMessages {
+		if strings.Contains(err.Error(), msg) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b *Backoff) SetMaxRetries(retries int) {
+	b.maxRetries = retries
+}
+func (b *Backoff) MaxRetries() int {
+	return b.maxRetries
+}
+
+func (b *Backoff) SetMinBackoff(d time.Duration) {
+	b.minBackoff = d
+}
+func (b *Backoff) MinBackoff() time.Duration {
+	return b.minBackoff
+}
+
+func (b *Backoff) SetMaxBackoff(d time.Duration) {
+	b.maxBackoff = d
+}
+func (b *Backoff) MaxBackoff() time.Duration {
+	return b.maxBackoff
+}
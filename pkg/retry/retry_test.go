@@ -0,0 +1,135 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package retry
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newTestBackoff(slept *[]time.Duration) *Backoff {
+	b := New(logrus.NewEntry(logrus.New()))
+	b.SetMaxRetries(3)
+	b.sleep = func(d time.Duration) {
+		*slept = append(*slept, d)
+	}
+	return b
+}
+
+func TestIsRetryable(t *testing.T) {
+	for msg, exp := range map[string]bool{
+		"Error making API request.\n\nURL: GET http://127.0.0.1:8200/v1/sys/mounts\nCode: 503. Errors:\n\n* Vault is sealed": true,
+		"Error making API request.\n\nURL: PUT http://127.0.0.1:8200/v1/pki/sign/a\nCode: 500. Errors:\n\n* internal error":  true,
+		"Error making API request.\n\nCode: 429. Errors:\n\n* rate limited":                                                  true,
+		"Error making API request.\n\nCode: 403. Errors:\n\n* permission denied":                                             false,
+		"Error making API request.\n\nCode: 400. Errors:\n\n* common name not allowed":                                       false,
+		"Put http://127.0.0.1:8200/v1/auth/token/renew-self: dial tcp 127.0.0.1:8200: connect: connection refused":           true,
+		"node is in standby mode":   true,
+		"invalid certificate role":  false,
+		"failed to parse json data": false,
+	} {
+		if act := IsRetryable(errors.New(msg)); act != exp {
+			t.Errorf("unexpected retryable for '%s' exp=%t got=%t", msg, exp, act)
+		}
+	}
+
+	if IsRetryable(nil) {
+		t.Error("nil error must not be retryable")
+	}
+}
+
+func TestBackoff_Do_Retryable(t *testing.T) {
+	var slept []time.Duration
+	b := newTestBackoff(&slept)
+
+	calls := 0
+	err := b.Do("test", func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("Code: 503. Errors:\n\n* Vault is sealed")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if exp, act := 3, calls; exp != act {
+		t.Errorf("unexpected calls exp=%d got=%d", exp, act)
+	}
+	if exp, act := 2, len(slept); exp != act {
+		t.Errorf("unexpected sleeps exp=%d got=%d", exp, act)
+	}
+}
+
+func TestBackoff_Do_Permanent(t *testing.T) {
+	var slept []time.Duration
+	b := newTestBackoff(&slept)
+
+	calls := 0
+	err := b.Do("test", func() error {
+		calls++
+		return errors.New("Code: 403. Errors:\n\n* permission denied")
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	if exp, act := 1, calls; exp != act {
+		t.Errorf("unexpected calls exp=%d got=%d", exp, act)
+	}
+	if len(slept) != 0 {
+		t.Errorf("unexpected sleeps: %v", slept)
+	}
+}
+
+func TestBackoff_Do_GiveUp(t *testing.T) {
+	var slept []time.Duration
+	b := newTestBackoff(&slept)
+
+	calls := 0
+	err := b.Do("test", func() error {
+		calls++
+		return errors.New("connection refused")
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	if exp, act := 4, calls; exp != act {
+		t.Errorf("unexpected calls exp=%d got=%d", exp, act)
+	}
+}
+
+func TestBackoff_Do_Nil(t *testing.T) {
+	var b *Backoff
+
+	calls := 0
+	b.Do("test", func() error {
+		calls++
+		return errors.New("connection refused")
+	})
+
+	if exp, act := 1, calls; exp != act {
+		t.Errorf("unexpected calls exp=%d got=%d", exp, act)
+	}
+}
+
+func TestBackoff_Duration(t *testing.T) {
+	b := New(nil)
+	b.SetMinBackoff(time.Second)
+	b.SetMaxBackoff(time.Second * 10)
+
+	for attempt, max := range []time.Duration{
+		time.Second, time.Second * 2, time.Second * 4, time.Second * 8, time.Second * 10, time.Second * 10,
+	} {
+		for n := 0; n < 20; n++ {
+			d := b.Duration(attempt)
+			if d < max/2 || d > max {
+				t.Errorf("unexpected duration for attempt %d: %s not in [%s, %s]", attempt, d, max/2, max)
+			}
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package retry
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// vault paths of writes that mint a new token, certificate or signature, or
+// consume a single use token. Sending them twice has side effects.
+var nonIdempotentPaths = []string{
+	"/v1/auth/token/create",
+	"/login",
+	"/issue/",
+	"/sign/",
+	"/sign-verbatim",
+	"/v1/sys/wrapping/unwrap",
+	"/v1/sys/wrapping/wrap",
+}
+
+// errors of connections failing before the request was sent
+var notSentMessages = []string{
+	"connection refused",
+	"no route to host",
+	"no such host",
+}
+
+// Transport retries the requests of a vault client failing with a transient
+// error. It is the HTTP transport of every vault client, so that all calls
+// to vault are retried the same way.
+//
+// Requests which are not idempotent are only retried if vault can't have
+// processed them: the connection was refused, vault is sealed or a standby
+// (503) or the request was rate limited (429).
+type Transport struct {
+	base    http.RoundTripper
+	backoff *Backoff
+}
+
+var _ http.RoundTripper = &Transport{}
+
+func NewTransport(base http.RoundTripper, backoff *Backoff) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &Transport{
+		base:    base,
+		backoff: backoff,
+	}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	idempotent := IsIdempotent(req)
+	operation := req.Method + " " + req.URL.Path
+
+	r := req
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(r)
+
+		reason := retryReason(resp, err, idempotent)
+		if reason == "" || attempt >= t.backoff.maxRetries {
+			return resp, err
+		}
+
+		// a request body can only be sent again if it can be rewound
+		next, rewindErr := rewind(req)
+		if rewindErr != nil {
+			return resp, err
+		}
+
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		wait := t.backoff.Duration(attempt)
+		if t.backoff.Log != nil {
+			t.backoff.Log.Warnf("error during %s, retrying in %s (%d/%d): %s", operation, wait, attempt+1, t.backoff.maxRetries, reason)
+		}
+
+		t.backoff.sleep(wait)
+
+		r = next
+	}
+}
+
+// retryReason returns why the request should be retried, or an empty string
+// if the response or error is final
+func retryReason(resp *http.Response, err error, idempotent bool) string {
+	if err != nil {
+		if (idempotent && IsRetryable(err)) || notSent(err) {
+			return err.Error()
+		}
+		return ""
+	}
+
+	switch code := resp.StatusCode; {
+	case code == http.StatusServiceUnavailable, code == http.StatusTooManyRequests:
+		return fmt.Sprintf("vault responded %s", resp.Status)
+	case code >= 500 && idempotent:
+		return fmt.Sprintf("vault responded %s", resp.Status)
+	}
+
+	return ""
+}
+
+// IsIdempotent returns whether the request can be sent again after it may
+// have been processed by vault
+func IsIdempotent(req *http.Request) bool {
+	if req.Method != http.MethodPost && req.Method != http.MethodPut {
+		return true
+	}
+
+	for _, path := range nonIdempotentPaths {
+		if strings.Contains(req.URL.Path, path) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// notSent returns true if the connection failed before the request was sent
+func notSent(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok && opErr.Op == "dial" {
+		return true
+	}
+
+	for _, msg := range notSentMessages {
+		if strings.Contains(err.Error(), msg) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rewind returns a copy of the request with a fresh body
+func rewind(req *http.Request) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+	if req.GetBody == nil {
+		return nil, fmt.Errorf("request body of %s %s can't be rewound", req.Method, req.URL.Path)
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+
+	r := req.WithContext(req.Context())
+	r.Body = body
+
+	return r, nil
+}
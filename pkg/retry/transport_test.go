@@ -0,0 +1,129 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package retry
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// newTestClient returns a vault client retrying with the transport against a
+// server failing with the given status codes before succeeding
+func newTestClient(t *testing.T, codes []int, requests *[]map[string]interface{}) *vault.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := make(map[string]interface{})
+		if r.Body != nil {
+			json.NewDecoder(r.Body).Decode(&data)
+		}
+		*requests = append(*requests, data)
+
+		if n := len(*requests); n <= len(codes) {
+			w.WriteHeader(codes[n-1])
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{http.StatusText(codes[n-1])}})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{"ok": true},
+			"auth": map[string]interface{}{"client_token": "new-token"},
+		})
+	}))
+
+	var slept []time.Duration
+	transport := NewTransport(http.DefaultTransport, newTestBackoff(&slept))
+
+	v, err := vault.NewClient(&vault.Config{Address: server.URL, HttpClient: &http.Client{Transport: transport}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.SetMaxRetries(0)
+
+	return v
+}
+
+func TestTransport_Retry(t *testing.T) {
+	for _, r := range []struct {
+		name     string
+		path     string
+		codes    []int
+		requests int
+		fail     bool
+	}{
+		{"sealed", "secret/app", []int{503, 503}, 3, false},
+		{"server error", "secret/app", []int{500}, 2, false},
+		{"permission denied", "secret/app", []int{403}, 1, true},
+		{"give up", "secret/app", []int{500, 500, 500, 500}, 4, true},
+		{"create token sealed", "auth/token/create/worker", []int{503}, 2, false},
+		{"create token server error", "auth/token/create/worker", []int{500}, 1, true},
+		{"issue rate limited", "test-cluster/pki/k8s/issue/kubelet", []int{429}, 2, false},
+		{"issue server error", "test-cluster/pki/k8s/issue/kubelet", []int{502}, 1, true},
+		{"login server error", "auth/approle/login", []int{500}, 1, true},
+	} {
+		var requests []map[string]interface{}
+		v := newTestClient(t, r.codes, &requests)
+
+		_, err := v.Logical().Write(r.path, map[string]interface{}{"key": "value"})
+		if r.fail != (err != nil) {
+			t.Errorf("%s: unexpected error, expected failure=%t got: %v", r.name, r.fail, err)
+		}
+		if exp, act := r.requests, len(requests); exp != act {
+			t.Errorf("%s: unexpected number of requests exp=%d got=%d", r.name, exp, act)
+		}
+		// retried requests are sent with the same body
+		for _, data := range requests {
+			if exp, act := "value", data["key"]; exp != act {
+				t.Errorf("%s: unexpected request body exp=%s got=%v", r.name, exp, act)
+			}
+		}
+	}
+}
+
+func TestTransport_NotSent(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connect: connection refused")}
+	if retryReason(nil, refused, false) == "" {
+		t.Error("expected refused connection to be retried for non idempotent request")
+	}
+
+	timeout := errors.New("read tcp 127.0.0.1:8200: i/o timeout")
+	if retryReason(nil, timeout, false) != "" {
+		t.Error("unexpected retry of non idempotent request after timeout")
+	}
+	if retryReason(nil, timeout, true) == "" {
+		t.Error("expected idempotent request to be retried after timeout")
+	}
+}
+
+func TestTransport_IsIdempotent(t *testing.T) {
+	for _, r := range []struct {
+		method string
+		path   string
+		exp    bool
+	}{
+		{"GET", "/v1/test-cluster/pki/k8s/cert/ca", true},
+		{"LIST", "/v1/test-cluster/pki/k8s/certs", true},
+		{"PUT", "/v1/sys/policy/test-cluster/worker", true},
+		{"PUT", "/v1/auth/token/renew-self", true},
+		{"PUT", "/v1/test-cluster/transit/encrypt/kube-secrets", true},
+		{"PUT", "/v1/auth/token/create/test-cluster-worker", false},
+		{"POST", "/v1/auth/token/create-orphan", false},
+		{"PUT", "/v1/auth/approle/login", false},
+		{"PUT", "/v1/test-cluster/pki/k8s/sign/kubelet", false},
+		{"PUT", "/v1/test-cluster/pki/k8s/issue/kubelet", false},
+		{"PUT", "/v1/test-cluster/ssh/host/sign/worker", false},
+		{"PUT", "/v1/sys/wrapping/unwrap", false},
+	} {
+		req, err := http.NewRequest(r.method, "http://127.0.0.1:8200"+r.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if act := IsIdempotent(req); r.exp != act {
+			t.Errorf("unexpected idempotent for %s %s exp=%t got=%t", r.method, r.path, r.exp, act)
+		}
+	}
+}
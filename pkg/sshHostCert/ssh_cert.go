@@ -0,0 +1,226 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package sshHostCert
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+const certTypeSuffix = "-cert-v01@openssh.com"
+
+// certTypeHost is the type of host certificates (SSH2_CERT_TYPE_HOST)
+const certTypeHost = 2
+
+// number of string encoded fields of the public key, by key type
+var keyFields = map[string]int{
+	"ssh-rsa":                            2,
+	"ssh-dss":                            4,
+	"ecdsa-sha2-nistp256":                2,
+	"ecdsa-sha2-nistp384":                2,
+	"ecdsa-sha2-nistp521":                2,
+	"ssh-ed25519":                        1,
+	"sk-ecdsa-sha2-nistp256@openssh.com": 3,
+	"sk-ssh-ed25519@openssh.com":         2,
+}
+
+// hostCert holds the fields of an OpenSSH certificate needed to decide
+// whether it has to be renewed
+type hostCert struct {
+	keyType     string
+	key         []byte
+	certType    uint32
+	principals  []string
+	validAfter  time.Time
+	validBefore time.Time
+}
+
+// renewalReason returns why the certificate needs to be renewed, or an empty
+// string if it is a host certificate of the key, valid for exactly the
+// principals, and more than a third of its validity remains
+func (c *hostCert) renewalReason(keyType string, key []byte, principals []string, now time.Time) string {
+	if c.keyType != keyType || !bytes.Equal(c.key, key) {
+		return "host certificate doesn't match the host key"
+	}
+	if c.certType != certTypeHost {
+		return "certificate is not a host certificate"
+	}
+	if !equalPrincipals(c.principals, principals) {
+		return fmt.Sprintf("principals changed from '%s' to '%s'", strings.Join(c.principals, ","), strings.Join(principals, ","))
+	}
+	if now.Before(c.validAfter) {
+		return "host certificate is not valid yet"
+	}
+	if c.validBefore.IsZero() {
+		return ""
+	}
+	if renewAt := c.validBefore.Add(-c.validBefore.Sub(c.validAfter) / 3); !now.Before(renewAt) {
+		return fmt.Sprintf("host certificate expires at %s", c.validBefore.Format(time.RFC3339))
+	}
+
+	return ""
+}
+
+// parseHostCert parses an OpenSSH certificate in authorized_keys format
+func parseHostCert(b []byte) (*hostCert, error) {
+	certType, blob, err := parseAuthorizedKey(b)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(certType, certTypeSuffix) {
+		return nil, fmt.Errorf("'%s' is not a certificate type", certType)
+	}
+
+	r := &wireReader{b: blob}
+	if name := string(r.string()); name != certType {
+		return nil, fmt.Errorf("certificate type '%s' doesn't match '%s'", name, certType)
+	}
+	// nonce
+	r.string()
+
+	c := &hostCert{keyType: strings.TrimSuffix(certType, certTypeSuffix)}
+	fields, ok := keyFields[c.keyType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type '%s'", c.keyType)
+	}
+	start := r.offset()
+	for i := 0; i < fields; i++ {
+		r.string()
+	}
+	if r.err == nil {
+		c.key = blob[start:r.offset()]
+	}
+
+	// serial
+	r.uint64()
+	c.certType = r.uint32()
+	// key id
+	r.string()
+
+	principals := &wireReader{b: r.string()}
+	for r.err == nil && principals.err == nil && len(principals.b) > 0 {
+		c.principals = append(c.principals, string(principals.string()))
+	}
+
+	validAfter, validBefore := r.uint64(), r.uint64()
+	if r.err != nil {
+		return nil, r.err
+	}
+	if principals.err != nil {
+		return nil, principals.err
+	}
+	c.validAfter = time.Unix(int64(validAfter), 0)
+	// certificates valid forever have no expiry
+	if validBefore != math.MaxUint64 {
+		c.validBefore = time.Unix(int64(validBefore), 0)
+	}
+
+	return c, nil
+}
+
+// parsePublicKey parses a public key in authorized_keys format, returning its
+// type and the encoded key fields as embedded in certificates
+func parsePublicKey(b []byte) (string, []byte, error) {
+	keyType, blob, err := parseAuthorizedKey(b)
+	if err != nil {
+		return "", nil, err
+	}
+
+	r := &wireReader{b: blob}
+	if name := string(r.string()); r.err != nil || name != keyType {
+		return "", nil, fmt.Errorf("key type '%s' doesn't match '%s'", name, keyType)
+	}
+
+	return keyType, r.b, nil
+}
+
+func parseAuthorizedKey(b []byte) (string, []byte, error) {
+	fields := strings.Fields(string(b))
+	if len(fields) < 2 {
+		return "", nil, errors.New("expected key type and base64 encoded key")
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to decode key: %v", err)
+	}
+
+	return fields[0], blob, nil
+}
+
+func equalPrincipals(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	set := make(map[string]bool)
+	for _, p := range a {
+		set[p] = true
+	}
+	for _, p := range b {
+		if !set[p] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// wireReader reads the SSH wire encoding (RFC 4251), keeping the first error
+type wireReader struct {
+	b    []byte
+	read int
+	err  error
+}
+
+var errShortData = errors.New("certificate data too short")
+
+func (r *wireReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.b) < n {
+		r.err = errShortData
+		return nil
+	}
+
+	b := r.b[:n]
+	r.b = r.b[n:]
+	r.read += n
+
+	return b
+}
+
+func (r *wireReader) offset() int {
+	return r.read
+}
+
+func (r *wireReader) uint32() uint32 {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *wireReader) uint64() uint64 {
+	b := r.next(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (r *wireReader) string() []byte {
+	n := r.uint32()
+	if n > uint32(len(r.b)) {
+		r.err = errShortData
+		return nil
+	}
+	return r.next(int(n))
+}
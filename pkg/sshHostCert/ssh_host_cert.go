@@ -0,0 +1,224 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package sshHostCert
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/jetstack/vault-helper/pkg/instanceToken"
+)
+
+const FlagHostKeyDir = "host-key-dir"
+const FlagPrincipals = "principals"
+
+// host public keys are matched by this pattern inside the host key directory
+const hostKeyPattern = "ssh_host_*_key.pub"
+
+// replaced in tests
+var (
+	hostname    = os.Hostname
+	lookupCNAME = net.LookupCNAME
+)
+
+type SSHHostCert struct {
+	signPath   string
+	hostKeyDir string
+	principals []string
+
+	Log           *logrus.Entry
+	instanceToken *instanceToken.InstanceToken
+}
+
+func New(logger *logrus.Entry, i *instanceToken.InstanceToken) *SSHHostCert {
+	s := &SSHHostCert{
+		hostKeyDir:    "/etc/ssh",
+		instanceToken: i,
+	}
+
+	if logger != nil {
+		s.Log = logger
+	}
+
+	return s
+}
+
+func (s *SSHHostCert) RunSSHHostCert() error {
+	keys, err := s.HostKeys()
+	if err != nil {
+		return err
+	}
+
+	principals := s.Principals()
+	if len(principals) == 0 {
+		name, err := fqdn()
+		if err != nil {
+			return fmt.Errorf("error getting FQDN for principals, set them with --%s: %v", FlagPrincipals, err)
+		}
+		principals = []string{name}
+	}
+
+	now := time.Now()
+	for _, key := range keys {
+		reason, err := renewalReason(key, principals, now)
+		if err != nil {
+			return err
+		}
+		if reason == "" {
+			s.Log.Infof("Host certificate still valid: %s", CertPath(key))
+			continue
+		}
+		s.Log.Infof("Signing host key '%s': %s", key, reason)
+
+		if err := s.signHostKey(key, principals); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fqdn returns the fully qualified hostname, as matched by the allowed
+// domains of the host roles
+func fqdn() (string, error) {
+	name, err := hostname()
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(name, ".") {
+		return name, nil
+	}
+
+	cname, err := lookupCNAME(name)
+	if err != nil {
+		return "", fmt.Errorf("error resolving hostname '%s': %v", name, err)
+	}
+	cname = strings.ToLower(strings.TrimSuffix(cname, "."))
+	if !strings.Contains(cname, ".") {
+		return "", fmt.Errorf("hostname '%s' doesn't resolve to a FQDN", name)
+	}
+
+	return cname, nil
+}
+
+// renewalReason returns why the host key needs to be signed, or an empty
+// string if its certificate is valid for the principals and not due for
+// renewal
+func renewalReason(keyPath string, principals []string, now time.Time) (string, error) {
+	b, err := ioutil.ReadFile(CertPath(keyPath))
+	if os.IsNotExist(err) {
+		return "no host certificate exists", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading host certificate '%s': %v", CertPath(keyPath), err)
+	}
+
+	cert, err := parseHostCert(b)
+	if err != nil {
+		return fmt.Sprintf("failed to parse host certificate: %v", err), nil
+	}
+
+	publicKey, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return "", fmt.Errorf("error reading host key '%s': %v", keyPath, err)
+	}
+	keyType, key, err := parsePublicKey(publicKey)
+	if err != nil {
+		return "", fmt.Errorf("error parsing host key '%s': %v", keyPath, err)
+	}
+
+	return cert.renewalReason(keyType, key, principals, now), nil
+}
+
+// HostKeys returns the public host keys found in the host key directory
+func (s *SSHHostCert) HostKeys() ([]string, error) {
+	keys, err := filepath.Glob(filepath.Join(s.HostKeyDir(), hostKeyPattern))
+	if err != nil {
+		return nil, fmt.Errorf("error searching for host keys in '%s': %v", s.HostKeyDir(), err)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no host keys found matching '%s'", filepath.Join(s.HostKeyDir(), hostKeyPattern))
+	}
+
+	return keys, nil
+}
+
+func (s *SSHHostCert) signHostKey(keyPath string, principals []string) error {
+	publicKey, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return fmt.Errorf("error reading host key '%s': %v", keyPath, err)
+	}
+
+	data := map[string]interface{}{
+		"public_key":       string(publicKey),
+		"cert_type":        "host",
+		"valid_principals": strings.Join(principals, ","),
+	}
+
+	sec, err := s.InstanceToken().VaultClient().Logical().Write(s.SignPath(), data)
+	if err != nil {
+		return fmt.Errorf("error signing host key '%s': %v", keyPath, err)
+	}
+	if sec == nil {
+		return errors.New("vault returned nothing")
+	}
+
+	signedKey, ok := sec.Data["signed_key"].(string)
+	if !ok || signedKey == "" {
+		return fmt.Errorf("no signed key in response signing host key '%s'", keyPath)
+	}
+
+	path := CertPath(keyPath)
+	if err := ioutil.WriteFile(path, []byte(signedKey), 0644); err != nil {
+		return fmt.Errorf("error writing host certificate '%s': %v", path, err)
+	}
+	if err := os.Chmod(path, 0644); err != nil {
+		return fmt.Errorf("failed to change permissons of file '%s' to 0644: %v", path, err)
+	}
+
+	s.Log.Infof("Host certificate written to: %s", path)
+
+	return nil
+}
+
+// CertPath returns the path of the certificate for a public host key, as
+// expected by sshd (ssh_host_rsa_key.pub -> ssh_host_rsa_key-cert.pub)
+func CertPath(keyPath string) string {
+	return strings.TrimSuffix(keyPath, ".pub") + "-cert.pub"
+}
+
+func (s *SSHHostCert) SetSignPath(path string) {
+	s.signPath = path
+}
+func (s *SSHHostCert) SignPath() string {
+	return s.signPath
+}
+
+func (s *SSHHostCert) SetHostKeyDir(dir string) {
+	s.hostKeyDir = dir
+}
+func (s *SSHHostCert) HostKeyDir() string {
+	return s.hostKeyDir
+}
+
+func (s *SSHHostCert) SetPrincipals(principals []string) {
+	s.principals = principals
+}
+func (s *SSHHostCert) Principals() []string {
+	return s.principals
+}
+
+func (s *SSHHostCert) SetInstanceToken(i *instanceToken.InstanceToken) {
+	s.instanceToken = i
+}
+func (s *SSHHostCert) InstanceToken() *instanceToken.InstanceToken {
+	return s.instanceToken
+}
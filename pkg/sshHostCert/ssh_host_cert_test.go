@@ -0,0 +1,244 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package sshHostCert
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+
+	"github.com/jetstack/vault-helper/pkg/instanceToken"
+)
+
+func TestSSHHostCert_Run(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-helper-ssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"ssh_host_rsa_key.pub", "ssh_host_ed25519_key.pub", "other.pub"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("ssh-rsa AAAA "+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var requests []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if exp, act := "/v1/test-cluster/ssh/host/sign/worker", r.URL.Path; exp != act {
+			t.Errorf("unexpected sign path exp=%s got=%s", exp, act)
+		}
+
+		data := make(map[string]interface{})
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			t.Errorf("error decoding request: %v", err)
+		}
+		requests = append(requests, data)
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"signed_key": "signed " + data["public_key"].(string),
+			},
+		})
+	}))
+	defer server.Close()
+
+	v, err := vault.NewClient(&vault.Config{Address: server.URL, HttpClient: http.DefaultClient})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := logrus.NewEntry(logrus.New())
+	s := New(log, instanceToken.New(v, log))
+	s.SetSignPath("test-cluster/ssh/host/sign/worker")
+	s.SetHostKeyDir(dir)
+	s.SetPrincipals([]string{"node1", "node1.compute.internal"})
+
+	if err := s.RunSSHHostCert(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if exp, act := 2, len(requests); exp != act {
+		t.Fatalf("unexpected number of sign requests exp=%d got=%d", exp, act)
+	}
+	for _, req := range requests {
+		if exp, act := "node1,node1.compute.internal", req["valid_principals"]; exp != act {
+			t.Errorf("unexpected principals exp=%s got=%s", exp, act)
+		}
+		if exp, act := "host", req["cert_type"]; exp != act {
+			t.Errorf("unexpected cert type exp=%s got=%s", exp, act)
+		}
+	}
+
+	for _, name := range []string{"ssh_host_rsa_key", "ssh_host_ed25519_key"} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name+"-cert.pub"))
+		if err != nil {
+			t.Errorf("expected host certificate: %v", err)
+			continue
+		}
+		if exp, act := "signed ssh-rsa AAAA "+name+".pub", string(b); exp != act {
+			t.Errorf("unexpected host certificate exp=%s got=%s", exp, act)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "other-cert.pub")); !os.IsNotExist(err) {
+		t.Error("unexpected certificate for non host key")
+	}
+}
+
+func TestSSHHostCert_NoKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-helper-ssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := New(logrus.NewEntry(logrus.New()), nil)
+	s.SetHostKeyDir(dir)
+
+	if err := s.RunSSHHostCert(); err == nil {
+		t.Error("expected error with no host keys")
+	}
+}
+
+func wireString(b []byte) []byte {
+	var l [4]byte
+	binary.BigEndian.PutUint32(l[:], uint32(len(b)))
+	return append(l[:], b...)
+}
+
+func wireUint64(v uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	return b[:]
+}
+
+func testPublicKey(seed byte) string {
+	key := bytes.Repeat([]byte{seed}, 32)
+	blob := append(wireString([]byte("ssh-ed25519")), wireString(key)...)
+	return "ssh-ed25519 " + base64.StdEncoding.EncodeToString(blob) + " root@node1"
+}
+
+func testHostCert(seed byte, certType uint32, principals []string, validAfter, validBefore uint64) string {
+	var packed []byte
+	for _, p := range principals {
+		packed = append(packed, wireString([]byte(p))...)
+	}
+
+	var ct [4]byte
+	binary.BigEndian.PutUint32(ct[:], certType)
+
+	var blob []byte
+	for _, field := range [][]byte{
+		wireString([]byte("ssh-ed25519-cert-v01@openssh.com")),
+		wireString([]byte("nonce")),
+		wireString(bytes.Repeat([]byte{seed}, 32)),
+		wireUint64(1),
+		ct[:],
+		wireString([]byte("node1")),
+		wireString(packed),
+		wireUint64(validAfter),
+		wireUint64(validBefore),
+		wireString(nil),
+		wireString(nil),
+		wireString(nil),
+		wireString([]byte("signature key")),
+		wireString([]byte("signature")),
+	} {
+		blob = append(blob, field...)
+	}
+
+	return "ssh-ed25519-cert-v01@openssh.com " + base64.StdEncoding.EncodeToString(blob)
+}
+
+func TestSSHHostCert_RenewalReason(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-helper-ssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyPath := filepath.Join(dir, "ssh_host_ed25519_key.pub")
+	if err := ioutil.WriteFile(keyPath, []byte(testPublicKey(1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	start, end := uint64(now.Add(-time.Hour).Unix()), uint64(now.Add(time.Hour*71).Unix())
+	principals := []string{"node1.compute.internal"}
+
+	reason, err := renewalReason(keyPath, principals, now)
+	if err != nil || reason != "no host certificate exists" {
+		t.Errorf("expected missing certificate as reason, got: %s %v", reason, err)
+	}
+
+	for _, r := range []struct {
+		name  string
+		cert  string
+		renew bool
+	}{
+		{"valid", testHostCert(1, certTypeHost, principals, start, end), false},
+		{"valid forever", testHostCert(1, certTypeHost, principals, start, math.MaxUint64), false},
+		{"other key", testHostCert(2, certTypeHost, principals, start, end), true},
+		{"user certificate", testHostCert(1, 1, principals, start, end), true},
+		{"principals changed", testHostCert(1, certTypeHost, []string{"node1"}, start, end), true},
+		{"extra principal", testHostCert(1, certTypeHost, []string{"node1", "node1.compute.internal"}, start, end), true},
+		{"not yet valid", testHostCert(1, certTypeHost, principals, end, end+3600), true},
+		{"expiring", testHostCert(1, certTypeHost, principals, uint64(now.Add(-time.Hour*71).Unix()), start+7200), true},
+		{"invalid", "ssh-ed25519-cert-v01@openssh.com AAAA", true},
+	} {
+		if err := ioutil.WriteFile(CertPath(keyPath), []byte(r.cert), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		reason, err := renewalReason(keyPath, principals, now)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", r.name, err)
+		}
+		if r.renew != (reason != "") {
+			t.Errorf("%s: unexpected renewal, expected renew=%t got reason: '%s'", r.name, r.renew, reason)
+		}
+	}
+}
+
+func TestSSHHostCert_FQDN(t *testing.T) {
+	defer func(h func() (string, error), l func(string) (string, error)) {
+		hostname, lookupCNAME = h, l
+	}(hostname, lookupCNAME)
+
+	lookupCNAME = func(name string) (string, error) {
+		return name + ".compute.internal.", nil
+	}
+
+	for name, exp := range map[string]string{
+		"node1":              "node1.compute.internal",
+		"node1.ec2.internal": "node1.ec2.internal",
+		"NODE1":              "node1.compute.internal",
+	} {
+		hostname = func() (string, error) { return name, nil }
+		act, err := fqdn()
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", name, err)
+		}
+		if exp != act {
+			t.Errorf("unexpected FQDN exp=%s got=%s", exp, act)
+		}
+	}
+
+	hostname = func() (string, error) { return "node1", nil }
+	lookupCNAME = func(name string) (string, error) { return name + ".", nil }
+	if _, err := fqdn(); err == nil {
+		t.Error("expected error for hostname without FQDN")
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package vaultVersion
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// MinEntityAliases is the first vault version supporting entity aliases of
+// token roles (allowed_entity_aliases, entity_alias) and identity templates
+// in the allowed domains of PKI roles (allowed_domains_template). Older
+// versions ignore these fields, so the kubelet restriction wouldn't apply.
+const MinEntityAliases = "1.6.0"
+
+// Health reads the health of vault, which includes its version
+type Health interface {
+	Health() (*vault.HealthResponse, error)
+}
+
+// Require returns an error if the version of vault is older than min. The
+// feature names what needs the version in the error.
+func Require(sys Health, min, feature string) error {
+	health, err := sys.Health()
+	if err != nil {
+		return fmt.Errorf("error reading vault version: %v", err)
+	}
+	if health == nil || health.Version == "" {
+		return fmt.Errorf("%s requires vault %s or later, vault didn't report its version", feature, min)
+	}
+
+	ok, err := AtLeast(health.Version, min)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s requires vault %s or later, vault is %s", feature, min, health.Version)
+	}
+
+	return nil
+}
+
+// AtLeast returns whether version is min or later. Suffixes like +ent or
+// -beta1 are ignored.
+func AtLeast(version, min string) (bool, error) {
+	v, err := parse(version)
+	if err != nil {
+		return false, err
+	}
+	m, err := parse(min)
+	if err != nil {
+		return false, err
+	}
+
+	for n := range v {
+		if v[n] != m[n] {
+			return v[n] > m[n], nil
+		}
+	}
+
+	return true, nil
+}
+
+func parse(version string) ([3]int, error) {
+	var v [3]int
+
+	s := strings.TrimPrefix(version, "v")
+	if i := strings.IndexAny(s, "+-"); i >= 0 {
+		s = s[:i]
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) != len(v) {
+		return v, fmt.Errorf("invalid vault version '%s'", version)
+	}
+	for n, p := range parts {
+		i, err := strconv.Atoi(p)
+		if err != nil || i < 0 {
+			return v, fmt.Errorf("invalid vault version '%s'", version)
+		}
+		v[n] = i
+	}
+
+	return v, nil
+}
@@ -0,0 +1,65 @@
+// Copyright Jetstack Ltd. See LICENSE for details.
+package vaultVersion
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+type fakeHealth struct {
+	version string
+	err     error
+}
+
+func (f *fakeHealth) Health() (*vault.HealthResponse, error) {
+	return &vault.HealthResponse{Version: f.version}, f.err
+}
+
+func TestVaultVersion_AtLeast(t *testing.T) {
+	for _, r := range []struct {
+		version string
+		exp     bool
+	}{
+		{"0.9.6", false},
+		{"1.5.9", false},
+		{"1.6.0", true},
+		{"1.6.0-beta1", true},
+		{"v1.10.3", true},
+		{"1.13.1+ent", true},
+		{"2.0.0", true},
+	} {
+		act, err := AtLeast(r.version, MinEntityAliases)
+		if err != nil {
+			t.Errorf("unexpected error for version '%s': %v", r.version, err)
+		}
+		if r.exp != act {
+			t.Errorf("unexpected result for version '%s' exp=%t got=%t", r.version, r.exp, act)
+		}
+	}
+
+	for _, version := range []string{"", "1.6", "1.x.0", "1.6.0.1"} {
+		if _, err := AtLeast(version, MinEntityAliases); err == nil {
+			t.Errorf("expected error for version '%s'", version)
+		}
+	}
+}
+
+func TestVaultVersion_Require(t *testing.T) {
+	if err := Require(&fakeHealth{version: "1.6.2"}, MinEntityAliases, "--restrict-kubelet"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err := Require(&fakeHealth{version: "0.9.6"}, MinEntityAliases, "--restrict-kubelet")
+	if err == nil || !strings.Contains(err.Error(), "requires vault 1.6.0 or later, vault is 0.9.6") {
+		t.Errorf("expected error for old vault version, got: %v", err)
+	}
+
+	for _, h := range []*fakeHealth{{version: ""}, {err: errors.New("connection refused")}} {
+		if err := Require(h, MinEntityAliases, "--restrict-kubelet"); err == nil {
+			t.Errorf("expected error for health %+v", h)
+		}
+	}
+}